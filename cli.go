@@ -0,0 +1,874 @@
+package main
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	// community
+	"github.com/fatih/color"
+
+	// terramorph
+	"github.com/h0tbird/terramorph/pkg/event"
+	"github.com/h0tbird/terramorph/pkg/logger"
+	"github.com/h0tbird/terramorph/pkg/manifest"
+	"github.com/h0tbird/terramorph/pkg/provider"
+	"github.com/h0tbird/terramorph/pkg/resource"
+	"github.com/h0tbird/terramorph/pkg/state"
+	"github.com/h0tbird/terramorph/pkg/tfd"
+)
+
+//-----------------------------------------------------------------------------
+// Globals
+//-----------------------------------------------------------------------------
+
+const usage = `usage: terramorph <command> [options] [args]
+
+commands:
+  apply      create or update the resources of the manifest
+  plan       show the changes apply would make
+  destroy    delete the resources of the manifest
+  validate   check the manifest against the provider schemas
+  graph      print the dependencies between resources as DOT or JSON
+  import     adopt an existing resource: import <resource> <id>
+  output     print the outputs of the manifest
+  state      inspect and edit the state: state <subcommand> [args]
+  schema     inspect resource and data source types
+
+Run terramorph <command> -h for the options of a command.`
+
+// stackReg is what a stack name can be made of. Names made of dots alone or
+// containing ".." are rejected as well, they would point outside the stacks.
+var stackReg = regexp.MustCompile(`^[\w.-]+$`)
+
+//-----------------------------------------------------------------------------
+// Types
+//-----------------------------------------------------------------------------
+
+// cli runs the commands. Everything it touches outside the manifest is here
+// so tests can run it against the mock provider and a temporary home.
+type cli struct {
+	in      io.Reader
+	out     io.Writer
+	err     io.Writer
+	reg     *provider.Registry
+	home    string
+	env     map[string]interface{}
+	signals <-chan os.Signal
+	stop    chan struct{}
+	l       sync.Mutex
+	m       *manifest.Handler
+	files   []io.Closer
+}
+
+// options are the flags shared by the commands
+type options struct {
+	manifest    string
+	varFiles    stringList
+	vars        stringList
+	targets     stringList
+	stack       string
+	parallelism int
+	autoApprove bool
+	noColor     bool
+	format      string
+	events      string
+	logLevel    string
+	logFormat   string
+	logProvider bool
+	onFailure   string
+	plan        bool
+}
+
+// writers serializes the writes of a command and of the interrupt handling,
+// and drops the ones of a command left running by an abort
+type writers struct {
+	l      sync.Mutex
+	closed bool
+}
+
+// syncWriter is an io.Writer guarded by writers
+type syncWriter struct {
+	ws *writers
+	w  io.Writer
+}
+
+// stringList is a repeatable flag
+type stringList []string
+
+// diagnostic is the JSON form of a tfd.Diagnostic
+type diagnostic struct {
+	Severity string `json:"severity"`
+	Summary  string `json:"summary"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+//-----------------------------------------------------------------------------
+// Methods
+//-----------------------------------------------------------------------------
+
+// run dispatches a command line, without the program name. The first signal
+// stops the command once the resources in flight are done, the second one
+// aborts it at once.
+func (c *cli) run(ctx context.Context, args []string) error {
+
+	ctx, abort := context.WithCancel(ctx)
+	defer abort()
+
+	// The command runs on its own cli, with writers that go quiet on abort
+	w := &writers{}
+	cmd := &cli{
+		in:   c.in,
+		out:  w.wrap(c.out),
+		err:  w.wrap(c.err),
+		reg:  c.reg,
+		home: c.home,
+		env:  c.env,
+		stop: make(chan struct{}),
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		err := cmd.dispatch(ctx, args)
+		for _, f := range cmd.files {
+			f.Close()
+		}
+		done <- err
+	}()
+
+	for stopping := false; ; {
+		select {
+		case err := <-done:
+			if err == flag.ErrHelp {
+				return nil
+			}
+			return err
+		case <-c.signals:
+			if !stopping {
+				stopping = true
+				close(cmd.stop)
+				fmt.Fprintln(cmd.err, "Interrupt received, waiting for the resources in flight to finish. Interrupt again to abort.")
+				continue
+			}
+			abort()
+			err := cmd.aborted()
+			w.close()
+			return err
+		}
+	}
+}
+
+func (c *cli) dispatch(ctx context.Context, args []string) error {
+
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "apply":
+		return c.apply(ctx, args)
+	case "plan":
+		return c.plan(ctx, args)
+	case "destroy":
+		return c.destroy(ctx, args)
+	case "validate":
+		return c.validate(ctx, args)
+	case "graph":
+		return c.graph(ctx, args)
+	case "import":
+		return c.importCmd(ctx, args)
+	case "output":
+		return c.output(args)
+	case "state":
+		return c.state(args)
+	case "schema":
+		return schemaCmd(c.out, c.reg, args)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprintln(c.out, usage)
+		return nil
+	}
+
+	return fmt.Errorf("unknown command %q\n\n%s", cmd, usage)
+}
+
+func (c *cli) apply(ctx context.Context, args []string) error {
+
+	o, _, err := c.parse("apply", args, 0, "")
+	if err != nil {
+		return err
+	}
+
+	m, s, err := c.open(ctx, o)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	// Nothing is changed before the plan is approved
+	changes, diags := m.Plan(ctx, s)
+	if diags.HasErrors() {
+		return c.report(o, "apply", nil, diags)
+	}
+	if !c.approve(o, changes) {
+		return errors.New("apply cancelled, nothing was changed")
+	}
+
+	// Resources whose change is no longer the approved one are not changed
+	diags = m.ApplyPlan(ctx, s, changes)
+	if o.format == "json" {
+		return c.report(o, "apply", map[string]interface{}{"changes": changes, "summary": m.Summary()}, diags)
+	}
+
+	writeSummary(c.out, m.Summary())
+	return c.report(o, "apply", nil, diags)
+}
+
+func (c *cli) plan(ctx context.Context, args []string) error {
+
+	o, _, err := c.parse("plan", args, 0, "")
+	if err != nil {
+		return err
+	}
+
+	m, s, err := c.open(ctx, o)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	changes, diags := m.Plan(ctx, s)
+	if o.format == "json" {
+		return c.report(o, "plan", map[string]interface{}{"changes": changes}, diags)
+	}
+
+	if !diags.HasErrors() {
+		writePlan(c.out, changes)
+	}
+
+	return c.report(o, "plan", nil, diags)
+}
+
+func (c *cli) destroy(ctx context.Context, args []string) error {
+
+	o, _, err := c.parse("destroy", args, 0, "")
+	if err != nil {
+		return err
+	}
+
+	m, s, err := c.open(ctx, o)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	changes, err := m.PlanDestroy(s)
+	if err != nil {
+		return err
+	}
+	if !c.approve(o, changes) {
+		return errors.New("destroy cancelled, nothing was changed")
+	}
+
+	diags := m.Destroy(ctx, s)
+	if o.format == "json" {
+		return c.report(o, "destroy", map[string]interface{}{"changes": changes, "summary": m.Summary()}, diags)
+	}
+
+	writeSummary(c.out, m.Summary())
+	return c.report(o, "destroy", nil, diags)
+}
+
+func (c *cli) validate(ctx context.Context, args []string) error {
+
+	o, _, err := c.parse("validate", args, 0, "")
+	if err != nil {
+		return err
+	}
+
+	m, err := c.load(o)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	diags := m.ConfigureProviders(ctx, c.reg)
+	if !diags.HasErrors() {
+		diags = diags.Append(m.Validate(ctx))
+	}
+
+	if o.format == "json" {
+		return c.report(o, "validate", map[string]interface{}{"valid": !diags.HasErrors()}, diags)
+	}
+	if !diags.HasErrors() {
+		fmt.Fprintln(c.out, "The manifest is valid.")
+	}
+
+	return c.report(o, "validate", nil, diags)
+}
+
+func (c *cli) graph(ctx context.Context, args []string) error {
+
+	o, _, err := c.parse("graph", args, 0, "")
+	if err != nil {
+		return err
+	}
+
+	m, err := c.load(o)
+	if err != nil {
+		return err
+	}
+
+	// Broken references and cycles are reported after the graph
+	_, diags := m.Graph()
+
+	if o.format == "json" {
+		if diags.HasErrors() {
+			return c.report(o, "graph", nil, diags)
+		}
+		b, err := m.GraphJSON()
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(c.out, "%s\n", b)
+		return err
+	}
+
+	// DOT, colored by the planned actions with -plan
+	var changes []*resource.Change
+	if o.plan && !diags.HasErrors() {
+		if err := c.configure(ctx, o, m); err != nil {
+			return err
+		}
+		defer m.Close()
+
+		s, err := c.openState(o)
+		if err != nil {
+			return err
+		}
+
+		var planDiags tfd.Diagnostics
+		changes, planDiags = m.Plan(ctx, s)
+		if planDiags.HasErrors() {
+			return c.report(o, "graph", nil, planDiags)
+		}
+	}
+
+	if _, err := c.out.Write(m.Dot(changes)); err != nil {
+		return err
+	}
+
+	return c.report(o, "graph", nil, diags)
+}
+
+func (c *cli) importCmd(ctx context.Context, args []string) error {
+
+	o, rest, err := c.parse("import", args, 2, "<resource> <id>")
+	if err != nil {
+		return err
+	}
+
+	m, s, err := c.open(ctx, o)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	diags := m.Import(ctx, s, rest[0], rest[1])
+	if o.format != "json" && !diags.HasErrors() {
+		fmt.Fprintf(c.out, "imported %s\n", rest[0])
+	}
+
+	return c.report(o, "import", nil, diags)
+}
+
+func (c *cli) output(args []string) error {
+
+	o, rest, err := c.parse("output", args, -1, "[name]")
+	if err != nil {
+		return err
+	}
+	if len(rest) > 1 {
+		return fmt.Errorf("usage: terramorph output [options] [name]")
+	}
+
+	m, err := c.load(o)
+	if err != nil {
+		return err
+	}
+
+	s, err := c.openState(o)
+	if err != nil {
+		return err
+	}
+
+	values, err := m.OutputValues(s)
+	if err != nil {
+		return err
+	}
+
+	// A single output is printed raw, for scripts
+	if len(rest) == 1 {
+		v, ok := values[rest[0]]
+		if !ok {
+			return fmt.Errorf("output %q has no value", rest[0])
+		}
+		if o.format == "json" {
+			return writeJSON(c.out, v)
+		}
+		fmt.Fprintln(c.out, v)
+		return nil
+	}
+
+	if o.format == "json" {
+		return writeJSON(c.out, values)
+	}
+
+	names := []string{}
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(c.out, "%s = %q\n", name, values[name])
+	}
+
+	return nil
+}
+
+func (c *cli) state(args []string) error {
+
+	o, rest, err := c.parse("state", args, -1, "<subcommand> [args]")
+	if err != nil {
+		return err
+	}
+
+	m, err := c.load(o)
+	if err != nil {
+		return err
+	}
+
+	s, err := c.openState(o)
+	if err != nil {
+		return err
+	}
+
+	return stateCmd(c.out, c.err, s, m, c.reg, rest)
+}
+
+// parse parses the options of a command. A nargs of -1 takes any number of
+// arguments, otherwise exactly nargs are expected.
+func (c *cli) parse(cmd string, args []string, nargs int, argsUsage string) (*options, []string, error) {
+
+	o := &options{}
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fs.SetOutput(c.err)
+	fs.StringVar(&o.manifest, "manifest", "", "path to a YAML or JSON manifest, the IAM bootstrap manifest if empty")
+	fs.Var(&o.varFiles, "var-file", "YAML or JSON file of variables, repeatable")
+	fs.Var(&o.vars, "var", "variable as name=value, repeatable")
+	fs.Var(&o.targets, "target", "logical ID of a resource to limit the walk to, with its dependencies on apply and its dependents on destroy, repeatable")
+	fs.StringVar(&o.stack, "stack", "default", "name of the stack the state belongs to")
+	fs.IntVar(&o.parallelism, "parallelism", 1, "number of resources handled at a time")
+	fs.BoolVar(&o.autoApprove, "auto-approve", false, "skip the confirmation of apply and destroy")
+	fs.BoolVar(&o.noColor, "no-color", false, "do not color the output")
+	fs.StringVar(&o.format, "format", "text", "output format: text or json")
+	fs.StringVar(&o.events, "events", "", "file to write the progress events to as newline-delimited JSON")
+	fs.StringVar(&o.logLevel, "log-level", "warn", "level of the log written to stderr: debug, info, warn or error")
+	fs.StringVar(&o.logFormat, "log-format", logger.Console, "encoding of the log: console or json")
+	fs.BoolVar(&o.logProvider, "log-provider", false, "log what the provider SDK writes at debug level instead of discarding it")
+	fs.StringVar(&o.onFailure, "on-failure", string(manifest.FailFast), "what a failed resource does to the rest: fail-fast, continue or continue-independent")
+	if cmd == "graph" {
+		fs.BoolVar(&o.plan, "plan", false, "color the resources by the action planned for them")
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(c.err, "usage: terramorph %s [options] %s\n\noptions:\n", cmd, argsUsage)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if nargs >= 0 && fs.NArg() != nargs {
+		fs.Usage()
+		return nil, nil, fmt.Errorf("%s takes %d arguments, got %d", cmd, nargs, fs.NArg())
+	}
+	if o.format != "text" && o.format != "json" {
+		return nil, nil, fmt.Errorf("unknown format %q, use text or json", o.format)
+	}
+	switch manifest.FailurePolicy(o.onFailure) {
+	case manifest.FailFast, manifest.Continue, manifest.ContinueIndependent:
+	default:
+		return nil, nil, fmt.Errorf("unknown failure policy %q, use fail-fast, continue or continue-independent", o.onFailure)
+	}
+	if _, err := logger.ParseLevel(o.logLevel); err != nil {
+		return nil, nil, err
+	}
+	if o.logFormat != logger.Console && o.logFormat != logger.JSON {
+		return nil, nil, fmt.Errorf("unknown log format %q, use console or json", o.logFormat)
+	}
+	if !stackReg.MatchString(o.stack) || strings.Trim(o.stack, ".") == "" || strings.Contains(o.stack, "..") {
+		return nil, nil, fmt.Errorf("invalid stack name %q", o.stack)
+	}
+	if o.parallelism < 1 {
+		return nil, nil, fmt.Errorf("parallelism must be at least 1")
+	}
+	if o.format == "json" && (cmd == "apply" || cmd == "destroy") && !o.autoApprove {
+		return nil, nil, fmt.Errorf("%s with -format json needs -auto-approve", cmd)
+	}
+	if o.noColor {
+		color.NoColor = true
+	}
+
+	return o, fs.Args(), nil
+}
+
+// load returns the manifest with its variables set: the manifest defaults,
+// then the environment, the variable files in order and the -var flags.
+func (c *cli) load(o *options) (*manifest.Handler, error) {
+
+	m := newManifest()
+	if o.manifest != "" {
+		var err error
+		if m, err = manifest.Load(o.manifest); err != nil {
+			return nil, err
+		}
+	}
+
+	for k, v := range c.env {
+		m.Variables[k] = v
+	}
+
+	for _, path := range o.varFiles {
+		vars, err := manifest.LoadVariables(path)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range vars {
+			m.Variables[k] = v
+		}
+	}
+
+	for _, kv := range o.vars {
+		pair := strings.SplitN(kv, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return nil, fmt.Errorf("invalid -var %q, use name=value", kv)
+		}
+		m.Variables[pair[0]] = pair[1]
+	}
+
+	m.Parallelism = o.parallelism
+	m.Targets = o.targets
+	m.OnFailure = manifest.FailurePolicy(o.onFailure)
+
+	// Progress is shown in text mode and written to the events file
+	sinks := event.Multi{}
+	if o.format == "text" {
+		sinks = append(sinks, event.NewHuman(c.err))
+	}
+	if o.events != "" {
+		f, err := os.Create(o.events)
+		if err != nil {
+			return nil, err
+		}
+		c.files = append(c.files, f)
+		sinks = append(sinks, event.NewJSON(f))
+	}
+	m.Events = sinks
+
+	// The provider SDK writes to the standard log package
+	level, _ := logger.ParseLevel(o.logLevel)
+	l, err := logger.New(logger.Config{Level: level, Encoding: o.logFormat, Output: c.err})
+	if err != nil {
+		return nil, err
+	}
+	m.Log = l
+	log.SetOutput(ioutil.Discard)
+	if o.logProvider {
+		log.SetFlags(0)
+		log.SetOutput(logger.Writer(l.With(logger.F("source", "provider")), logger.Debug))
+	}
+	m.Stop = c.stop
+
+	c.l.Lock()
+	c.m = m
+	c.l.Unlock()
+
+	return m, nil
+}
+
+// open loads the manifest, configures its providers and opens the state
+func (c *cli) open(ctx context.Context, o *options) (*manifest.Handler, state.Backend, error) {
+
+	m, err := c.load(o)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s, err := c.openState(o)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := c.configure(ctx, o, m); err != nil {
+		return nil, nil, err
+	}
+
+	return m, s, nil
+}
+
+// configure configures the providers of the manifest
+func (c *cli) configure(ctx context.Context, o *options, m *manifest.Handler) error {
+	if diags := m.ConfigureProviders(ctx, c.reg); diags.HasErrors() {
+		m.Close()
+		return c.report(o, "configure", nil, diags)
+	}
+	return nil
+}
+
+// openState opens the state of the stack. The default stack lives right in
+// ~/.terramorph, where the state was kept before stacks existed.
+func (c *cli) openState(o *options) (state.Backend, error) {
+	dir := filepath.Join(c.home, ".terramorph")
+	if o.stack != "default" {
+		dir = filepath.Join(dir, "stacks", o.stack)
+	}
+	return newState(dir)
+}
+
+// approve shows the changes of an apply or a destroy and asks for them to be
+// confirmed by typing yes. There is nothing to approve without changes, and
+// -auto-approve skips the question.
+func (c *cli) approve(o *options, changes []*resource.Change) bool {
+
+	if o.format != "json" {
+		writePlan(c.out, changes)
+	}
+
+	if !pending(changes) {
+		return true
+	}
+	if o.autoApprove {
+		return true
+	}
+
+	bold := color.New(color.Bold)
+	bold.Fprintf(c.out, "\nDo you want to perform these actions on stack %s?\n", o.stack)
+	fmt.Fprintf(c.out, "  Only 'yes' will be accepted to approve.\n\n")
+	bold.Fprint(c.out, "  Enter a value: ")
+
+	answer := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(c.in).ReadString('\n')
+		answer <- line
+	}()
+
+	// A stop cancels the question and ends the read, the input is not read
+	// by anything else
+	var line string
+	select {
+	case line = <-answer:
+	case <-c.stop:
+		stopReading(c.in)
+	}
+	fmt.Fprintln(c.out)
+
+	return strings.TrimSpace(line) == "yes"
+}
+
+// aborted warns about the resources that were in flight when the command
+// was aborted, their changes may be lost or half done.
+func (c *cli) aborted() error {
+
+	c.l.Lock()
+	m := c.m
+	c.l.Unlock()
+
+	if m != nil {
+		if ids := m.InFlight(); len(ids) > 0 {
+			c.report(&options{}, "", nil, tfd.Diagnostics{}.Append(tfd.Sourceless(tfd.Warning,
+				"Resources may be inconsistent",
+				fmt.Sprintf("%s were cut short: they may exist without state or be partly changed. Run plan to see where they stand and import them if needed.",
+					strings.Join(ids, ", ")))))
+		}
+	}
+
+	return errors.New("aborted")
+}
+
+// report writes the diagnostics of a command and fails if any is an error.
+// With the json format the payload and the diagnostics are one document.
+func (c *cli) report(o *options, cmd string, payload map[string]interface{}, diags tfd.Diagnostics) error {
+
+	list := []diagnostic{}
+	errs := 0
+	for _, d := range diags {
+		desc := d.Description()
+		severity := "warning"
+		if d.Severity() == tfd.Error {
+			severity = "error"
+			errs++
+		}
+		list = append(list, diagnostic{Severity: severity, Summary: desc.Summary, Detail: desc.Detail})
+	}
+
+	if o.format == "json" {
+		if payload == nil {
+			payload = map[string]interface{}{}
+		}
+		payload["diagnostics"] = list
+		if err := writeJSON(c.out, payload); err != nil {
+			return err
+		}
+	} else {
+		for _, d := range list {
+			fmt.Fprintf(c.err, "%s: %s\n", strings.Title(d.Severity), d.Summary)
+			if d.Detail != "" {
+				fmt.Fprintf(c.err, "\n  %s\n", d.Detail)
+			}
+			fmt.Fprintln(c.err)
+		}
+	}
+
+	if errs > 0 {
+		return fmt.Errorf("%s failed with %d error(s)", cmd, errs)
+	}
+
+	return nil
+}
+
+// wrap ...
+func (ws *writers) wrap(w io.Writer) io.Writer {
+	return &syncWriter{ws: ws, w: w}
+}
+
+// close drops the writes from now on
+func (ws *writers) close() {
+	ws.l.Lock()
+	defer ws.l.Unlock()
+	ws.closed = true
+}
+
+// Write ...
+func (sw *syncWriter) Write(p []byte) (int, error) {
+	sw.ws.l.Lock()
+	defer sw.ws.l.Unlock()
+	if sw.ws.closed {
+		return len(p), nil
+	}
+	return sw.w.Write(p)
+}
+
+// String ...
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set ...
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// Helpers
+//-----------------------------------------------------------------------------
+
+// writeSummary writes how many resources succeeded, failed and were skipped
+// by a walk, naming the ones that did not succeed
+func writeSummary(w io.Writer, s manifest.Summary) {
+
+	if len(s.Succeeded)+len(s.Failed)+len(s.Skipped) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "\nSummary: %d succeeded, %d failed, %d skipped.\n", len(s.Succeeded), len(s.Failed), len(s.Skipped))
+	if len(s.Failed) > 0 {
+		color.New(color.FgRed).Fprintf(w, "  failed:  %s\n", strings.Join(s.Failed, ", "))
+	}
+	if len(s.Skipped) > 0 {
+		color.New(color.FgYellow).Fprintf(w, "  skipped: %s\n", strings.Join(s.Skipped, ", "))
+	}
+}
+
+// writePlan writes the changes of a plan, one resource per line, colored by
+// action the way Terraform does.
+func writePlan(w io.Writer, changes []*resource.Change) {
+
+	styles := map[resource.Action]struct {
+		symbol string
+		color  *color.Color
+	}{
+		resource.Create:  {"+", color.New(color.FgGreen)},
+		resource.Update:  {"~", color.New(color.FgYellow)},
+		resource.Replace: {"-/+", color.New(color.FgMagenta)},
+		resource.Delete:  {"-", color.New(color.FgRed)},
+	}
+
+	counts := map[resource.Action]int{}
+	for _, ch := range changes {
+		counts[ch.Action]++
+		style, ok := styles[ch.Action]
+		if !ok {
+			continue
+		}
+		style.color.Fprintf(w, "%3s", style.symbol)
+		fmt.Fprintf(w, " %s (%s)", ch.LogicalID, ch.Type)
+		if len(ch.Attributes) > 0 {
+			fmt.Fprintf(w, ": %s", strings.Join(ch.Attributes, ", "))
+		}
+		fmt.Fprintln(w)
+	}
+
+	if !pending(changes) {
+		color.New(color.FgGreen, color.Bold).Fprintln(w, "No changes. The stack matches the manifest.")
+		return
+	}
+
+	fmt.Fprintln(w)
+	color.New(color.Bold).Fprint(w, "Plan: ")
+	fmt.Fprintf(w, "%s to create, %s to update, %s to replace, %s to delete.\n",
+		styles[resource.Create].color.Sprint(counts[resource.Create]),
+		styles[resource.Update].color.Sprint(counts[resource.Update]),
+		styles[resource.Replace].color.Sprint(counts[resource.Replace]),
+		styles[resource.Delete].color.Sprint(counts[resource.Delete]))
+}
+
+// pending tells whether any of the changes is not a no-op
+func pending(changes []*resource.Change) bool {
+	for _, ch := range changes {
+		if ch.Action != resource.NoOp {
+			return true
+		}
+	}
+	return false
+}
+
+// stopReading unblocks a read of r, with a deadline when r supports one or
+// by closing it
+func stopReading(r io.Reader) {
+	if d, ok := r.(interface{ SetReadDeadline(time.Time) error }); ok && d.SetReadDeadline(time.Now()) == nil {
+		return
+	}
+	if cl, ok := r.(io.Closer); ok {
+		cl.Close()
+	}
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+
+	"github.com/h0tbird/terramorph/pkg/mock"
+	"github.com/h0tbird/terramorph/pkg/provider"
+)
+
+const cliManifest = `
+resources:
+  role:
+    logicalID: Role
+    type: mock_role
+    config:
+      name: nodes
+  policy:
+    logicalID: Policy
+    type: mock_policy
+    config:
+      document: "{}"
+  attachment:
+    logicalID: Attachment
+    type: mock_attachment
+    config:
+      role: role.ResourceConfig.name
+      policy_arn: policy.ResourceState.ID
+outputs:
+  policyArn: policy.ResourceState.arn
+`
+
+func testCLI(t *testing.T) (*cli, *mock.Backend, string) {
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.yaml")
+	if err := ioutil.WriteFile(path, []byte(cliManifest), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	b := mock.NewBackend()
+	reg := provider.NewRegistry()
+	reg.Register("mock", func() *schema.Provider {
+		return mock.NewProvider(b, map[string]*mock.Resource{
+			"mock_role": {
+				IDAttribute: "name",
+				Schema: map[string]*schema.Schema{
+					"name": {Type: schema.TypeString, Required: true, ForceNew: true},
+				},
+			},
+			"mock_policy": {
+				Schema: map[string]*schema.Schema{
+					"document": {Type: schema.TypeString, Required: true},
+					"arn":      {Type: schema.TypeString, Computed: true},
+				},
+			},
+			"mock_attachment": {
+				Schema: map[string]*schema.Schema{
+					"role":       {Type: schema.TypeString, Required: true, ForceNew: true},
+					"policy_arn": {Type: schema.TypeString, Required: true, ForceNew: true},
+				},
+			},
+		})
+	})
+
+	c := &cli{in: strings.NewReader(""), out: &bytes.Buffer{}, err: &bytes.Buffer{}, reg: reg, home: dir}
+	return c, b, path
+}
+
+func runCLI(c *cli, input string, args ...string) (string, error) {
+	out := &bytes.Buffer{}
+	c.in, c.out = strings.NewReader(input), out
+	err := c.run(context.Background(), args)
+	return out.String(), err
+}
+
+func TestCLI(t *testing.T) {
+
+	c, b, path := testCLI(t)
+
+	// No command
+	if _, err := runCLI(c, ""); err == nil || !strings.Contains(err.Error(), "usage") {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// Validate
+	out, err := runCLI(c, "", "validate", "-manifest", path, "-format", "json")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(out, `"valid": true`) {
+		t.Fatalf("bad: %s", out)
+	}
+
+	// Plan
+	out, err = runCLI(c, "", "plan", "-manifest", path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(out, "+ Role (mock_role)") || !strings.Contains(out, "Plan: 3 to create") {
+		t.Fatalf("bad: %s", out)
+	}
+	if !strings.Contains(c.err.(*bytes.Buffer).String(), "Starting plan of 3 resources") {
+		t.Fatalf("bad: %s", c.err)
+	}
+
+	// Progress events
+	events := filepath.Join(filepath.Dir(path), "events.json")
+	if _, err := runCLI(c, "", "plan", "-manifest", path, "-format", "json", "-events", events); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	data, err := ioutil.ReadFile(events)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 11 || !strings.Contains(lines[10], `"kind":"walk.done"`) {
+		t.Fatalf("bad: %s", data)
+	}
+
+	// Targets
+	out, err = runCLI(c, "", "plan", "-manifest", path, "-target", "Role")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(out, "Plan: 1 to create") {
+		t.Fatalf("bad: %s", out)
+	}
+	if !strings.Contains(c.err.(*bytes.Buffer).String(), "Warning: Resource targeting is in effect") {
+		t.Fatalf("bad: %s", c.err)
+	}
+
+	// Graph
+	out, err = runCLI(c, "", "graph", "-manifest", path, "-plan")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(out, `"[root] Policy" -> "[root] Attachment"`) || !strings.Contains(out, `xlabel = "create"`) {
+		t.Fatalf("bad: %s", out)
+	}
+	out, err = runCLI(c, "", "graph", "-manifest", path, "-format", "json")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(out, `"Name":"Policy|Attachment"`) {
+		t.Fatalf("bad: %s", out)
+	}
+
+	// Apply shows the plan and changes nothing unless approved
+	b.ResetCalls()
+	out, err = runCLI(c, "no\n", "apply", "-manifest", path)
+	if err == nil || !strings.Contains(err.Error(), "cancelled") {
+		t.Fatalf("bad: %v", err)
+	}
+	if !strings.Contains(out, "Plan: 3 to create") || !strings.Contains(out, "Enter a value") {
+		t.Fatalf("bad: %s", out)
+	}
+	if calls := b.Calls(); len(calls) != 0 {
+		t.Fatalf("bad: %v", calls)
+	}
+	s, _ := c.openState(&options{stack: "default"})
+	if ids, _ := s.List(); len(ids) != 0 {
+		t.Fatalf("bad: %v", ids)
+	}
+	out, err = runCLI(c, "yes\n", "apply", "-manifest", path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(out, "Summary: 3 succeeded, 0 failed, 0 skipped.") {
+		t.Fatalf("bad: %s", out)
+	}
+	if ids := b.IDs("mock_role"); len(ids) != 1 || ids[0] != "nodes" {
+		t.Fatalf("bad: %v", ids)
+	}
+
+	// Nothing to approve without changes
+	out, err = runCLI(c, "", "apply", "-manifest", path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(out, "No changes") || strings.Contains(out, "Enter a value") {
+		t.Fatalf("bad: %s", out)
+	}
+
+	// Outputs
+	out, err = runCLI(c, "", "output", "-manifest", path, "-format", "json")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	values := map[string]string{}
+	if err := json.Unmarshal([]byte(out), &values); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.HasPrefix(values["policyArn"], "mock:mock_policy:") {
+		t.Fatalf("bad: %#v", values)
+	}
+
+	// State of the stack
+	out, err = runCLI(c, "", "state", "-manifest", path, "list")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(out, "Role") || !strings.Contains(out, "nodes") {
+		t.Fatalf("bad: %s", out)
+	}
+
+	// Renamed entries keep their type and the unknown ones are masked
+	if _, err := runCLI(c, "", "state", "-manifest", path, "mv", "Policy", "OldPolicy"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	out, _ = runCLI(c, "", "state", "-manifest", path, "list")
+	if !strings.Contains(out, "OldPolicy   mock_policy") {
+		t.Fatalf("bad: %s", out)
+	}
+	out, _ = runCLI(c, "", "state", "-manifest", path, "show", "OldPolicy")
+	if !strings.Contains(out, "# OldPolicy (mock_policy)") || !strings.Contains(out, `document = "{}"`) {
+		t.Fatalf("bad: %s", out)
+	}
+	s.Write("Unknown", &terraform.InstanceState{ID: "x", Attributes: map[string]string{"secret": "wJalrXUtnFEMI"}})
+	out, _ = runCLI(c, "", "state", "-manifest", path, "show", "Unknown")
+	if !strings.Contains(out, "# Unknown (-)") || strings.Contains(out, "wJalrXUtnFEMI") {
+		t.Fatalf("bad: %s", out)
+	}
+	s.Delete("Unknown")
+	if _, err := runCLI(c, "", "state", "-manifest", path, "mv", "OldPolicy", "Policy"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Exports to a file carry on its lineage
+	tfstate := filepath.Join(filepath.Dir(path), "terraform.tfstate")
+	files := []map[string]interface{}{}
+	for i := 0; i < 2; i++ {
+		if _, err := runCLI(c, "", "state", "-manifest", path, "export", tfstate); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		data, _ := ioutil.ReadFile(tfstate)
+		f := map[string]interface{}{}
+		if err := json.Unmarshal(data, &f); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		files = append(files, f)
+	}
+	if files[1]["lineage"] != files[0]["lineage"] || files[1]["serial"] != 2.0 {
+		t.Fatalf("bad: %v %v", files[0], files[1])
+	}
+
+	// The outputs left out are warned about
+	if errOut := c.err.(*bytes.Buffer).String(); !strings.Contains(errOut, "Warning: Outputs are not exported") || !strings.Contains(errOut, "policyArn") {
+		t.Fatalf("bad: %s", errOut)
+	}
+
+	// A failed export leaves the file alone
+	if err := ioutil.WriteFile(tfstate, []byte("not json"), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := runCLI(c, "", "state", "-manifest", path, "export", tfstate); err == nil {
+		t.Fatal("expected an error")
+	}
+	if data, _ := ioutil.ReadFile(tfstate); string(data) != "not json" {
+		t.Fatalf("bad: %s", data)
+	}
+
+	// Other stacks have their own state
+	out, err = runCLI(c, "", "plan", "-manifest", path, "-stack", "staging", "-format", "json")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if strings.Count(out, `"action": "create"`) != 3 {
+		t.Fatalf("bad: %s", out)
+	}
+
+	// Import into the other stack
+	if _, err := runCLI(c, "", "import", "-manifest", path, "-stack", "staging", "role", "nodes"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	out, _ = runCLI(c, "", "plan", "-manifest", path, "-stack", "staging", "-format", "json")
+	if strings.Count(out, `"action": "create"`) != 2 {
+		t.Fatalf("bad: %s", out)
+	}
+
+	// Destroy lists the deletes
+	out, err = runCLI(c, "\n", "destroy", "-manifest", path)
+	if err == nil || !strings.Contains(out, "  - Role (mock_role)") || !strings.Contains(out, "3 to delete") {
+		t.Fatalf("bad: %v: %s", err, out)
+	}
+	if ids := b.IDs("mock_policy"); len(ids) != 1 {
+		t.Fatalf("bad: %v", ids)
+	}
+	if _, err := runCLI(c, "", "destroy", "-manifest", path, "-auto-approve"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if ids := b.IDs("mock_policy"); len(ids) != 0 {
+		t.Fatalf("bad: %v", ids)
+	}
+}
+
+func TestCLIInterrupt(t *testing.T) {
+
+	c, b, path := testCLI(t)
+	b.Slow("mock_role", 200*time.Millisecond)
+	signals := make(chan os.Signal, 2)
+	c.signals = signals
+
+	interrupt := func(n int) {
+		time.Sleep(100 * time.Millisecond)
+		for i := 0; i < n; i++ {
+			signals <- os.Interrupt
+		}
+	}
+
+	// The role in flight finishes, the attachment is not started
+	go interrupt(1)
+	if _, err := runCLI(c, "", "apply", "-manifest", path, "-auto-approve"); err == nil {
+		t.Fatal("expected an error")
+	}
+	stderr := c.err.(*bytes.Buffer).String()
+	if !strings.Contains(stderr, "Interrupt received") || !strings.Contains(stderr, "Error: Interrupted") {
+		t.Fatalf("bad: %s", stderr)
+	}
+	if len(b.IDs("mock_role")) != 1 || len(b.IDs("mock_attachment")) != 0 {
+		t.Fatalf("bad: %v %v", b.IDs("mock_role"), b.IDs("mock_attachment"))
+	}
+
+	// A second interrupt aborts and warns about the resources in flight
+	if _, err := runCLI(c, "", "destroy", "-manifest", path, "-auto-approve"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	c.err = &bytes.Buffer{}
+	go interrupt(2)
+	if _, err := runCLI(c, "", "apply", "-manifest", path, "-auto-approve"); err == nil || err.Error() != "aborted" {
+		t.Fatalf("bad: %v", err)
+	}
+	if stderr := c.err.(*bytes.Buffer).String(); !strings.Contains(stderr, "Warning: Resources may be inconsistent") || !strings.Contains(stderr, "Role") {
+		t.Fatalf("bad: %s", stderr)
+	}
+
+	// Let the aborted command wind down before the home is removed
+	time.Sleep(100 * time.Millisecond)
+
+	// An interrupt at the question stops reading the input
+	if _, err := runCLI(c, "", "destroy", "-manifest", path, "-auto-approve"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	pr, pw := io.Pipe()
+	c.in = pr
+	go interrupt(1)
+	if err := c.run(context.Background(), []string{"apply", "-manifest", path}); err == nil || !strings.Contains(err.Error(), "cancelled") {
+		t.Fatalf("bad: %v", err)
+	}
+	if _, err := pw.Write([]byte("yes\n")); err != io.ErrClosedPipe {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+func TestCLIApprovedPlan(t *testing.T) {
+
+	c, b, path := testCLI(t)
+	if _, err := runCLI(c, "", "apply", "-manifest", path, "-auto-approve"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	arn := b.IDs("mock_policy")[0]
+	b.Put("mock_policy", arn, mock.Object{"document": "{drift}", "arn": arn})
+
+	// The policy is deleted while the update is waiting for approval
+	pr, pw := io.Pipe()
+	c.in, c.out = pr, &bytes.Buffer{}
+	done := make(chan error, 1)
+	go func() { done <- c.run(context.Background(), []string{"apply", "-manifest", path}) }()
+	pw.Write(nil)
+	b.Remove("mock_policy", arn)
+	pw.Write([]byte("yes\n"))
+
+	// and not created again
+	if err := <-done; err == nil {
+		t.Fatal("expected an error")
+	}
+	if stderr := c.err.(*bytes.Buffer).String(); !strings.Contains(stderr, "the plan changed since it was approved, it is now create") {
+		t.Fatalf("bad: %s", stderr)
+	}
+	if ids := b.IDs("mock_policy"); len(ids) != 0 {
+		t.Fatalf("bad: %v", ids)
+	}
+}
+
+func TestCLILog(t *testing.T) {
+
+	c, _, path := testCLI(t)
+
+	// Nothing below warn by default
+	if _, err := runCLI(c, "", "plan", "-manifest", path); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if strings.Contains(c.err.(*bytes.Buffer).String(), "Configuring the provider") {
+		t.Fatalf("bad: %s", c.err)
+	}
+
+	// Provider SDK logs at debug level
+	c.err = &bytes.Buffer{}
+	if _, err := runCLI(c, "", "apply", "-manifest", path, "-auto-approve", "-log-level", "debug", "-log-format", "json", "-log-provider"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	stderr := c.err.(*bytes.Buffer).String()
+	if !strings.Contains(stderr, `"msg":"Configuring the provider"`) || !strings.Contains(stderr, `"source":"provider"`) {
+		t.Fatalf("bad: %s", stderr)
+	}
+}
+
+func TestCLIErrors(t *testing.T) {
+
+	c, _, path := testCLI(t)
+
+	for name, args := range map[string][]string{
+		"unknown command": {"deploy"},
+		"bad format":      {"plan", "-manifest", path, "-format", "yaml"},
+		"bad stack":       {"plan", "-manifest", path, "-stack", "../x"},
+		"dot stack":       {"plan", "-manifest", path, "-stack", "."},
+		"parent stack":    {"plan", "-manifest", path, "-stack", ".."},
+		"dotted stack":    {"plan", "-manifest", path, "-stack", "a..b"},
+		"bad var":         {"plan", "-manifest", path, "-var", "name"},
+		"extra args":      {"plan", "-manifest", path, "x"},
+		"missing file":    {"plan", "-manifest", path + ".nope"},
+		"import args":     {"import", "-manifest", path, "role"},
+		"json approval":   {"apply", "-manifest", path, "-format", "json"},
+		"bad policy":      {"apply", "-manifest", path, "-on-failure", "retry"},
+		"bad log level":   {"plan", "-manifest", path, "-log-level", "trace"},
+		"bad log format":  {"plan", "-manifest", path, "-log-format", "yaml"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := runCLI(c, "", args...); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+
+	// State is only addressed by logical IDs, never by paths
+	for _, args := range [][]string{
+		{"show", "/etc/passwd"},
+		{"rm", "../Role"},
+		{"mv", "Role", "a/b"},
+		{"restore", "..", "1"},
+	} {
+		if _, err := runCLI(c, "", append([]string{"state", "-manifest", path}, args...)...); err == nil || !strings.Contains(err.Error(), "invalid logical ID") {
+			t.Fatalf("%s: bad: %v", args[0], err)
+		}
+	}
+}
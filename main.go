@@ -8,22 +8,22 @@ import (
 
 	// stdlib
 	"context"
+	"fmt"
 	"io/ioutil"
 	"log"
-
-	// community
-	"github.com/sirupsen/logrus"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	// terraform
-	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
-	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
 	"github.com/terraform-providers/terraform-provider-aws/aws"
 
 	// terramorph
 	// TODO: move from pkg to v1
 	"github.com/h0tbird/terramorph/pkg/manifest"
+	"github.com/h0tbird/terramorph/pkg/provider"
 	"github.com/h0tbird/terramorph/pkg/resource"
-	"github.com/h0tbird/terramorph/pkg/tfd"
 )
 
 //-----------------------------------------------------------------------------
@@ -31,38 +31,47 @@ import (
 //-----------------------------------------------------------------------------
 
 func init() {
-	// TODO: replace logrus with zap logger
+	// The provider SDK writes to the standard log package, the cli sends it
+	// through its logger with -log-provider
 	log.SetOutput(ioutil.Discard)
 }
 
 //-----------------------------------------------------------------------------
-// Main
+// Helpers
 //-----------------------------------------------------------------------------
 
-func main() {
+// envVariables returns the environment variables starting with prefix,
+// keyed by the rest of their name.
+func envVariables(prefix string) map[string]interface{} {
+	vars := map[string]interface{}{}
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, prefix) {
+			pair := strings.SplitN(strings.TrimPrefix(kv, prefix), "=", 2)
+			vars[pair[0]] = pair[1]
+		}
+	}
+	return vars
+}
+
+//-----------------------------------------------------------------------------
+// Manifest
+//-----------------------------------------------------------------------------
+
+// newManifest returns the IAM bootstrap manifest
+func newManifest() *manifest.Handler {
 
-	ctx := context.Background()
 	m := manifest.New()
-	s := &state{}
 
-	//------------------------
-	// Configure the provider
-	//------------------------
+	//-----------------------
+	// Variables and provider
+	//-----------------------
+
+	m.Variables["region"] = "us-east-2"
 
-	p := aws.Provider()
-	logrus.WithFields(logrus.Fields{"region": "us-east-2"}).Info("Configuring the provider")
-	diags := p.Configure(ctx, &terraform.ResourceConfig{
+	m.Providers["aws"] = &manifest.Provider{
 		Config: map[string]interface{}{
-			"region": "us-east-2",
+			"region": "var.region",
 		},
-	})
-
-	if diags != nil && diags.HasError() {
-		for _, d := range diags {
-			if d.Severity == diag.Error {
-				logrus.Fatalf("error configuring the provider: %s", d.Summary)
-			}
-		}
 	}
 
 	//--------------------------------------------
@@ -220,16 +229,40 @@ func main() {
 		},
 	}
 
-	//--------------------
-	// Apply the manifest
-	//--------------------
+	return m
+}
 
-	diags2 := m.Apply(ctx, p, s)
-	if diags2 != nil && diags2.HasErrors() {
-		for _, d := range diags2 {
-			if d.Severity() == tfd.Error {
-				logrus.Fatalf("error applying the manifest: %s", d.Description())
-			}
-		}
+//-----------------------------------------------------------------------------
+// Main
+//-----------------------------------------------------------------------------
+
+func main() {
+
+	// Provider registry. The random, tls and null providers keep their
+	// schema.Provider in internal packages and can not be linked in, they are
+	// run out of process with a provider block giving the path of the binary.
+	reg := provider.NewRegistry()
+	reg.Register("aws", aws.Provider)
+
+	// IAM throttles aggressively, keep parallel walks under its rate
+	resource.Limits.SetRate("aws_iam", resource.Rate{PerSecond: 10, Burst: 10})
+
+	// Interrupts are handled by the cli
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	c := &cli{
+		in:      os.Stdin,
+		out:     os.Stdout,
+		err:     os.Stderr,
+		reg:     reg,
+		home:    os.Getenv("HOME"),
+		env:     envVariables("TERRAMORPH_VAR_"),
+		signals: signals,
+	}
+
+	if err := c.run(context.Background(), os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
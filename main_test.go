@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/terraform-providers/terraform-provider-aws/aws"
+
+	"github.com/h0tbird/terramorph/pkg/awstest"
+	"github.com/h0tbird/terramorph/pkg/manifest"
+	"github.com/h0tbird/terramorph/pkg/mock"
+	"github.com/h0tbird/terramorph/pkg/provider"
+	"github.com/h0tbird/terramorph/pkg/resource"
+	"github.com/h0tbird/terramorph/pkg/state"
+)
+
+// awsConfig points the AWS provider at the IAM stand-in
+func awsConfig(srv *awstest.Server) map[string]interface{} {
+	return map[string]interface{}{
+		"region":                  "var.region",
+		"access_key":              "test",
+		"secret_key":              "test",
+		"skip_get_ec2_platforms":  true,
+		"skip_metadata_api_check": true,
+		"endpoints": []interface{}{
+			map[string]interface{}{"iam": srv.URL, "sts": srv.URL},
+		},
+	}
+}
+
+// TestManifestAWS runs the bootstrap manifest through the AWS provider
+// against the IAM stand-in: apply, re-apply and destroy.
+func TestManifestAWS(t *testing.T) {
+
+	srv := awstest.NewServer()
+	defer srv.Close()
+
+	ctx := context.Background()
+	m := newManifest()
+	m.Providers["aws"].Config = awsConfig(srv)
+
+	reg := provider.NewRegistry()
+	reg.Register("aws", aws.Provider)
+	if diags := m.ConfigureProviders(ctx, reg); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+
+	s := state.NewMemory()
+	names := []string{
+		"control-plane.cluster-api-provider-aws.sigs.k8s.io",
+		"controllers.cluster-api-provider-aws.sigs.k8s.io",
+		"nodes.cluster-api-provider-aws.sigs.k8s.io",
+	}
+	arn := func(name string) string {
+		return "arn:aws:iam::" + awstest.Account + ":policy/" + name
+	}
+
+	// Apply
+	if diags := m.Apply(ctx, s); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+
+	if got := srv.Roles(); !reflect.DeepEqual(got, names) {
+		t.Fatalf("bad roles: %#v", got)
+	}
+	if got, want := srv.Policies(), []string{arn(names[0]), arn(names[1]), arn(names[2])}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("bad policies: %#v", got)
+	}
+	if got, want := srv.AttachedPolicies(names[0]), []string{arn(names[0]), arn(names[1]), arn(names[2])}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("bad control plane attachments: %#v", got)
+	}
+	if got, want := srv.AttachedPolicies(names[2]), []string{arn(names[2])}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("bad nodes attachments: %#v", got)
+	}
+	if got, want := srv.InstanceProfiles(), []string{names[0] + "=" + names[0], names[1] + "=" + names[1], names[2] + "=" + names[2]}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("bad instance profiles: %#v", got)
+	}
+
+	// Re-apply is a no-op
+	srv.ResetCalls()
+	if diags := m.Apply(ctx, s); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	for _, call := range srv.Calls() {
+		if !strings.HasPrefix(call, "Get") && !strings.HasPrefix(call, "List") {
+			t.Fatalf("re-apply called %s: %v", call, srv.Calls())
+		}
+	}
+
+	// Destroy
+	if diags := m.Destroy(ctx, s); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+
+	if got := srv.Roles(); len(got) != 0 {
+		t.Fatalf("bad roles: %#v", got)
+	}
+	if got := srv.Policies(); len(got) != 0 {
+		t.Fatalf("bad policies: %#v", got)
+	}
+	if got := srv.InstanceProfiles(); len(got) != 0 {
+		t.Fatalf("bad instance profiles: %#v", got)
+	}
+}
+
+// TestManifestMixedProviders mixes AWS resources with the resources of a
+// second provider: each is routed by its type prefix and the role is named
+// after the ID the other provider generated.
+func TestManifestMixedProviders(t *testing.T) {
+
+	srv := awstest.NewServer()
+	defer srv.Close()
+
+	b := mock.NewBackend()
+	reg := provider.NewRegistry()
+	reg.Register("aws", aws.Provider)
+	reg.Register("random", func() *schema.Provider {
+		return mock.NewProvider(b, map[string]*mock.Resource{
+			"random_id": {
+				Schema: map[string]*schema.Schema{
+					"byte_length": {Type: schema.TypeInt, Required: true, ForceNew: true},
+					"hex":         {Type: schema.TypeString, Computed: true},
+				},
+			},
+		})
+	})
+
+	ctx := context.Background()
+	m := manifest.New()
+	m.Variables["region"] = "us-east-2"
+	m.Providers["aws"] = &manifest.Provider{Config: awsConfig(srv)}
+	m.Resources["suffix"] = &resource.Handler{
+		ResourceLogicalID: "Suffix",
+		ResourceType:      "random_id",
+		ResourceConfig:    map[string]interface{}{"byte_length": 4},
+	}
+	m.Resources["role"] = &resource.Handler{
+		ResourceLogicalID: "Role",
+		ResourceType:      "aws_iam_role",
+		ResourceConfig: map[string]interface{}{
+			"name":               "suffix.ResourceState.ID",
+			"assume_role_policy": assumeRolePolicy,
+		},
+	}
+
+	if diags := m.ConfigureProviders(ctx, reg); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	if names := []string{m.Providers["aws"].Name, m.Providers["random"].Name}; !reflect.DeepEqual(names, []string{"aws", "random"}) {
+		t.Fatalf("bad: %#v", names)
+	}
+
+	// Apply
+	s := state.NewMemory()
+	if diags := m.Apply(ctx, s); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+
+	ids := b.IDs("random_id")
+	if len(ids) != 1 {
+		t.Fatalf("bad: %#v", ids)
+	}
+	if got := srv.Roles(); !reflect.DeepEqual(got, ids) {
+		t.Fatalf("bad roles: %#v", got)
+	}
+
+	// Re-apply is a no-op for both providers
+	b.ResetCalls()
+	srv.ResetCalls()
+	if diags := m.Apply(ctx, s); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	for _, call := range b.Calls() {
+		if !strings.HasPrefix(call, "read") {
+			t.Fatalf("re-apply called %s: %v", call, b.Calls())
+		}
+	}
+	for _, call := range srv.Calls() {
+		if !strings.HasPrefix(call, "Get") && !strings.HasPrefix(call, "List") {
+			t.Fatalf("re-apply called %s: %v", call, srv.Calls())
+		}
+	}
+
+	// Destroy
+	if diags := m.Destroy(ctx, s); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	if got := srv.Roles(); len(got) != 0 {
+		t.Fatalf("bad roles: %#v", got)
+	}
+	if ids := b.IDs("random_id"); len(ids) != 0 {
+		t.Fatalf("bad: %#v", ids)
+	}
+}
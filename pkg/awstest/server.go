@@ -0,0 +1,882 @@
+package awstest
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//-----------------------------------------------------------------------------
+// Globals
+//-----------------------------------------------------------------------------
+
+// Account is the AWS account ID of the stand-in
+const Account = "123456789012"
+
+const xmlns = "https://iam.amazonaws.com/doc/2010-05-08/"
+
+//-----------------------------------------------------------------------------
+// Types
+//-----------------------------------------------------------------------------
+
+// Server is a local stand-in for the IAM and STS query APIs. It implements
+// the subset used by the aws_iam_policy, aws_iam_role,
+// aws_iam_role_policy_attachment and aws_iam_instance_profile resources and
+// keeps everything in memory. Point the iam and sts endpoints of the AWS
+// provider at URL.
+type Server struct {
+	*httptest.Server
+	mu       sync.Mutex
+	policies map[string]*policy
+	roles    map[string]*role
+	profiles map[string]*profile
+	calls    []string
+	next     int
+}
+
+type policy struct {
+	name, id, arn, path, description string
+	versions                         []*policyVersion
+	defaultVersion                   string
+	created                          time.Time
+}
+
+type policyVersion struct {
+	id       string
+	document string
+	created  time.Time
+}
+
+type role struct {
+	name, id, arn, path, description string
+	assumeRolePolicy                 string
+	maxSessionDuration               int64
+	attached                         []string
+	created                          time.Time
+}
+
+type profile struct {
+	name, id, arn, path string
+	roles               []string
+	created             time.Time
+}
+
+// apiError is an error response of the query API
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+// handler implements an action. It returns the <Action>Result element.
+type handler func(s *Server, form url.Values) (interface{}, *apiError)
+
+//-----------------------------------------------------------------------------
+// Constructor
+//-----------------------------------------------------------------------------
+
+// NewServer starts a stand-in. Close it when done.
+func NewServer() *Server {
+
+	s := &Server{
+		policies: map[string]*policy{},
+		roles:    map[string]*role{},
+		profiles: map[string]*profile{},
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+//-----------------------------------------------------------------------------
+// Methods
+//-----------------------------------------------------------------------------
+
+// Calls returns the actions called so far, in order
+func (s *Server) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.calls...)
+}
+
+// ResetCalls forgets the recorded calls
+func (s *Server) ResetCalls() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = nil
+}
+
+// Policies returns the ARNs of the managed policies, sorted
+func (s *Server) Policies() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	arns := []string{}
+	for arn := range s.policies {
+		arns = append(arns, arn)
+	}
+	sort.Strings(arns)
+	return arns
+}
+
+// Roles returns the role names, sorted
+func (s *Server) Roles() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := []string{}
+	for name := range s.roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AttachedPolicies returns the ARNs of the policies attached to a role, sorted
+func (s *Server) AttachedPolicies(roleName string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	arns := []string{}
+	if r, ok := s.roles[roleName]; ok {
+		arns = append(arns, r.attached...)
+	}
+	sort.Strings(arns)
+	return arns
+}
+
+// InstanceProfiles returns the instance profiles as name=role, sorted
+func (s *Server) InstanceProfiles() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	profiles := []string{}
+	for name, p := range s.profiles {
+		profiles = append(profiles, name+"="+strings.Join(p.roles, ","))
+	}
+	sort.Strings(profiles)
+	return profiles
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, req *http.Request) {
+
+	if err := req.ParseForm(); err != nil {
+		writeError(w, &apiError{http.StatusBadRequest, "MalformedQueryString", err.Error()})
+		return
+	}
+
+	action := req.Form.Get("Action")
+	h, ok := handlers[action]
+	if !ok {
+		writeError(w, &apiError{http.StatusBadRequest, "InvalidAction", fmt.Sprintf("Could not find operation %s", action)})
+		return
+	}
+
+	s.mu.Lock()
+	s.calls = append(s.calls, action)
+	result, aerr := h(s, req.Form)
+	s.mu.Unlock()
+
+	if aerr != nil {
+		writeError(w, aerr)
+		return
+	}
+
+	writeResult(w, action, result)
+}
+
+// id returns a unique ID with the prefix IAM uses for the kind of entity
+func (s *Server) id(prefix string) string {
+	s.next++
+	return fmt.Sprintf("%s%017d", prefix, s.next)
+}
+
+//-----------------------------------------------------------------------------
+// Actions
+//-----------------------------------------------------------------------------
+
+var handlers = map[string]handler{
+	"GetCallerIdentity":             getCallerIdentity,
+	"CreatePolicy":                  createPolicy,
+	"GetPolicy":                     getPolicy,
+	"DeletePolicy":                  deletePolicy,
+	"CreatePolicyVersion":           createPolicyVersion,
+	"GetPolicyVersion":              getPolicyVersion,
+	"ListPolicyVersions":            listPolicyVersions,
+	"DeletePolicyVersion":           deletePolicyVersion,
+	"CreateRole":                    createRole,
+	"GetRole":                       getRole,
+	"UpdateRole":                    updateRole,
+	"UpdateRoleDescription":         updateRoleDescription,
+	"UpdateAssumeRolePolicy":        updateAssumeRolePolicy,
+	"DeleteRole":                    deleteRole,
+	"AttachRolePolicy":              attachRolePolicy,
+	"DetachRolePolicy":              detachRolePolicy,
+	"ListAttachedRolePolicies":      listAttachedRolePolicies,
+	"ListRolePolicies":              listRolePolicies,
+	"ListInstanceProfilesForRole":   listInstanceProfilesForRole,
+	"CreateInstanceProfile":         createInstanceProfile,
+	"GetInstanceProfile":            getInstanceProfile,
+	"DeleteInstanceProfile":         deleteInstanceProfile,
+	"AddRoleToInstanceProfile":      addRoleToInstanceProfile,
+	"RemoveRoleFromInstanceProfile": removeRoleFromInstanceProfile,
+}
+
+func getCallerIdentity(s *Server, form url.Values) (interface{}, *apiError) {
+	return &struct {
+		Account string
+		Arn     string
+		UserId  string
+	}{Account, fmt.Sprintf("arn:aws:iam::%s:user/terramorph", Account), "AIDA00000000000000000"}, nil
+}
+
+//------------------
+// Managed policies
+//------------------
+
+func createPolicy(s *Server, form url.Values) (interface{}, *apiError) {
+
+	name, path := form.Get("PolicyName"), pathOrRoot(form.Get("Path"))
+	if aerr := validDocument(form.Get("PolicyDocument")); aerr != nil {
+		return nil, aerr
+	}
+
+	arn := fmt.Sprintf("arn:aws:iam::%s:policy%s%s", Account, path, name)
+	if _, ok := s.policies[arn]; ok {
+		return nil, alreadyExists("policy", name)
+	}
+
+	now := now()
+	p := &policy{
+		name:           name,
+		id:             s.id("ANPA"),
+		arn:            arn,
+		path:           path,
+		description:    form.Get("Description"),
+		versions:       []*policyVersion{{id: "v1", document: form.Get("PolicyDocument"), created: now}},
+		defaultVersion: "v1",
+		created:        now,
+	}
+	s.policies[arn] = p
+
+	return &struct{ Policy *policyXML }{s.policyXML(p)}, nil
+}
+
+func getPolicy(s *Server, form url.Values) (interface{}, *apiError) {
+	p, aerr := s.policy(form.Get("PolicyArn"))
+	if aerr != nil {
+		return nil, aerr
+	}
+	return &struct{ Policy *policyXML }{s.policyXML(p)}, nil
+}
+
+func deletePolicy(s *Server, form url.Values) (interface{}, *apiError) {
+
+	p, aerr := s.policy(form.Get("PolicyArn"))
+	if aerr != nil {
+		return nil, aerr
+	}
+
+	if s.attachments(p.arn) > 0 {
+		return nil, &apiError{http.StatusConflict, "DeleteConflict", "Cannot delete a policy attached to entities."}
+	}
+	if len(p.versions) > 1 {
+		return nil, &apiError{http.StatusConflict, "DeleteConflict", "This policy has more than one version. Before you delete a policy, you must delete the policy's versions. The default version is deleted with the policy."}
+	}
+
+	delete(s.policies, p.arn)
+	return nil, nil
+}
+
+func createPolicyVersion(s *Server, form url.Values) (interface{}, *apiError) {
+
+	p, aerr := s.policy(form.Get("PolicyArn"))
+	if aerr != nil {
+		return nil, aerr
+	}
+	if aerr := validDocument(form.Get("PolicyDocument")); aerr != nil {
+		return nil, aerr
+	}
+	if len(p.versions) >= 5 {
+		return nil, &apiError{http.StatusConflict, "LimitExceeded", "A managed policy can have up to 5 versions."}
+	}
+
+	last, _ := strconv.Atoi(strings.TrimPrefix(p.versions[len(p.versions)-1].id, "v"))
+	v := &policyVersion{id: fmt.Sprintf("v%d", last+1), document: form.Get("PolicyDocument"), created: now()}
+	p.versions = append(p.versions, v)
+	if form.Get("SetAsDefault") == "true" {
+		p.defaultVersion = v.id
+	}
+
+	return &struct{ PolicyVersion *policyVersionXML }{p.versionXML(v, false)}, nil
+}
+
+func getPolicyVersion(s *Server, form url.Values) (interface{}, *apiError) {
+
+	p, aerr := s.policy(form.Get("PolicyArn"))
+	if aerr != nil {
+		return nil, aerr
+	}
+
+	for _, v := range p.versions {
+		if v.id == form.Get("VersionId") {
+			return &struct{ PolicyVersion *policyVersionXML }{p.versionXML(v, true)}, nil
+		}
+	}
+
+	return nil, noSuchEntity("Policy %s version %s does not exist or is not attachable.", p.arn, form.Get("VersionId"))
+}
+
+func listPolicyVersions(s *Server, form url.Values) (interface{}, *apiError) {
+
+	p, aerr := s.policy(form.Get("PolicyArn"))
+	if aerr != nil {
+		return nil, aerr
+	}
+
+	versions := []*policyVersionXML{}
+	for _, v := range p.versions {
+		versions = append(versions, p.versionXML(v, false))
+	}
+
+	return &struct {
+		Versions    []*policyVersionXML `xml:"Versions>member"`
+		IsTruncated bool
+	}{Versions: versions}, nil
+}
+
+func deletePolicyVersion(s *Server, form url.Values) (interface{}, *apiError) {
+
+	p, aerr := s.policy(form.Get("PolicyArn"))
+	if aerr != nil {
+		return nil, aerr
+	}
+
+	id := form.Get("VersionId")
+	if id == p.defaultVersion {
+		return nil, &apiError{http.StatusConflict, "DeleteConflict", "Cannot delete the default version of a policy."}
+	}
+
+	for i, v := range p.versions {
+		if v.id == id {
+			p.versions = append(p.versions[:i], p.versions[i+1:]...)
+			return nil, nil
+		}
+	}
+
+	return nil, noSuchEntity("Policy %s version %s does not exist.", p.arn, id)
+}
+
+//-------
+// Roles
+//-------
+
+func createRole(s *Server, form url.Values) (interface{}, *apiError) {
+
+	name, path := form.Get("RoleName"), pathOrRoot(form.Get("Path"))
+	if aerr := validDocument(form.Get("AssumeRolePolicyDocument")); aerr != nil {
+		return nil, aerr
+	}
+	if _, ok := s.roles[name]; ok {
+		return nil, alreadyExists("role", name)
+	}
+
+	r := &role{
+		name:               name,
+		id:                 s.id("AROA"),
+		arn:                fmt.Sprintf("arn:aws:iam::%s:role%s%s", Account, path, name),
+		path:               path,
+		description:        form.Get("Description"),
+		assumeRolePolicy:   form.Get("AssumeRolePolicyDocument"),
+		maxSessionDuration: 3600,
+		created:            now(),
+	}
+
+	if v := form.Get("MaxSessionDuration"); v != "" {
+		d, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || d < 3600 || d > 43200 {
+			return nil, &apiError{http.StatusBadRequest, "ValidationError", "MaxSessionDuration must be between 3600 and 43200."}
+		}
+		r.maxSessionDuration = d
+	}
+
+	s.roles[name] = r
+	return &struct{ Role *roleXML }{r.xml()}, nil
+}
+
+func getRole(s *Server, form url.Values) (interface{}, *apiError) {
+	r, aerr := s.role(form.Get("RoleName"))
+	if aerr != nil {
+		return nil, aerr
+	}
+	return &struct{ Role *roleXML }{r.xml()}, nil
+}
+
+func updateRole(s *Server, form url.Values) (interface{}, *apiError) {
+
+	r, aerr := s.role(form.Get("RoleName"))
+	if aerr != nil {
+		return nil, aerr
+	}
+
+	if v := form.Get("MaxSessionDuration"); v != "" {
+		d, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || d < 3600 || d > 43200 {
+			return nil, &apiError{http.StatusBadRequest, "ValidationError", "MaxSessionDuration must be between 3600 and 43200."}
+		}
+		r.maxSessionDuration = d
+	}
+	if _, ok := form["Description"]; ok {
+		r.description = form.Get("Description")
+	}
+
+	return nil, nil
+}
+
+func updateRoleDescription(s *Server, form url.Values) (interface{}, *apiError) {
+	r, aerr := s.role(form.Get("RoleName"))
+	if aerr != nil {
+		return nil, aerr
+	}
+	r.description = form.Get("Description")
+	return &struct{ Role *roleXML }{r.xml()}, nil
+}
+
+func updateAssumeRolePolicy(s *Server, form url.Values) (interface{}, *apiError) {
+	r, aerr := s.role(form.Get("RoleName"))
+	if aerr != nil {
+		return nil, aerr
+	}
+	if aerr := validDocument(form.Get("PolicyDocument")); aerr != nil {
+		return nil, aerr
+	}
+	r.assumeRolePolicy = form.Get("PolicyDocument")
+	return nil, nil
+}
+
+func deleteRole(s *Server, form url.Values) (interface{}, *apiError) {
+
+	r, aerr := s.role(form.Get("RoleName"))
+	if aerr != nil {
+		return nil, aerr
+	}
+
+	if len(r.attached) > 0 {
+		return nil, &apiError{http.StatusConflict, "DeleteConflict", "Cannot delete entity, must detach all policies first."}
+	}
+	for _, p := range s.profiles {
+		if contains(p.roles, r.name) {
+			return nil, &apiError{http.StatusConflict, "DeleteConflict", "Cannot delete entity, must remove roles from instance profile first."}
+		}
+	}
+
+	delete(s.roles, r.name)
+	return nil, nil
+}
+
+func attachRolePolicy(s *Server, form url.Values) (interface{}, *apiError) {
+
+	r, aerr := s.role(form.Get("RoleName"))
+	if aerr != nil {
+		return nil, aerr
+	}
+	p, aerr := s.policy(form.Get("PolicyArn"))
+	if aerr != nil {
+		return nil, aerr
+	}
+
+	if !contains(r.attached, p.arn) {
+		r.attached = append(r.attached, p.arn)
+	}
+
+	return nil, nil
+}
+
+func detachRolePolicy(s *Server, form url.Values) (interface{}, *apiError) {
+
+	r, aerr := s.role(form.Get("RoleName"))
+	if aerr != nil {
+		return nil, aerr
+	}
+
+	arn := form.Get("PolicyArn")
+	if !contains(r.attached, arn) {
+		return nil, noSuchEntity("Policy %s was not found.", arn)
+	}
+
+	r.attached = remove(r.attached, arn)
+	return nil, nil
+}
+
+func listAttachedRolePolicies(s *Server, form url.Values) (interface{}, *apiError) {
+
+	r, aerr := s.role(form.Get("RoleName"))
+	if aerr != nil {
+		return nil, aerr
+	}
+
+	type attachedPolicy struct {
+		PolicyName string
+		PolicyArn  string
+	}
+
+	attached := []*attachedPolicy{}
+	for _, arn := range r.attached {
+		attached = append(attached, &attachedPolicy{s.policies[arn].name, arn})
+	}
+
+	return &struct {
+		AttachedPolicies []*attachedPolicy `xml:"AttachedPolicies>member"`
+		IsTruncated      bool
+	}{AttachedPolicies: attached}, nil
+}
+
+// listRolePolicies lists the inline policies, which are not supported
+func listRolePolicies(s *Server, form url.Values) (interface{}, *apiError) {
+	if _, aerr := s.role(form.Get("RoleName")); aerr != nil {
+		return nil, aerr
+	}
+	return &struct {
+		PolicyNames []string `xml:"PolicyNames>member"`
+		IsTruncated bool
+	}{}, nil
+}
+
+func listInstanceProfilesForRole(s *Server, form url.Values) (interface{}, *apiError) {
+
+	r, aerr := s.role(form.Get("RoleName"))
+	if aerr != nil {
+		return nil, aerr
+	}
+
+	profiles := []*profileXML{}
+	for _, name := range sortedKeys(s.profiles) {
+		if p := s.profiles[name]; contains(p.roles, r.name) {
+			profiles = append(profiles, s.profileXML(p))
+		}
+	}
+
+	return &struct {
+		InstanceProfiles []*profileXML `xml:"InstanceProfiles>member"`
+		IsTruncated      bool
+	}{InstanceProfiles: profiles}, nil
+}
+
+//-------------------
+// Instance profiles
+//-------------------
+
+func createInstanceProfile(s *Server, form url.Values) (interface{}, *apiError) {
+
+	name, path := form.Get("InstanceProfileName"), pathOrRoot(form.Get("Path"))
+	if _, ok := s.profiles[name]; ok {
+		return nil, alreadyExists("instance profile", name)
+	}
+
+	p := &profile{
+		name:    name,
+		id:      s.id("AIPA"),
+		arn:     fmt.Sprintf("arn:aws:iam::%s:instance-profile%s%s", Account, path, name),
+		path:    path,
+		created: now(),
+	}
+	s.profiles[name] = p
+
+	return &struct{ InstanceProfile *profileXML }{s.profileXML(p)}, nil
+}
+
+func getInstanceProfile(s *Server, form url.Values) (interface{}, *apiError) {
+	p, aerr := s.profile(form.Get("InstanceProfileName"))
+	if aerr != nil {
+		return nil, aerr
+	}
+	return &struct{ InstanceProfile *profileXML }{s.profileXML(p)}, nil
+}
+
+func deleteInstanceProfile(s *Server, form url.Values) (interface{}, *apiError) {
+
+	p, aerr := s.profile(form.Get("InstanceProfileName"))
+	if aerr != nil {
+		return nil, aerr
+	}
+
+	if len(p.roles) > 0 {
+		return nil, &apiError{http.StatusConflict, "DeleteConflict", "Cannot delete entity, must remove roles from instance profile first."}
+	}
+
+	delete(s.profiles, p.name)
+	return nil, nil
+}
+
+func addRoleToInstanceProfile(s *Server, form url.Values) (interface{}, *apiError) {
+
+	p, aerr := s.profile(form.Get("InstanceProfileName"))
+	if aerr != nil {
+		return nil, aerr
+	}
+	r, aerr := s.role(form.Get("RoleName"))
+	if aerr != nil {
+		return nil, aerr
+	}
+
+	if len(p.roles) > 0 {
+		return nil, &apiError{http.StatusConflict, "LimitExceeded", "Cannot exceed quota for InstanceSessionsPerInstanceProfile: 1"}
+	}
+
+	p.roles = append(p.roles, r.name)
+	return nil, nil
+}
+
+func removeRoleFromInstanceProfile(s *Server, form url.Values) (interface{}, *apiError) {
+
+	p, aerr := s.profile(form.Get("InstanceProfileName"))
+	if aerr != nil {
+		return nil, aerr
+	}
+
+	name := form.Get("RoleName")
+	if !contains(p.roles, name) {
+		return nil, noSuchEntity("The role with name %s cannot be found.", name)
+	}
+
+	p.roles = remove(p.roles, name)
+	return nil, nil
+}
+
+//-----------------------------------------------------------------------------
+// Lookups
+//-----------------------------------------------------------------------------
+
+func (s *Server) policy(arn string) (*policy, *apiError) {
+	if p, ok := s.policies[arn]; ok {
+		return p, nil
+	}
+	return nil, noSuchEntity("Policy %s does not exist or is not attachable.", arn)
+}
+
+func (s *Server) role(name string) (*role, *apiError) {
+	if r, ok := s.roles[name]; ok {
+		return r, nil
+	}
+	return nil, noSuchEntity("The role with name %s cannot be found.", name)
+}
+
+func (s *Server) profile(name string) (*profile, *apiError) {
+	if p, ok := s.profiles[name]; ok {
+		return p, nil
+	}
+	return nil, noSuchEntity("Instance Profile %s cannot be found.", name)
+}
+
+// attachments counts the roles a policy is attached to
+func (s *Server) attachments(arn string) int {
+	n := 0
+	for _, r := range s.roles {
+		if contains(r.attached, arn) {
+			n++
+		}
+	}
+	return n
+}
+
+//-----------------------------------------------------------------------------
+// Responses
+//-----------------------------------------------------------------------------
+
+type policyXML struct {
+	PolicyName       string
+	PolicyId         string
+	Arn              string
+	Path             string
+	DefaultVersionId string
+	AttachmentCount  int
+	IsAttachable     bool
+	Description      string `xml:",omitempty"`
+	CreateDate       time.Time
+	UpdateDate       time.Time
+}
+
+type policyVersionXML struct {
+	Document         string `xml:",omitempty"`
+	VersionId        string
+	IsDefaultVersion bool
+	CreateDate       time.Time
+}
+
+type roleXML struct {
+	Path                     string
+	RoleName                 string
+	RoleId                   string
+	Arn                      string
+	CreateDate               time.Time
+	AssumeRolePolicyDocument string
+	Description              string `xml:",omitempty"`
+	MaxSessionDuration       int64
+}
+
+type profileXML struct {
+	Path                string
+	InstanceProfileName string
+	InstanceProfileId   string
+	Arn                 string
+	CreateDate          time.Time
+	Roles               []*roleXML `xml:"Roles>member"`
+}
+
+func (s *Server) policyXML(p *policy) *policyXML {
+	return &policyXML{
+		PolicyName:       p.name,
+		PolicyId:         p.id,
+		Arn:              p.arn,
+		Path:             p.path,
+		DefaultVersionId: p.defaultVersion,
+		AttachmentCount:  s.attachments(p.arn),
+		IsAttachable:     true,
+		Description:      p.description,
+		CreateDate:       p.created,
+		UpdateDate:       p.versions[len(p.versions)-1].created,
+	}
+}
+
+// versionXML returns a policy version. Like IAM, the document is URL encoded.
+func (p *policy) versionXML(v *policyVersion, document bool) *policyVersionXML {
+	x := &policyVersionXML{VersionId: v.id, IsDefaultVersion: v.id == p.defaultVersion, CreateDate: v.created}
+	if document {
+		x.Document = url.QueryEscape(v.document)
+	}
+	return x
+}
+
+func (r *role) xml() *roleXML {
+	return &roleXML{
+		Path:                     r.path,
+		RoleName:                 r.name,
+		RoleId:                   r.id,
+		Arn:                      r.arn,
+		CreateDate:               r.created,
+		AssumeRolePolicyDocument: url.QueryEscape(r.assumeRolePolicy),
+		Description:              r.description,
+		MaxSessionDuration:       r.maxSessionDuration,
+	}
+}
+
+func (s *Server) profileXML(p *profile) *profileXML {
+	x := &profileXML{
+		Path:                p.path,
+		InstanceProfileName: p.name,
+		InstanceProfileId:   p.id,
+		Arn:                 p.arn,
+		CreateDate:          p.created,
+	}
+	for _, name := range p.roles {
+		if r, ok := s.roles[name]; ok {
+			x.Roles = append(x.Roles, r.xml())
+		}
+	}
+	return x
+}
+
+//-----------------------------------------------------------------------------
+// Helpers
+//-----------------------------------------------------------------------------
+
+// writeResult writes <Action>Response with the <Action>Result element
+func writeResult(w http.ResponseWriter, action string, result interface{}) {
+
+	w.Header().Set("Content-Type", "text/xml")
+	e := xml.NewEncoder(w)
+
+	start := xml.StartElement{
+		Name: xml.Name{Local: action + "Response"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: xmlns}},
+	}
+
+	e.EncodeToken(start)
+	if result != nil {
+		e.EncodeElement(result, xml.StartElement{Name: xml.Name{Local: action + "Result"}})
+	}
+	e.EncodeElement(struct {
+		RequestId string
+	}{"00000000-0000-0000-0000-000000000000"}, xml.StartElement{Name: xml.Name{Local: "ResponseMetadata"}})
+	e.EncodeToken(start.End())
+	e.Flush()
+}
+
+func writeError(w http.ResponseWriter, aerr *apiError) {
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(aerr.status)
+
+	xml.NewEncoder(w).Encode(struct {
+		XMLName   xml.Name `xml:"ErrorResponse"`
+		Type      string   `xml:"Error>Type"`
+		Code      string   `xml:"Error>Code"`
+		Message   string   `xml:"Error>Message"`
+		RequestId string
+	}{Type: "Sender", Code: aerr.code, Message: aerr.message, RequestId: "00000000-0000-0000-0000-000000000000"})
+}
+
+func noSuchEntity(format string, a ...interface{}) *apiError {
+	return &apiError{http.StatusNotFound, "NoSuchEntity", fmt.Sprintf(format, a...)}
+}
+
+func alreadyExists(kind, name string) *apiError {
+	return &apiError{http.StatusConflict, "EntityAlreadyExists", fmt.Sprintf("A %s with name %s already exists.", kind, name)}
+}
+
+func validDocument(doc string) *apiError {
+	var v map[string]interface{}
+	if err := json.Unmarshal([]byte(doc), &v); err != nil {
+		return &apiError{http.StatusBadRequest, "MalformedPolicyDocument", "Syntax errors in policy."}
+	}
+	return nil
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func remove(list []string, s string) []string {
+	out := []string{}
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func sortedKeys(m map[string]*profile) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
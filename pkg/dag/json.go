@@ -0,0 +1,11 @@
+package dag
+
+import (
+	"encoding/json"
+)
+
+// MarshalJSON returns the JSON representation of the Graph, the same
+// structure the DOT output is generated from.
+func (g *Graph) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newMarshalGraph("", g))
+}
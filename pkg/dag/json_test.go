@@ -0,0 +1,30 @@
+package dag
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestGraphMarshalJSON(t *testing.T) {
+	var g Graph
+	g.Add("a")
+	g.Add("b")
+	g.Connect(BasicEdge("a", "b"))
+
+	b, err := json.Marshal(&g)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var mg struct {
+		Type     string
+		Vertices []struct{ Name string }
+		Edges    []struct{ Name string }
+	}
+	if err := json.Unmarshal(b, &mg); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if mg.Type != "Graph" || len(mg.Vertices) != 2 || mg.Vertices[0].Name != "a" || len(mg.Edges) != 1 || mg.Edges[0].Name != "a|b" {
+		t.Fatalf("bad: %s", b)
+	}
+}
@@ -0,0 +1,21 @@
+package dag
+
+// Results returns, once the walk is complete, the vertices walked without
+// errors, the ones that failed and the ones skipped because a dependency
+// failed. Vertices cancelled before they were walked are in none of them.
+func (w *Walker) Results() (succeeded, failed, skipped []Vertex) {
+	w.diagsLock.Lock()
+	defer w.diagsLock.Unlock()
+
+	for v, diags := range w.diagsMap {
+		if _, upstream := w.upstreamFailed[v]; upstream {
+			skipped = append(skipped, v)
+		} else if diags.HasErrors() {
+			failed = append(failed, v)
+		} else {
+			succeeded = append(succeeded, v)
+		}
+	}
+
+	return succeeded, failed, skipped
+}
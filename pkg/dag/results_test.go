@@ -0,0 +1,38 @@
+package dag
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/h0tbird/terramorph/pkg/tfd"
+)
+
+func TestWalkerResults(t *testing.T) {
+	var g AcyclicGraph
+	g.Add(1)
+	g.Add(2)
+	g.Add(3)
+	g.Add(4)
+	g.Connect(BasicEdge(1, 2))
+	g.Connect(BasicEdge(2, 3))
+
+	w := &Walker{Callback: func(v Vertex) tfd.Diagnostics {
+		var diags tfd.Diagnostics
+		if v == 2 {
+			diags = diags.Append(fmt.Errorf("error"))
+		}
+		return diags
+	}}
+	w.Update(&g)
+	if diags := w.Wait(); !diags.HasErrors() {
+		t.Fatal("expected an error")
+	}
+
+	succeeded, failed, skipped := w.Results()
+	sort.Slice(succeeded, func(i, j int) bool { return succeeded[i].(int) < succeeded[j].(int) })
+	if !reflect.DeepEqual(succeeded, []Vertex{1, 4}) || !reflect.DeepEqual(failed, []Vertex{2}) || !reflect.DeepEqual(skipped, []Vertex{3}) {
+		t.Fatalf("bad: %v %v %v", succeeded, failed, skipped)
+	}
+}
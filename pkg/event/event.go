@@ -0,0 +1,142 @@
+package event
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"encoding/json"
+	"time"
+)
+
+//-----------------------------------------------------------------------------
+// Globals
+//-----------------------------------------------------------------------------
+
+// Kinds of events
+const (
+	WalkStarted Kind = "walk.started"
+	WalkDone    Kind = "walk.done"
+	Importing   Kind = "resource.importing"
+	Upgrading   Kind = "resource.upgrading"
+	Refreshing  Kind = "resource.refreshing"
+	Diffing     Kind = "resource.diffing"
+	Unchanged   Kind = "resource.unchanged"
+	Replacing   Kind = "resource.replacing"
+	Applying    Kind = "resource.applying"
+	Destroying  Kind = "resource.destroying"
+	Retrying    Kind = "resource.retrying"
+	Done        Kind = "resource.done"
+	Failed      Kind = "resource.failed"
+	Skipped     Kind = "resource.skipped"
+)
+
+// Discard drops every event
+var Discard Sink = SinkFunc(func(Event) {})
+
+//-----------------------------------------------------------------------------
+// Types
+//-----------------------------------------------------------------------------
+
+// Kind is what an event is about
+type Kind string
+
+// Event is a step of a walk. Walk events carry the operation, resource
+// events the logical ID and type of the resource. Reason is why a resource
+// was skipped or the step being retried.
+type Event struct {
+	Time      time.Time
+	Kind      Kind
+	Operation string
+	LogicalID string
+	Type      string
+	Diff      []string
+	Attempt   int
+	Duration  time.Duration
+	Error     string
+	Reason    string
+	Resources int
+	Succeeded int
+	Failed    int
+	Skipped   int
+}
+
+// Sink receives the events. Sinks are called from the walk goroutines and
+// must be safe for concurrent use.
+type Sink interface {
+	Emit(Event)
+}
+
+// SinkFunc is a function used as a Sink
+type SinkFunc func(Event)
+
+// Multi sends the events to every sink
+type Multi []Sink
+
+// jsonEvent is the JSON form of an Event
+type jsonEvent struct {
+	Time       time.Time `json:"time"`
+	Kind       Kind      `json:"kind"`
+	Operation  string    `json:"operation,omitempty"`
+	LogicalID  string    `json:"logicalID,omitempty"`
+	Type       string    `json:"type,omitempty"`
+	Diff       []string  `json:"diff,omitempty"`
+	Attempt    int       `json:"attempt,omitempty"`
+	DurationMS int64     `json:"durationMs,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Resources  int       `json:"resources,omitempty"`
+	Succeeded  int       `json:"succeeded,omitempty"`
+	Failed     int       `json:"failed,omitempty"`
+	Skipped    int       `json:"skipped,omitempty"`
+}
+
+//-----------------------------------------------------------------------------
+// Methods
+//-----------------------------------------------------------------------------
+
+// Emit sends e to s, stamped with the current time unless it has one. A nil
+// sink drops it.
+func Emit(s Sink, e Event) {
+	if s == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	s.Emit(e)
+}
+
+// Emit ...
+func (f SinkFunc) Emit(e Event) {
+	f(e)
+}
+
+// Emit ...
+func (m Multi) Emit(e Event) {
+	for _, s := range m {
+		s.Emit(e)
+	}
+}
+
+// MarshalJSON returns the event with the duration in milliseconds
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonEvent{
+		Time:       e.Time,
+		Kind:       e.Kind,
+		Operation:  e.Operation,
+		LogicalID:  e.LogicalID,
+		Type:       e.Type,
+		Diff:       e.Diff,
+		Attempt:    e.Attempt,
+		DurationMS: e.Duration.Milliseconds(),
+		Error:      e.Error,
+		Reason:     e.Reason,
+		Resources:  e.Resources,
+		Succeeded:  e.Succeeded,
+		Failed:     e.Failed,
+		Skipped:    e.Skipped,
+	})
+}
@@ -0,0 +1,58 @@
+package event
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+func TestJSON(t *testing.T) {
+
+	b := &bytes.Buffer{}
+	s := NewJSON(b)
+	Emit(s, Event{Kind: WalkStarted, Operation: "apply", Resources: 2})
+	Emit(s, Event{Kind: Done, LogicalID: "Role", Type: "aws_iam_role", Duration: 1500 * time.Millisecond})
+	Emit(nil, Event{Kind: Done})
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("bad: %s", b)
+	}
+
+	var e map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[1]), &e); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if e["kind"] != "resource.done" || e["logicalID"] != "Role" || e["durationMs"] != float64(1500) || e["time"] == nil {
+		t.Fatalf("bad: %s", lines[1])
+	}
+	if _, ok := e["error"]; ok {
+		t.Fatalf("bad: %s", lines[1])
+	}
+}
+
+func TestHuman(t *testing.T) {
+
+	color.NoColor = true
+	b := &bytes.Buffer{}
+	s := Multi{NewHuman(b), Discard}
+
+	Emit(s, Event{Kind: Applying, LogicalID: "Role", Type: "aws_iam_role", Diff: []string{"name", "path"}})
+	Emit(s, Event{Kind: Diffing, LogicalID: "Role", Type: "aws_iam_role"})
+	Emit(s, Event{Kind: Failed, LogicalID: "Role", Type: "aws_iam_role", Duration: 20 * time.Millisecond, Error: "AccessDenied"})
+	Emit(s, Event{Kind: Skipped, LogicalID: "Profile", Type: "aws_iam_instance_profile", Reason: "a dependency failed"})
+	Emit(s, Event{Kind: WalkDone, Operation: "apply", Duration: 2340 * time.Millisecond, Succeeded: 1, Failed: 1, Skipped: 1})
+
+	expected := `Role (aws_iam_role): applying changes to name, path
+Role (aws_iam_role): failed after 20ms: AccessDenied
+Profile (aws_iam_instance_profile): skipped, a dependency failed
+Finished apply in 2.3s: 1 succeeded, 1 failed, 1 skipped
+`
+	if b.String() != expected {
+		t.Fatalf("bad:\n%s", b)
+	}
+}
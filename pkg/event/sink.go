@@ -0,0 +1,123 @@
+package event
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	// community
+	"github.com/fatih/color"
+)
+
+//-----------------------------------------------------------------------------
+// Types
+//-----------------------------------------------------------------------------
+
+// JSON writes the events as newline-delimited JSON
+type JSON struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// Human writes the events as lines for a terminal
+type Human struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+//-----------------------------------------------------------------------------
+// Constructor
+//-----------------------------------------------------------------------------
+
+// NewJSON ...
+func NewJSON(w io.Writer) *JSON {
+	return &JSON{enc: json.NewEncoder(w)}
+}
+
+// NewHuman ...
+func NewHuman(w io.Writer) *Human {
+	return &Human{w: w}
+}
+
+//-----------------------------------------------------------------------------
+// Methods
+//-----------------------------------------------------------------------------
+
+// Emit ...
+func (j *JSON) Emit(e Event) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.enc.Encode(e)
+}
+
+// Emit ...
+func (h *Human) Emit(e Event) {
+
+	line := h.line(e)
+	if line == "" {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintln(h.w, line)
+}
+
+// line returns the text of e, empty for the events not worth a line
+func (h *Human) line(e Event) string {
+
+	subject := fmt.Sprintf("%s (%s): ", e.LogicalID, e.Type)
+
+	switch e.Kind {
+	case WalkStarted:
+		return color.New(color.Bold).Sprintf("Starting %s of %d resources", e.Operation, e.Resources)
+	case WalkDone:
+		return color.New(color.Bold).Sprintf("Finished %s in %s: %d succeeded, %d failed, %d skipped",
+			e.Operation, round(e.Duration), e.Succeeded, e.Failed, e.Skipped)
+	case Importing:
+		return subject + "importing"
+	case Upgrading:
+		return subject + "upgrading the state"
+	case Refreshing:
+		return subject + "refreshing"
+	case Unchanged:
+		return subject + "up to date"
+	case Replacing:
+		return subject + "replacing, changes to " + strings.Join(e.Diff, ", ")
+	case Applying:
+		return subject + "applying changes to " + strings.Join(e.Diff, ", ")
+	case Destroying:
+		return subject + "destroying"
+	case Retrying:
+		return color.New(color.FgYellow).Sprintf("%sretrying in %s after attempt %d: %s", subject, round(e.Duration), e.Attempt, e.Error)
+	case Done:
+		return color.New(color.FgGreen).Sprintf("%sdone in %s", subject, round(e.Duration))
+	case Failed:
+		return color.New(color.FgRed).Sprintf("%sfailed after %s: %s", subject, round(e.Duration), e.Error)
+	case Skipped:
+		return color.New(color.FgYellow).Sprintf("%sskipped, %s", subject, e.Reason)
+	}
+
+	return ""
+}
+
+//-----------------------------------------------------------------------------
+// Helpers
+//-----------------------------------------------------------------------------
+
+// round keeps durations readable
+func round(d time.Duration) time.Duration {
+	if d < time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(100 * time.Millisecond)
+}
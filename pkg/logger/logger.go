@@ -0,0 +1,190 @@
+package logger
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	// community
+	"github.com/sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// Globals
+//-----------------------------------------------------------------------------
+
+// Levels
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+// Encodings
+const (
+	Console = "console"
+	JSON    = "json"
+)
+
+// Nop drops every entry
+var Nop Logger = nop{}
+
+// levels maps the levels to logrus
+var levels = map[Level]logrus.Level{
+	Debug: logrus.DebugLevel,
+	Info:  logrus.InfoLevel,
+	Warn:  logrus.WarnLevel,
+	Error: logrus.ErrorLevel,
+}
+
+//-----------------------------------------------------------------------------
+// Types
+//-----------------------------------------------------------------------------
+
+// Logger is the structured logger the handlers are given. Fields added by
+// With are kept on every entry of the returned Logger.
+type Logger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+	With(fields ...Field) Logger
+}
+
+// Level is the severity of an entry
+type Level int
+
+// Field is a key and value of an entry
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Config is how New builds a Logger: entries below Level are dropped and
+// the rest are written to Output with the console or json Encoding.
+type Config struct {
+	Level    Level
+	Encoding string
+	Output   io.Writer
+}
+
+// logrusLogger is a Logger on top of a logrus entry
+type logrusLogger struct {
+	entry *logrus.Entry
+}
+
+// nop is a Logger that drops every entry
+type nop struct{}
+
+//-----------------------------------------------------------------------------
+// Constructor
+//-----------------------------------------------------------------------------
+
+// New ...
+func New(c Config) (Logger, error) {
+
+	out := c.Output
+	if out == nil {
+		out = ioutil.Discard
+	}
+
+	l := logrus.New()
+	l.SetLevel(levels[c.Level])
+	l.SetOutput(out)
+
+	switch c.Encoding {
+	case Console, "":
+		l.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	case JSON:
+		l.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return nil, fmt.Errorf("unknown log encoding %q, use console or json", c.Encoding)
+	}
+
+	return &logrusLogger{entry: logrus.NewEntry(l)}, nil
+}
+
+//-----------------------------------------------------------------------------
+// Methods
+//-----------------------------------------------------------------------------
+
+// F returns a Field
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// ParseLevel returns the level named debug, info, warn or error
+func ParseLevel(name string) (Level, error) {
+	for _, l := range []Level{Debug, Info, Warn, Error} {
+		if l.String() == strings.ToLower(name) {
+			return l, nil
+		}
+	}
+	return Info, fmt.Errorf("unknown log level %q, use debug, info, warn or error", name)
+}
+
+// String ...
+func (l Level) String() string {
+	switch l {
+	case Debug:
+		return "debug"
+	case Info:
+		return "info"
+	case Warn:
+		return "warn"
+	case Error:
+		return "error"
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// Debug ...
+func (l *logrusLogger) Debug(msg string, fields ...Field) {
+	l.with(fields).Debug(msg)
+}
+
+// Info ...
+func (l *logrusLogger) Info(msg string, fields ...Field) {
+	l.with(fields).Info(msg)
+}
+
+// Warn ...
+func (l *logrusLogger) Warn(msg string, fields ...Field) {
+	l.with(fields).Warn(msg)
+}
+
+// Error ...
+func (l *logrusLogger) Error(msg string, fields ...Field) {
+	l.with(fields).Error(msg)
+}
+
+// With ...
+func (l *logrusLogger) With(fields ...Field) Logger {
+	return &logrusLogger{entry: l.with(fields)}
+}
+
+// with returns the entry with the fields added
+func (l *logrusLogger) with(fields []Field) *logrus.Entry {
+	if len(fields) == 0 {
+		return l.entry
+	}
+	lf := logrus.Fields{}
+	for _, f := range fields {
+		lf[f.Key] = f.Value
+	}
+	return l.entry.WithFields(lf)
+}
+
+func (nop) Debug(string, ...Field) {}
+func (nop) Info(string, ...Field)  {}
+func (nop) Warn(string, ...Field)  {}
+func (nop) Error(string, ...Field) {}
+func (nop) With(...Field) Logger   { return nop{} }
@@ -0,0 +1,61 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+
+	b := &bytes.Buffer{}
+	l, err := New(Config{Level: Info, Encoding: JSON, Output: b})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	l.Debug("hidden")
+	l.With(F("id", "Role")).Info("shown", F("attempt", 2))
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("bad: %s", b)
+	}
+	var e map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if e["msg"] != "shown" || e["level"] != "info" || e["id"] != "Role" || e["attempt"] != float64(2) {
+		t.Fatalf("bad: %s", lines[0])
+	}
+
+	if _, err := New(Config{Encoding: "yaml"}); err == nil {
+		t.Fatal("expected an error")
+	}
+	Nop.With(F("id", "Role")).Error("dropped")
+}
+
+func TestParseLevel(t *testing.T) {
+	if l, err := ParseLevel("WARN"); err != nil || l != Warn {
+		t.Fatalf("bad: %v %v", l, err)
+	}
+	if _, err := ParseLevel("trace"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestWriter(t *testing.T) {
+
+	b := &bytes.Buffer{}
+	l, _ := New(Config{Level: Debug, Encoding: Console, Output: b})
+
+	std := log.New(Writer(l.With(F("source", "provider")), Debug), "", 0)
+	std.Print("[DEBUG] first\nsecond")
+
+	out := b.String()
+	if strings.Count(out, "level=debug") != 2 || !strings.Contains(out, `msg="[DEBUG] first"`) || !strings.Contains(out, "source=provider") {
+		t.Fatalf("bad: %s", out)
+	}
+}
@@ -0,0 +1,60 @@
+package logger
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"io"
+	"strings"
+)
+
+//-----------------------------------------------------------------------------
+// Types
+//-----------------------------------------------------------------------------
+
+// writer logs the lines written to it
+type writer struct {
+	log   Logger
+	level Level
+}
+
+//-----------------------------------------------------------------------------
+// Constructor
+//-----------------------------------------------------------------------------
+
+// Writer returns an io.Writer that logs every line written to it at level.
+// It lets the standard log package, which the provider SDK writes to, go
+// through a Logger.
+func Writer(l Logger, level Level) io.Writer {
+	return &writer{log: l, level: level}
+}
+
+//-----------------------------------------------------------------------------
+// Methods
+//-----------------------------------------------------------------------------
+
+// Write ...
+func (w *writer) Write(p []byte) (int, error) {
+
+	for _, line := range strings.Split(string(p), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		switch w.level {
+		case Debug:
+			w.log.Debug(line)
+		case Info:
+			w.log.Info(line)
+		case Warn:
+			w.log.Warn(line)
+		default:
+			w.log.Error(line)
+		}
+	}
+
+	return len(p), nil
+}
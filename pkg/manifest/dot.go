@@ -0,0 +1,109 @@
+package manifest
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"encoding/json"
+
+	// terramorph
+	"github.com/h0tbird/terramorph/pkg/dag"
+	"github.com/h0tbird/terramorph/pkg/resource"
+)
+
+//-----------------------------------------------------------------------------
+// Globals
+//-----------------------------------------------------------------------------
+
+// actionColors are the DOT colors of the planned actions
+var actionColors = map[resource.Action]string{
+	resource.Create:  "green4",
+	resource.Update:  "orange",
+	resource.Replace: "purple",
+	resource.Delete:  "red",
+}
+
+//-----------------------------------------------------------------------------
+// Types
+//-----------------------------------------------------------------------------
+
+// graphNode is a resource as drawn in the graph output. Nodes are values
+// hashed by logical ID so the output does not depend on memory addresses.
+type graphNode struct {
+	r      *resource.Handler
+	action resource.Action
+}
+
+//-----------------------------------------------------------------------------
+// Methods
+//-----------------------------------------------------------------------------
+
+// Dot returns the DAG in the DOT language. Resources are labelled with their
+// logical ID and type and, given the changes of a plan, colored by their
+// planned action. Edges go from a dependency to its dependents.
+func (h *Handler) Dot(changes []*resource.Change) []byte {
+	return h.displayGraph(changes).Dot(&dag.DotOpts{DrawCycles: true, MaxDepth: -1, Verbose: true})
+}
+
+// GraphJSON returns the DAG in the JSON form of pkg/dag
+func (h *Handler) GraphJSON() ([]byte, error) {
+	return json.Marshal(h.displayGraph(nil))
+}
+
+// displayGraph returns the resources of the DAG and the edges between them.
+// The root vertex and broken references are left out, cycles are kept.
+func (h *Handler) displayGraph(changes []*resource.Change) *dag.Graph {
+
+	actions := map[string]resource.Action{}
+	for _, c := range changes {
+		actions[c.LogicalID] = c.Action
+	}
+
+	g := &dag.Graph{}
+	nodes := map[*resource.Handler]graphNode{}
+	for _, r := range h.Resources {
+		nodes[r] = graphNode{r: r, action: actions[r.ResourceLogicalID]}
+		g.Add(nodes[r])
+	}
+
+	d, _ := h.Graph()
+	for _, e := range d.Edges() {
+		source, ok := e.Source().(*resource.Handler)
+		if !ok {
+			continue
+		}
+		g.Connect(dag.BasicEdge(nodes[source], nodes[e.Target().(*resource.Handler)]))
+	}
+
+	return g
+}
+
+// Name ...
+func (n graphNode) Name() string {
+	return n.r.ResourceLogicalID
+}
+
+// Hashcode ...
+func (n graphNode) Hashcode() interface{} {
+	return n.r.ResourceLogicalID
+}
+
+// DotNode ...
+func (n graphNode) DotNode(name string, opts *dag.DotOpts) *dag.DotNode {
+
+	attrs := map[string]string{
+		"label": n.r.ResourceLogicalID + "\n" + n.r.ResourceType,
+		"shape": "box",
+	}
+
+	if c, ok := actionColors[n.action]; ok {
+		attrs["color"] = c
+		attrs["fontcolor"] = c
+		attrs["xlabel"] = string(n.action)
+	}
+
+	return &dag.DotNode{Name: name, Attrs: attrs}
+}
@@ -0,0 +1,38 @@
+package manifest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/h0tbird/terramorph/pkg/resource"
+)
+
+func TestDot(t *testing.T) {
+
+	h := New()
+	h.Resources["role"] = &resource.Handler{ResourceLogicalID: "Role", ResourceType: "test_role", ResourceConfig: map[string]interface{}{}}
+	h.Resources["profile"] = &resource.Handler{ResourceLogicalID: "Profile", ResourceType: "test_profile", ResourceConfig: map[string]interface{}{"role": "role.ResourceState.ID"}}
+
+	dot := string(h.Dot([]*resource.Change{{LogicalID: "Profile", Action: resource.Create}, {LogicalID: "Role", Action: resource.NoOp}}))
+
+	for _, expected := range []string{
+		`"[root] Profile" [color = "green4", fontcolor = "green4", label = "Profile\ntest_profile", shape = "box", xlabel = "create"]`,
+		`"[root] Role" [label = "Role\ntest_role", shape = "box"]`,
+		`"[root] Role" -> "[root] Profile"`,
+	} {
+		if !strings.Contains(dot, expected) {
+			t.Fatalf("missing %s in:\n%s", expected, dot)
+		}
+	}
+	if strings.Count(dot, "->") != 1 {
+		t.Fatalf("bad:\n%s", dot)
+	}
+
+	b, err := h.GraphJSON()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(string(b), `"Name":"Role|Profile"`) {
+		t.Fatalf("bad: %s", b)
+	}
+}
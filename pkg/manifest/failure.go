@@ -0,0 +1,102 @@
+package manifest
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"sort"
+
+	// terramorph
+	"github.com/h0tbird/terramorph/pkg/dag"
+	"github.com/h0tbird/terramorph/pkg/resource"
+)
+
+//-----------------------------------------------------------------------------
+// Globals
+//-----------------------------------------------------------------------------
+
+const (
+	// FailFast starts no more resources after the first failure, the default
+	FailFast FailurePolicy = "fail-fast"
+	// Continue carries on with every resource but the ones using the state
+	// of a failed or skipped one, which would be unknown to them. Destroying,
+	// the ones a failed or skipped resource refers to are kept.
+	Continue FailurePolicy = "continue"
+	// ContinueIndependent carries on with the resources that do not depend
+	// on a failed one
+	ContinueIndependent FailurePolicy = "continue-independent"
+)
+
+//-----------------------------------------------------------------------------
+// Types
+//-----------------------------------------------------------------------------
+
+// FailurePolicy is what a walk does when a resource fails
+type FailurePolicy string
+
+// Summary is the outcome of the last walk, by logical ID. Skipped resources
+// depend on a failed one or were never started.
+type Summary struct {
+	Succeeded []string `json:"succeeded"`
+	Failed    []string `json:"failed"`
+	Skipped   []string `json:"skipped"`
+}
+
+//-----------------------------------------------------------------------------
+// Methods
+//-----------------------------------------------------------------------------
+
+// Summary returns the outcome of the last walk
+func (h *Handler) Summary() Summary {
+	h.l.Lock()
+	defer h.l.Unlock()
+	return h.summary
+}
+
+// setSummary records the outcome of a walk
+func (h *Handler) setSummary(s Summary) {
+	h.l.Lock()
+	defer h.l.Unlock()
+	h.summary = s
+}
+
+// summarize returns the outcome of a walk of the resources considered. The
+// failures hidden from the walker by Continue are in failed.
+func summarize(w *dag.Walker, considered []*resource.Handler, started, failed map[*resource.Handler]bool) Summary {
+
+	outcome := map[*resource.Handler]string{}
+	succeededV, failedV, _ := w.Results()
+	for _, v := range succeededV {
+		if rh, ok := v.(*resource.Handler); ok && started[rh] {
+			outcome[rh] = "succeeded"
+		}
+	}
+	for _, v := range failedV {
+		if rh, ok := v.(*resource.Handler); ok {
+			outcome[rh] = "failed"
+		}
+	}
+	for rh := range failed {
+		outcome[rh] = "failed"
+	}
+
+	s := Summary{Succeeded: []string{}, Failed: []string{}, Skipped: []string{}}
+	for _, rh := range considered {
+		switch outcome[rh] {
+		case "succeeded":
+			s.Succeeded = append(s.Succeeded, rh.ResourceLogicalID)
+		case "failed":
+			s.Failed = append(s.Failed, rh.ResourceLogicalID)
+		default:
+			s.Skipped = append(s.Skipped, rh.ResourceLogicalID)
+		}
+	}
+	sort.Strings(s.Succeeded)
+	sort.Strings(s.Failed)
+	sort.Strings(s.Skipped)
+
+	return s
+}
@@ -0,0 +1,151 @@
+package manifest
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/h0tbird/terramorph/pkg/event"
+	"github.com/h0tbird/terramorph/pkg/resource"
+)
+
+func TestRunFailurePolicy(t *testing.T) {
+
+	cases := map[FailurePolicy]struct {
+		summary Summary
+		ran     []string
+	}{
+		"": {
+			Summary{Succeeded: []string{"Role"}, Failed: []string{"Policy"}, Skipped: []string{"Attachment", "Copy", "Profile"}},
+			[]string{"Policy", "Role"},
+		},
+		FailFast: {
+			Summary{Succeeded: []string{"Role"}, Failed: []string{"Policy"}, Skipped: []string{"Attachment", "Copy", "Profile"}},
+			[]string{"Policy", "Role"},
+		},
+		ContinueIndependent: {
+			Summary{Succeeded: []string{"Profile", "Role"}, Failed: []string{"Policy"}, Skipped: []string{"Attachment", "Copy"}},
+			[]string{"Policy", "Profile", "Role"},
+		},
+		Continue: {
+			Summary{Succeeded: []string{"Copy", "Profile", "Role"}, Failed: []string{"Policy"}, Skipped: []string{"Attachment"}},
+			[]string{"Copy", "Policy", "Profile", "Role"},
+		},
+	}
+
+	for policy, tc := range cases {
+		t.Run(string(policy), func(t *testing.T) {
+
+			// The profile uses the config of the role, the copy the config
+			// of the policy and the attachment its state
+			h := testWalk(t)
+			h.Resources["profile"] = &resource.Handler{ResourceLogicalID: "Profile", ResourceType: "test_role", ResourceConfig: map[string]interface{}{
+				"name": "role.ResourceConfig.name",
+			}}
+			h.Resources["copy"] = &resource.Handler{ResourceLogicalID: "Copy", ResourceType: "test_policy", ResourceConfig: map[string]interface{}{
+				"document": "policy.ResourceConfig.document",
+			}}
+			if diags := h.graph(); diags.HasErrors() {
+				t.Fatalf("err: %s", diags.Err())
+			}
+			h.Parallelism = 10
+			h.OnFailure = policy
+
+			var l sync.Mutex
+			reasons := map[string]string{}
+			reported := make(chan struct{})
+			h.Events = event.SinkFunc(func(e event.Event) {
+				switch e.Kind {
+				case event.Failed:
+					close(reported)
+				case event.Skipped:
+					l.Lock()
+					reasons[e.LogicalID] = e.Reason
+					l.Unlock()
+				}
+			})
+
+			// The policy fails while the role is in flight, which finishes
+			// once the failure is reported
+			ran := map[*resource.Handler]bool{}
+			inFlight := make(chan struct{})
+			diags := h.run("apply", &h.Dag, nil, func(rh *resource.Handler, p *Provider) error {
+				l.Lock()
+				ran[rh] = true
+				l.Unlock()
+				switch rh.ResourceLogicalID {
+				case "Policy":
+					<-inFlight
+					return errors.New("AccessDenied")
+				case "Role":
+					close(inFlight)
+					<-reported
+				}
+				return nil
+			})
+
+			if !diags.HasErrors() {
+				t.Fatal("expected an error")
+			}
+			if got := h.Summary(); !reflect.DeepEqual(got, tc.summary) {
+				t.Fatalf("bad: %#v", got)
+			}
+			if got := logicalIDs(ran); !reflect.DeepEqual(got, tc.ran) {
+				t.Fatalf("bad: %v", got)
+			}
+
+			// The attachment is never run on the unknown state of the policy
+			if reasons["Attachment"] != "a dependency failed" {
+				t.Fatalf("bad: %v", reasons)
+			}
+		})
+	}
+
+	// Destroying, what a failed resource refers to is kept
+	t.Run("destroy", func(t *testing.T) {
+		h := testWalk(t)
+		h.Resources["profile"] = &resource.Handler{ResourceLogicalID: "Profile", ResourceType: "test_role", ResourceConfig: map[string]interface{}{
+			"name": "role.ResourceConfig.name",
+		}}
+		if diags := h.graph(); diags.HasErrors() {
+			t.Fatalf("err: %s", diags.Err())
+		}
+		h.Parallelism = 10
+		h.OnFailure = Continue
+
+		var l sync.Mutex
+		ran := map[*resource.Handler]bool{}
+		diags := h.run("destroy", h.reversed(), nil, func(rh *resource.Handler, p *Provider) error {
+			l.Lock()
+			ran[rh] = true
+			l.Unlock()
+			if rh.ResourceLogicalID == "Attachment" {
+				return errors.New("DeleteConflict")
+			}
+			return nil
+		})
+
+		if !diags.HasErrors() {
+			t.Fatal("expected an error")
+		}
+		expected := Summary{Succeeded: []string{"Profile"}, Failed: []string{"Attachment"}, Skipped: []string{"Policy", "Role"}}
+		if got := h.Summary(); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("bad: %#v", got)
+		}
+		if got := logicalIDs(ran); !reflect.DeepEqual(got, []string{"Attachment", "Profile"}) {
+			t.Fatalf("bad: %v", got)
+		}
+	})
+
+	// Unknown policies are rejected
+	h := testWalk(t)
+	h.OnFailure = "retry"
+	diags := h.run("apply", &h.Dag, nil, func(rh *resource.Handler, p *Provider) error {
+		t.Fatalf("bad: %s ran", rh.ResourceLogicalID)
+		return nil
+	})
+	if len(diags) != 1 || diags[0].Description().Summary != "Invalid failure policy" {
+		t.Fatalf("bad: %v", diags)
+	}
+}
@@ -0,0 +1,223 @@
+package manifest
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	// community
+	"gopkg.in/yaml.v2"
+
+	// terramorph
+	"github.com/h0tbird/terramorph/pkg/resource"
+)
+
+//-----------------------------------------------------------------------------
+// Types
+//-----------------------------------------------------------------------------
+
+// File is the YAML form of a manifest. JSON is valid YAML so both work:
+//
+//	variables:
+//	  region: us-east-2
+//	providers:
+//	  aws:
+//	    config:
+//	      region: var.region
+//	resources:
+//	  nodesRole:
+//	    logicalID: NodesRole
+//	    type: aws_iam_role
+//	    config:
+//	      name: nodes
+//	outputs:
+//	  nodesRoleArn: nodesRole.ResourceState.arn
+type File struct {
+	Variables map[string]interface{}   `yaml:"variables"`
+	Providers map[string]*ProviderFile `yaml:"providers"`
+	Resources map[string]*ResourceFile `yaml:"resources"`
+	Outputs   map[string]string        `yaml:"outputs"`
+}
+
+// ProviderFile is a provider block of a File
+type ProviderFile struct {
+	Name   string                 `yaml:"name"`
+	Path   string                 `yaml:"path"`
+	Config map[string]interface{} `yaml:"config"`
+}
+
+// ResourceFile is a resource of a File. The logical ID defaults to the key.
+type ResourceFile struct {
+	LogicalID string                 `yaml:"logicalID"`
+	Type      string                 `yaml:"type"`
+	Provider  string                 `yaml:"provider"`
+	Config    map[string]interface{} `yaml:"config"`
+}
+
+//-----------------------------------------------------------------------------
+// Functions
+//-----------------------------------------------------------------------------
+
+// Load reads a manifest file
+func Load(path string) (*Handler, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h, err := Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return h, nil
+}
+
+// Decode reads a manifest
+func Decode(r io.Reader) (*Handler, error) {
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &File{}
+	if err := yaml.UnmarshalStrict(data, f); err != nil {
+		return nil, err
+	}
+
+	h := New()
+
+	for k, v := range f.Variables {
+		h.Variables[k] = normalize(v)
+	}
+
+	for alias, p := range f.Providers {
+		if p == nil {
+			p = &ProviderFile{}
+		}
+		h.Providers[alias] = &Provider{
+			Name:   p.Name,
+			Path:   p.Path,
+			Config: normalize(p.Config).(map[string]interface{}),
+		}
+	}
+
+	keys := []string{}
+	for k := range f.Resources {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	logicalIDs := map[string]string{}
+	for _, k := range keys {
+
+		r := f.Resources[k]
+		if r == nil || r.Type == "" {
+			return nil, fmt.Errorf("resource %s: type is required", k)
+		}
+
+		if r.LogicalID == "" {
+			r.LogicalID = k
+		}
+		if !resource.LogicalIDReg.MatchString(r.LogicalID) {
+			return nil, fmt.Errorf("resource %s: invalid logical ID %q", k, r.LogicalID)
+		}
+		if other, ok := logicalIDs[r.LogicalID]; ok {
+			return nil, fmt.Errorf("resources %s and %s have the same logical ID %s", other, k, r.LogicalID)
+		}
+		logicalIDs[r.LogicalID] = k
+
+		h.Resources[k] = &resource.Handler{
+			ResourceLogicalID: r.LogicalID,
+			ResourceType:      r.Type,
+			Provider:          r.Provider,
+			ResourceConfig:    normalize(r.Config).(map[string]interface{}),
+		}
+	}
+
+	for k, v := range f.Outputs {
+		h.Outputs[k] = v
+	}
+
+	return h, nil
+}
+
+// LoadVariables reads a YAML or JSON file of variables
+func LoadVariables(path string) (map[string]interface{}, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &vars); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	for k, v := range vars {
+		vars[k] = normalize(v)
+	}
+
+	return vars, nil
+}
+
+//-----------------------------------------------------------------------------
+// Helpers
+//-----------------------------------------------------------------------------
+
+// normalize turns the maps decoded by yaml into map[string]interface{}, the
+// form the providers expect. A nil map becomes an empty one.
+func normalize(v interface{}) interface{} {
+
+	switch v := v.(type) {
+
+	case nil:
+		return map[string]interface{}{}
+
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			m[key] = normalizeValue(val)
+		}
+		return m
+
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			m[fmt.Sprint(key)] = normalizeValue(val)
+		}
+		return m
+	}
+
+	return normalizeValue(v)
+}
+
+func normalizeValue(v interface{}) interface{} {
+
+	switch v := v.(type) {
+
+	case map[string]interface{}, map[interface{}]interface{}:
+		return normalize(v)
+
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, val := range v {
+			l[i] = normalizeValue(val)
+		}
+		return l
+	}
+
+	return v
+}
@@ -0,0 +1,145 @@
+package manifest
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+
+	"github.com/h0tbird/terramorph/pkg/state"
+)
+
+const testManifest = `
+variables:
+  region: us-east-2
+providers:
+  aws:
+    config:
+      region: var.region
+      assume_role:
+        - role_arn: arn:role
+resources:
+  nodesRole:
+    logicalID: NodesRole
+    type: aws_iam_role
+    config:
+      name: nodes
+      timeouts:
+        create: 1m
+  nodesProfile:
+    type: aws_iam_instance_profile
+    config:
+      role: nodesRole.ResourceConfig.name
+outputs:
+  roleArn: nodesRole.ResourceState.arn
+  roleID: nodesRole.ResourceState.ID
+  roleName: nodesRole.ResourceConfig.name
+`
+
+func TestDecode(t *testing.T) {
+
+	h, err := Decode(strings.NewReader(testManifest))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if h.Variables["region"] != "us-east-2" {
+		t.Fatalf("bad: %#v", h.Variables)
+	}
+
+	expected := map[string]interface{}{
+		"region":      "var.region",
+		"assume_role": []interface{}{map[string]interface{}{"role_arn": "arn:role"}},
+	}
+	if !reflect.DeepEqual(h.Providers["aws"].Config, expected) {
+		t.Fatalf("bad: %#v", h.Providers["aws"].Config)
+	}
+
+	r := h.Resources["nodesRole"]
+	if r.ResourceLogicalID != "NodesRole" || r.ResourceType != "aws_iam_role" {
+		t.Fatalf("bad: %#v", r)
+	}
+	if !reflect.DeepEqual(r.ResourceConfig["timeouts"], map[string]interface{}{"create": "1m"}) {
+		t.Fatalf("bad: %#v", r.ResourceConfig)
+	}
+
+	// The logical ID defaults to the key
+	if id := h.Resources["nodesProfile"].ResourceLogicalID; id != "nodesProfile" {
+		t.Fatalf("bad: %s", id)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		manifest string
+		expected string
+	}{
+		"unknown key":  {"resorces: {}", "resorces"},
+		"missing type": {"resources: {role: {config: {}}}", "type is required"},
+		"duplicate":    {"resources: {a: {type: t, logicalID: X}, b: {type: t, logicalID: X}}", "same logical ID X"},
+		"path":         {"resources: {a: {type: t, logicalID: ../X}}", `invalid logical ID "../X"`},
+		"hidden":       {"resources: {.a: {type: t}}", `invalid logical ID ".a"`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Decode(strings.NewReader(tc.manifest))
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Fatalf("bad: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadVariables(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "vars.json")
+	if err := ioutil.WriteFile(path, []byte(`{"region": "eu-west-1", "tags": {"team": "infra"}}`), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	vars, err := LoadVariables(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]interface{}{"region": "eu-west-1", "tags": map[string]interface{}{"team": "infra"}}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Fatalf("bad: %#v", vars)
+	}
+}
+
+func TestOutputValues(t *testing.T) {
+
+	h, err := Decode(strings.NewReader(testManifest))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	s := state.NewMemory()
+
+	// Nothing but config outputs before the role exists
+	values, err := h.OutputValues(s)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(values, map[string]string{"roleName": "nodes"}) {
+		t.Fatalf("bad: %#v", values)
+	}
+
+	s.Write("NodesRole", &terraform.InstanceState{ID: "nodes", Attributes: map[string]string{"arn": "arn:nodes"}})
+	values, err = h.OutputValues(s)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := map[string]string{"roleArn": "arn:nodes", "roleID": "nodes", "roleName": "nodes"}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("bad: %#v", values)
+	}
+
+	// Outputs must reference declared resources
+	h.Outputs["bad"] = "nope.ResourceState.ID"
+	if diags := h.validateOutputs(); len(diags) != 1 {
+		t.Fatalf("bad: %v", diags)
+	}
+}
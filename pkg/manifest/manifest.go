@@ -8,16 +8,20 @@ import (
 
 	// stdlib
 	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
 
-	// community
-	"github.com/sirupsen/logrus"
-
 	// terraform
-	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
 
 	// terramorph
 	"github.com/h0tbird/terramorph/pkg/dag"
+	"github.com/h0tbird/terramorph/pkg/event"
+	"github.com/h0tbird/terramorph/pkg/logger"
+	"github.com/h0tbird/terramorph/pkg/provider"
 	"github.com/h0tbird/terramorph/pkg/resource"
 	"github.com/h0tbird/terramorph/pkg/tfd"
 )
@@ -28,10 +32,27 @@ import (
 
 // Handler ...
 type Handler struct {
-	Resources map[string]*resource.Handler
-	Dag       dag.AcyclicGraph
+	Providers   map[string]*Provider
+	Variables   map[string]interface{}
+	Resources   map[string]*resource.Handler
+	Outputs     map[string]string
+	Parallelism int
+	Targets     []string
+	Stop        <-chan struct{}
+	OnFailure   FailurePolicy
+	Events      event.Sink
+	Log         logger.Logger
+	Dag         dag.AcyclicGraph
+	registry    *provider.Registry
+	l           sync.Mutex
+	inFlight    map[*resource.Handler]bool
+	summary     Summary
 }
 
+// rootVertex is the root of the DAG, resources without dependencies hang
+// from it
+type rootVertex struct{}
+
 //-----------------------------------------------------------------------------
 // Methods
 //-----------------------------------------------------------------------------
@@ -39,60 +60,392 @@ type Handler struct {
 // New ...
 func New() *Handler {
 	return &Handler{
+		Providers: map[string]*Provider{},
+		Variables: map[string]interface{}{},
 		Resources: map[string]*resource.Handler{},
+		Outputs:   map[string]string{},
 		Dag:       dag.AcyclicGraph{},
 	}
 }
 
 // Apply ...
-func (h *Handler) Apply(ctx context.Context, p *schema.Provider, s resource.State) tfd.Diagnostics {
+func (h *Handler) Apply(ctx context.Context, s resource.State) tfd.Diagnostics {
+
+	var diags tfd.Diagnostics
+	h.setSummary(Summary{})
+
+	// Validate everything before the first change
+	diags = diags.Append(h.Validate(ctx))
+	if diags.HasErrors() {
+		return diags
+	}
 
 	// Setup the DAG
-	for resKey, resVal := range h.Resources {
+	diags = diags.Append(h.graph())
+	if diags.HasErrors() {
+		return diags
+	}
+	only, targetDiags := h.selection(false)
+	diags = diags.Append(targetDiags)
+	if diags.HasErrors() {
+		return diags
+	}
+
+	// Walk the DAG
+	return diags.Append(h.run("apply", &h.Dag, only, func(rh *resource.Handler, p *Provider) error {
+		if p.Plugin() != nil {
+			return rh.ReconcilePlugin(ctx, p.Plugin(), s, h.Resources)
+		}
+		return rh.Reconcile(ctx, p.Instance(), s, h.Resources)
+	}))
+}
+
+// ApplyPlan is Apply limited to the changes of a plan, as approved. A
+// resource whose change is different by then fails instead of changing.
+func (h *Handler) ApplyPlan(ctx context.Context, s resource.State, changes []*resource.Change) tfd.Diagnostics {
+
+	approved := map[string]*resource.Change{}
+	for _, c := range changes {
+		approved[c.LogicalID] = c
+	}
+
+	for _, r := range h.Resources {
+		r.Approved = approved[r.ResourceLogicalID]
+		if r.Approved == nil {
+			r.Approved = &resource.Change{LogicalID: r.ResourceLogicalID, Type: r.ResourceType, Action: resource.NoOp}
+		}
+	}
+	defer func() {
+		for _, r := range h.Resources {
+			r.Approved = nil
+		}
+	}()
+
+	return h.Apply(ctx, s)
+}
+
+// Plan returns the changes Apply would make, sorted by logical ID. Values
+// that depend on resources to be created are unknown to their dependents.
+func (h *Handler) Plan(ctx context.Context, s resource.State) ([]*resource.Change, tfd.Diagnostics) {
+
+	var diags tfd.Diagnostics
+	var l sync.Mutex
+	changes := []*resource.Change{}
+	h.setSummary(Summary{})
+
+	diags = diags.Append(h.Validate(ctx))
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	diags = diags.Append(h.graph())
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	only, targetDiags := h.selection(false)
+	diags = diags.Append(targetDiags)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	diags = diags.Append(h.run("plan", &h.Dag, only, func(rh *resource.Handler, p *Provider) error {
+		var c *resource.Change
+		var err error
+		if p.Plugin() != nil {
+			c, err = rh.PlanPlugin(ctx, p.Plugin(), s, h.Resources)
+		} else {
+			c, err = rh.Plan(ctx, p.Instance(), s, h.Resources)
+		}
+		if err == nil {
+			l.Lock()
+			changes = append(changes, c)
+			l.Unlock()
+		}
+		return err
+	}))
+	sort.Slice(changes, func(i, j int) bool { return changes[i].LogicalID < changes[j].LogicalID })
+
+	return changes, diags
+}
+
+// PlanDestroy returns the changes Destroy would make: a delete for every
+// resource with a state, sorted by logical ID.
+func (h *Handler) PlanDestroy(s resource.State) ([]*resource.Change, error) {
+
+	changes := []*resource.Change{}
+
+	diags := h.graph()
+	if diags.HasErrors() {
+		return nil, diags.Err()
+	}
+	only, targetDiags := h.selection(true)
+	if targetDiags.HasErrors() {
+		return nil, targetDiags.Err()
+	}
+
+	for _, r := range h.Resources {
+		if only != nil && !only[r] {
+			continue
+		}
+		is := &terraform.InstanceState{}
+		if err := s.Read(r.ResourceLogicalID, is); err != nil {
+			return nil, err
+		}
+		if is.ID != "" {
+			changes = append(changes, &resource.Change{LogicalID: r.ResourceLogicalID, Type: r.ResourceType, Action: resource.Delete})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].LogicalID < changes[j].LogicalID })
+	return changes, nil
+}
+
+// Destroy deletes every resource of the manifest, dependents first
+func (h *Handler) Destroy(ctx context.Context, s resource.State) tfd.Diagnostics {
+
+	var diags tfd.Diagnostics
+	h.setSummary(Summary{})
+
+	for _, r := range h.Resources {
+		if _, err := h.Provider(r); err != nil {
+			diags = diags.Append(err)
+		}
+	}
+	if diags.HasErrors() {
+		return diags
+	}
+
+	diags = diags.Append(h.graph())
+	if diags.HasErrors() {
+		return diags
+	}
+	only, targetDiags := h.selection(true)
+	diags = diags.Append(targetDiags)
+	if diags.HasErrors() {
+		return diags
+	}
+
+	return diags.Append(h.run("destroy", h.reversed(), only, func(rh *resource.Handler, p *Provider) error {
+		if p.Plugin() != nil {
+			return rh.DestroyPlugin(ctx, p.Plugin(), s)
+		}
+		return rh.Destroy(ctx, p.Instance(), s)
+	}))
+}
+
+// Import adopts an existing resource, given by its key or logical ID, under
+// the ID the provider knows it by
+func (h *Handler) Import(ctx context.Context, s resource.State, key, id string) tfd.Diagnostics {
+
+	var diags tfd.Diagnostics
+
+	rh, ok := h.lookup(key)
+	if !ok {
+		return diags.Append(tfd.Sourceless(tfd.Error, "Unknown resource",
+			fmt.Sprintf("%q is not declared in the manifest", key)))
+	}
+
+	p, err := h.Provider(rh)
+	if err != nil {
+		return diags.Append(tfd.Sourceless(tfd.Error, "Invalid resource", err.Error()))
+	}
+
+	rh.Events, rh.Log = h.Events, h.Log
+	if p.Plugin() != nil {
+		err = rh.ImportPlugin(ctx, p.Plugin(), s, id)
+	} else {
+		err = rh.Import(ctx, p.Instance(), s, id)
+	}
+	if err != nil {
+		diags = diags.Append(tfd.Sourceless(tfd.Error,
+			fmt.Sprintf("%s (%s) import failed", rh.ResourceLogicalID, rh.ResourceType), err.Error()))
+	}
+
+	return diags
+}
+
+// Graph returns the DAG of the resources and the problems found building it.
+// Resources without dependencies hang from the root vertex and, when no
+// errors are found, redundant edges are removed by transitive reduction.
+func (h *Handler) Graph() (*dag.AcyclicGraph, tfd.Diagnostics) {
+	diags := h.graph()
+	return &h.Dag, diags
+}
+
+// reversed returns the DAG of the resources with its edges reversed, so
+// dependents come first
+func (h *Handler) reversed() *dag.AcyclicGraph {
+	g := &dag.AcyclicGraph{}
+	for _, r := range h.Resources {
+		g.Add(r)
+	}
+	for _, e := range h.Dag.Edges() {
+		if _, ok := e.Source().(*resource.Handler); ok {
+			g.Connect(dag.BasicEdge(e.Target(), e.Source()))
+		}
+	}
+	return g
+}
+
+// graph builds the DAG of the resources from their references. References to
+// undeclared resources and to the resource itself are left out of the DAG
+// and reported along with the dependency cycles.
+func (h *Handler) graph() tfd.Diagnostics {
+
+	var diags tfd.Diagnostics
+	h.Dag = dag.AcyclicGraph{}
+	h.Dag.Add(rootVertex{})
+
+	// The arguments behind each edge, to explain cycles
+	type edge struct{ from, to *resource.Handler }
+	args := map[edge][]string{}
+
+	for _, resKey := range h.keys() {
 
 		// All vertices
+		resVal := h.Resources[resKey]
 		h.Dag.Add(resVal)
 		match := false
 
 		// Dependent edges
-		for _, fieldVal := range resVal.ResourceConfig {
-			submatch := resource.Reg.FindStringSubmatch(fieldVal.(string))
-			if submatch != nil {
-				h.Dag.Connect(dag.BasicEdge(h.Resources[submatch[1]], h.Resources[resKey]))
-				match = true
+		for _, fieldKey := range sortedFields(resVal.ResourceConfig) {
+			str, _ := resVal.ResourceConfig[fieldKey].(string)
+			submatch := resource.Reg.FindStringSubmatch(str)
+			if submatch == nil {
+				continue
+			}
+
+			target, ok := h.Resources[submatch[1]]
+			switch {
+			case !ok:
+				diags = diags.Append(undeclaredReference(resVal, fieldKey, submatch[1]))
+				continue
+			case target == resVal:
+				diags = diags.Append(tfd.Sourceless(tfd.Error, "Self reference",
+					fmt.Sprintf("%s: argument %q references the resource itself", resVal.ResourceLogicalID, fieldKey)))
+				continue
 			}
+
+			h.Dag.Connect(dag.BasicEdge(target, resVal))
+			args[edge{target, resVal}] = append(args[edge{target, resVal}], fieldKey)
+			match = true
 		}
 
 		// Non-dependent edges
 		if !match {
-			h.Dag.Connect(dag.BasicEdge(0, h.Resources[resKey]))
+			h.Dag.Connect(dag.BasicEdge(rootVertex{}, resVal))
 		}
 	}
 
-	// Walk the DAG
-	w := &dag.Walker{Callback: walk(ctx, p, s, h.Resources)}
-	w.Update(&h.Dag)
+	// Dependency cycles
+	for _, cycle := range h.Dag.Cycles() {
 
-	// Return tfd.Diagnostics
-	return w.Wait()
+		in := map[*resource.Handler]bool{}
+		ids := []string{}
+		for _, v := range cycle {
+			in[v.(*resource.Handler)] = true
+			ids = append(ids, v.(*resource.Handler).ResourceLogicalID)
+		}
+		sort.Strings(ids)
+
+		refs := []string{}
+		for e, fields := range args {
+			if in[e.from] && in[e.to] {
+				for _, f := range fields {
+					refs = append(refs, fmt.Sprintf("%s.%s references %s", e.to.ResourceLogicalID, f, e.from.ResourceLogicalID))
+				}
+			}
+		}
+		sort.Strings(refs)
+
+		diags = diags.Append(tfd.Sourceless(tfd.Error, "Dependency cycle",
+			fmt.Sprintf("%s depend on each other: %s", strings.Join(ids, ", "), strings.Join(refs, "; "))))
+	}
+
+	if diags.HasErrors() {
+		return diags
+	}
+
+	if err := h.Dag.Validate(); err != nil {
+		return diags.Append(tfd.Sourceless(tfd.Error, "Invalid dependency graph", err.Error()))
+	}
+	h.Dag.TransitiveReduction()
+
+	return diags
+}
+
+// log returns Log, or a logger dropping everything without one
+func (h *Handler) log() logger.Logger {
+	if h.Log == nil {
+		return logger.Nop
+	}
+	return h.Log
+}
+
+// keys returns the keys of the resources, sorted
+func (h *Handler) keys() []string {
+	keys := []string{}
+	for k := range h.Resources {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Name ...
+func (rootVertex) Name() string {
+	return "root"
 }
 
 //-----------------------------------------------------------------------------
 // walk
 //-----------------------------------------------------------------------------
 
-func walk(ctx context.Context, p *schema.Provider, s resource.State, r map[string]*resource.Handler) dag.WalkFunc {
-	var l sync.Mutex
+// walk runs op on each resource with its provider, or only on the resources
+// of only when it is not nil. Up to Parallelism resources are handled at a
+// time, one by default, and an error skips the resources depending on it.
+// Nothing is started once halt or Stop is closed.
+func walk(h *Handler, only map[*resource.Handler]bool, halt <-chan struct{}, op func(*resource.Handler, *Provider) error) dag.WalkFunc {
+	n := h.Parallelism
+	if n < 1 {
+		n = 1
+	}
+	sem := make(chan struct{}, n)
 	return func(v dag.Vertex) tfd.Diagnostics {
-		l.Lock()
-		defer l.Unlock()
 
-		rh := v.(*resource.Handler)
-		if err := rh.Reconcile(ctx, p, s, r); err != nil {
-			// TODO: Return diagnostics
-			logrus.Fatal(err)
+		var diags tfd.Diagnostics
+
+		rh, ok := v.(*resource.Handler)
+		if !ok || (only != nil && !only[rh]) {
+			return diags
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-halt:
+			return diags
+		case <-h.Stop:
+			return diags
+		}
+		defer func() { <-sem }()
+		if closed(halt) || closed(h.Stop) {
+			return diags
+		}
+
+		h.track(rh, true)
+		defer h.track(rh, false)
+
+		p, _ := h.Provider(rh)
+		err := op(rh, p)
+		if terr := (*resource.TimeoutError)(nil); errors.As(err, &terr) {
+			diags = diags.Append(tfd.Sourceless(tfd.Error,
+				fmt.Sprintf("%s (%s) timed out", rh.ResourceLogicalID, rh.ResourceType),
+				fmt.Sprintf("%s; raise timeouts.%s in the manifest if it needs longer", terr, terr.Operation)))
+		} else if err != nil {
+			diags = diags.Append(tfd.Sourceless(tfd.Error,
+				fmt.Sprintf("%s (%s) failed", rh.ResourceLogicalID, rh.ResourceType), err.Error()))
 		}
 
-		return nil
+		return diags
 	}
 }
@@ -0,0 +1,137 @@
+package manifest
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+
+	"github.com/h0tbird/terramorph/pkg/dag"
+	"github.com/h0tbird/terramorph/pkg/resource"
+	"github.com/h0tbird/terramorph/pkg/state"
+	"github.com/h0tbird/terramorph/pkg/tfd"
+)
+
+func TestGraph(t *testing.T) {
+
+	h := New()
+	h.Resources["role"] = &resource.Handler{ResourceLogicalID: "Role", ResourceType: "test_role", ResourceConfig: map[string]interface{}{
+		"name":   "role",
+		"policy": "policy.ResourceState.ID",
+		"path":   "nope.ResourceConfig.path",
+	}}
+	h.Resources["policy"] = &resource.Handler{ResourceLogicalID: "Policy", ResourceType: "test_policy", ResourceConfig: map[string]interface{}{
+		"role": "role.ResourceConfig.name",
+	}}
+	h.Resources["profile"] = &resource.Handler{ResourceLogicalID: "Profile", ResourceType: "test_profile", ResourceConfig: map[string]interface{}{
+		"name": "profile.ResourceConfig.path",
+	}}
+
+	_, diags := h.Graph()
+
+	expected := []struct{ summary, detail string }{
+		{"Self reference", `Profile: argument "name" references the resource itself`},
+		{"Reference to undeclared resource", `Role: argument "path" references "nope" which is not declared`},
+		{"Dependency cycle", "Policy, Role depend on each other: Policy.role references Role; Role.policy references Policy"},
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("bad: %v", diags.Err())
+	}
+	for i, d := range diags {
+		desc := d.Description()
+		if desc.Summary != expected[i].summary || !strings.Contains(desc.Detail, expected[i].detail) {
+			t.Fatalf("%d: bad: %s: %s", i, desc.Summary, desc.Detail)
+		}
+	}
+}
+
+func TestGraphReduction(t *testing.T) {
+
+	build := func() *Handler {
+		h := New()
+		h.Resources["role"] = &resource.Handler{ResourceLogicalID: "Role", ResourceType: "test_role", ResourceConfig: map[string]interface{}{
+			"name": "role",
+		}}
+		h.Resources["policy"] = &resource.Handler{ResourceLogicalID: "Policy", ResourceType: "test_policy", ResourceConfig: map[string]interface{}{
+			"role": "role.ResourceConfig.name",
+		}}
+		h.Resources["attachment"] = &resource.Handler{ResourceLogicalID: "Attachment", ResourceType: "test_attachment", ResourceConfig: map[string]interface{}{
+			"role":       "role.ResourceConfig.name",
+			"policy_arn": "policy.ResourceState.ID",
+		}}
+		return h
+	}
+
+	h := build()
+	d, diags := h.Graph()
+	if diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+
+	root, err := d.Root()
+	if err != nil || root != (rootVertex{}) {
+		t.Fatalf("bad: %v: %v", root, err)
+	}
+
+	// Attachment depends on Role through Policy
+	if d.HasEdge(dag.BasicEdge(h.Resources["role"], h.Resources["attachment"])) {
+		t.Fatalf("bad: %s", d.String())
+	}
+	if len(d.Edges()) != 3 {
+		t.Fatalf("bad: %s", d.String())
+	}
+
+	// Same manifest, same output
+	a, err := h.GraphJSON()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	b, err := build().GraphJSON()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(a) != string(b) {
+		t.Fatalf("bad:\n%s\n%s", a, b)
+	}
+}
+
+func TestGraphBeforeWalk(t *testing.T) {
+
+	ctx := context.Background()
+	h := New()
+	h.Resources["role"] = &resource.Handler{ResourceLogicalID: "Role", ResourceType: "test_role", ResourceConfig: map[string]interface{}{
+		"name": "policy.ResourceState.ID",
+	}}
+	h.Resources["policy"] = &resource.Handler{ResourceLogicalID: "Policy", ResourceType: "test_role", ResourceConfig: map[string]interface{}{
+		"name": "role.ResourceState.ID",
+	}}
+	if diags := h.ConfigureProviders(ctx, validateRegistry()); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+
+	s := state.NewMemory()
+	if err := s.Write("Role", &terraform.InstanceState{ID: "nodes"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The cycle is reported instead of deadlocking the walk
+	_, planDiags := h.Plan(ctx, s)
+	for _, diags := range []tfd.Diagnostics{h.Apply(ctx, s), planDiags, h.Destroy(ctx, s)} {
+		if len(diags) != 1 || diags[0].Description().Summary != "Dependency cycle" {
+			t.Fatalf("bad: %v", diags)
+		}
+	}
+	if _, err := h.PlanDestroy(s); err == nil || !strings.Contains(err.Error(), "Dependency cycle") {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// and nothing is touched
+	is := &terraform.InstanceState{}
+	if err := s.Read("Role", is); err != nil || is.ID != "nodes" {
+		t.Fatalf("bad: %#v %v", is, err)
+	}
+	if s := h.Summary(); len(s.Succeeded)+len(s.Failed)+len(s.Skipped) != 0 {
+		t.Fatalf("bad: %#v", s)
+	}
+}
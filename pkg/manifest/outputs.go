@@ -0,0 +1,97 @@
+package manifest
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"fmt"
+	"sort"
+
+	// terraform
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+
+	// terramorph
+	"github.com/h0tbird/terramorph/pkg/resource"
+	"github.com/h0tbird/terramorph/pkg/tfd"
+)
+
+//-----------------------------------------------------------------------------
+// Methods
+//-----------------------------------------------------------------------------
+
+// OutputValues resolves the outputs from the stored state. An output is a
+// reference like nodesRole.ResourceState.arn; ID is the resource ID and any
+// other field is a state attribute. Outputs of resources that do not exist
+// yet are left out.
+func (h *Handler) OutputValues(s resource.State) (map[string]string, error) {
+
+	values := map[string]string{}
+
+	for _, name := range h.outputNames() {
+
+		submatch := resource.Reg.FindStringSubmatch(h.Outputs[name])
+		if submatch == nil {
+			return nil, fmt.Errorf("output %q: invalid reference %q", name, h.Outputs[name])
+		}
+		r, ok := h.Resources[submatch[1]]
+		if !ok {
+			return nil, fmt.Errorf("output %q: %q is not declared", name, submatch[1])
+		}
+
+		if submatch[2] == "ResourceConfig" {
+			if v, ok := r.ResourceConfig[submatch[3]]; ok {
+				values[name] = fmt.Sprint(v)
+			}
+			continue
+		}
+
+		is := &terraform.InstanceState{}
+		if err := s.Read(r.ResourceLogicalID, is); err != nil {
+			return nil, err
+		}
+		if is.ID == "" {
+			continue
+		}
+		if submatch[3] == "ID" || submatch[3] == "id" {
+			values[name] = is.ID
+		} else if v, ok := is.Attributes[submatch[3]]; ok {
+			values[name] = v
+		}
+	}
+
+	return values, nil
+}
+
+// validateOutputs checks that every output references a declared resource
+func (h *Handler) validateOutputs() tfd.Diagnostics {
+
+	var diags tfd.Diagnostics
+
+	for _, name := range h.outputNames() {
+		submatch := resource.Reg.FindStringSubmatch(h.Outputs[name])
+		if submatch == nil {
+			diags = diags.Append(tfd.Sourceless(tfd.Error, "Invalid output",
+				fmt.Sprintf("output %q: %q is not a reference like nodesRole.ResourceState.arn", name, h.Outputs[name])))
+			continue
+		}
+		if _, ok := h.Resources[submatch[1]]; !ok {
+			diags = diags.Append(tfd.Sourceless(tfd.Error, "Reference to undeclared resource",
+				fmt.Sprintf("output %q references %q which is not declared", name, submatch[1])))
+		}
+	}
+
+	return diags
+}
+
+// outputNames returns the names of the outputs, sorted
+func (h *Handler) outputNames() []string {
+	names := []string{}
+	for name := range h.Outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,146 @@
+package manifest
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+
+	"github.com/h0tbird/terramorph/pkg/provider"
+	"github.com/h0tbird/terramorph/pkg/resource"
+	"github.com/h0tbird/terramorph/pkg/state"
+)
+
+// buildTestProvider compiles testdata/terraform-provider-test
+func buildTestProvider(t *testing.T) string {
+	if testing.Short() {
+		t.Skip("skipping plugin build in short mode")
+	}
+
+	bin := filepath.Join(t.TempDir(), "terraform-provider-test")
+	out, err := exec.Command("go", "build", "-o", bin, "./testdata/terraform-provider-test").CombinedOutput()
+	if err != nil {
+		t.Fatalf("err: %s\n%s", err, out)
+	}
+
+	return bin
+}
+
+func TestPluginApply(t *testing.T) {
+	bin := buildTestProvider(t)
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	h := New()
+	h.Variables["dir"] = dir
+	h.Providers["test"] = &Provider{Path: bin, Config: map[string]interface{}{"dir": "var.dir"}}
+	h.Resources["file"] = &resource.Handler{
+		ResourceLogicalID: "File",
+		ResourceType:      "test_file",
+		ResourceConfig:    map[string]interface{}{"name": "a", "content": "hello"},
+	}
+
+	if diags := h.ConfigureProviders(ctx, provider.NewRegistry()); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	defer h.Close()
+
+	s := state.NewMemory()
+	check := func(name, content string) {
+		t.Helper()
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(data) != content {
+			t.Fatalf("bad: %q %v", data, err)
+		}
+		is := &terraform.InstanceState{}
+		s.Read("File", is)
+		if is.ID != name || is.Attributes["path"] != filepath.Join(dir, name) {
+			t.Fatalf("bad: %#v", is)
+		}
+	}
+
+	// Create
+	if diags := h.Apply(ctx, s); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	check("a", "hello")
+
+	// No changes
+	before := &terraform.InstanceState{}
+	s.Read("File", before)
+	if diags := h.Apply(ctx, s); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	after := &terraform.InstanceState{}
+	s.Read("File", after)
+	if !reflect.DeepEqual(after, before) {
+		t.Fatalf("bad: %#v", after)
+	}
+
+	// Update in place
+	h.Resources["file"].ResourceConfig["content"] = "bye"
+	if diags := h.Apply(ctx, s); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	check("a", "bye")
+
+	// Replace
+	h.Resources["file"].ResourceConfig["name"] = "b"
+	if diags := h.Apply(ctx, s); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	check("b", "bye")
+	if _, err := os.Stat(filepath.Join(dir, "a")); !os.IsNotExist(err) {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// A failed update without a new state keeps the prior one
+	h.Resources["file"].ResourceConfig["content"] = "crash"
+	if diags := h.Apply(ctx, s); !diags.HasErrors() {
+		t.Fatal("expected error")
+	}
+	check("b", "bye")
+}
+
+func TestPluginInvalidConfig(t *testing.T) {
+	bin := buildTestProvider(t)
+
+	h := New()
+	h.Providers["test"] = &Provider{Path: bin, Config: map[string]interface{}{"dri": "/tmp"}}
+
+	if diags := h.ConfigureProviders(context.Background(), provider.NewRegistry()); !diags.HasErrors() {
+		t.Fatal("expected error")
+	}
+	if h.Providers["test"].Plugin() != nil {
+		t.Fatal("plugin was configured")
+	}
+}
+
+func TestPluginResourceSchema(t *testing.T) {
+	bin := buildTestProvider(t)
+	ctx := context.Background()
+	reg := provider.NewRegistry()
+
+	// The schema comes without configuring the plugin
+	h := New()
+	h.Providers["test"] = &Provider{Path: bin}
+	name, rp, rs, err := h.ResourceSchema(ctx, reg, &resource.Handler{ResourceLogicalID: "File", ResourceType: "test_file"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if name != "test" || rp != nil || rs == nil || !rs.Block.ImpliedType().HasAttribute("content") {
+		t.Fatalf("bad: %s %v %#v", name, rp, rs)
+	}
+	if h.Providers["test"].Plugin() != nil {
+		t.Fatal("plugin was configured")
+	}
+
+	if _, _, _, err := h.ResourceSchema(ctx, reg, &resource.Handler{ResourceLogicalID: "Dir", ResourceType: "test_dir"}); err == nil {
+		t.Fatal("expected error")
+	}
+}
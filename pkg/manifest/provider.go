@@ -0,0 +1,375 @@
+package manifest
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	// terraform
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+
+	// terramorph
+	"github.com/h0tbird/terramorph/pkg/logger"
+	"github.com/h0tbird/terramorph/pkg/provider"
+	"github.com/h0tbird/terramorph/pkg/resource"
+	"github.com/h0tbird/terramorph/pkg/tfd"
+)
+
+//-----------------------------------------------------------------------------
+// Types
+//-----------------------------------------------------------------------------
+
+// Provider is a provider block of the manifest. Name selects the provider
+// in the registry and defaults to the alias the block is declared under.
+// When Path is set the provider binary at Path is launched instead and
+// driven over the plugin protocol.
+type Provider struct {
+	Name     string
+	Path     string
+	Config   map[string]interface{}
+	instance *schema.Provider
+	plugin   *provider.Plugin
+	schemas  *provider.ProviderSchema
+}
+
+// Instance returns the configured in-process provider or nil
+func (p *Provider) Instance() *schema.Provider {
+	return p.instance
+}
+
+// Plugin returns the configured out-of-process provider or nil
+func (p *Provider) Plugin() *provider.Plugin {
+	return p.plugin
+}
+
+// configured ...
+func (p *Provider) configured() bool {
+	return p.instance != nil || p.plugin != nil
+}
+
+// hasResource ...
+func (p *Provider) hasResource(resourceType string) bool {
+	if p.plugin != nil {
+		_, ok := p.plugin.ResourceSchema(resourceType)
+		return ok
+	}
+	_, ok := p.instance.ResourcesMap[resourceType]
+	return ok
+}
+
+//-----------------------------------------------------------------------------
+// Methods
+//-----------------------------------------------------------------------------
+
+// ConfigureProviders creates one provider instance per provider block from
+// the registry, resolves the variables it references, validates it against
+// the provider schema and configures it. Resources without an explicit
+// provider get an empty provider block for the provider their type is
+// routed to, the way Terraform implies providers.
+func (h *Handler) ConfigureProviders(ctx context.Context, reg *provider.Registry) tfd.Diagnostics {
+
+	var diags tfd.Diagnostics
+	h.registry = reg
+
+	// Implied provider blocks
+	for _, r := range h.Resources {
+		if alias := h.providerAlias(r); r.Provider == "" && h.Providers[alias] == nil {
+			if _, ok := reg.Factory(alias); ok {
+				h.Providers[alias] = &Provider{}
+			}
+		}
+	}
+
+	for _, alias := range h.providerAliases() {
+
+		p := h.Providers[alias]
+		if p.Name == "" {
+			p.Name = alias
+		}
+
+		// Out-of-process provider
+		if p.Path != "" {
+			diags = diags.Append(p.configurePlugin(ctx, h.log().With(logger.F("alias", alias)), alias, h.Variables))
+			continue
+		}
+
+		factory, ok := reg.Factory(p.Name)
+		if !ok {
+			diags = diags.Append(tfd.Sourceless(tfd.Error, "Unknown provider",
+				fmt.Sprintf("provider %q: %q is not registered, known providers are %s",
+					alias, p.Name, strings.Join(reg.Names(), ", "))))
+			continue
+		}
+
+		diags = diags.Append(p.configure(ctx, h.log().With(logger.F("alias", alias)), alias, factory(), h.Variables))
+	}
+
+	return diags
+}
+
+// Provider returns the configured provider block of a resource
+func (h *Handler) Provider(r *resource.Handler) (*Provider, error) {
+	alias := h.providerAlias(r)
+	p, ok := h.Providers[alias]
+	if !ok {
+		return nil, fmt.Errorf("%s: undeclared provider %q", r.ResourceLogicalID, alias)
+	}
+	if !p.configured() {
+		return nil, fmt.Errorf("%s: provider %q is not configured", r.ResourceLogicalID, alias)
+	}
+	if !p.hasResource(r.ResourceType) {
+		return nil, fmt.Errorf("%s: provider %q (%s) has no resource type %s", r.ResourceLogicalID, alias, p.Name, r.ResourceType)
+	}
+	return p, nil
+}
+
+// Close stops the out-of-process providers
+func (h *Handler) Close() error {
+	var err error
+	for _, p := range h.Providers {
+		if p.plugin != nil {
+			if cerr := p.plugin.Close(); cerr != nil {
+				err = cerr
+			}
+			p.plugin = nil
+		}
+	}
+	return err
+}
+
+// ResourceSchema returns the name of the provider a resource is routed to
+// and the schema of its type, without configuring the provider. In-process
+// providers answer with their SDK resource, out-of-process ones with the
+// schema they report once launched.
+func (h *Handler) ResourceSchema(ctx context.Context, reg *provider.Registry, r *resource.Handler) (string, *schema.Resource, *provider.Schema, error) {
+
+	h.registry = reg
+	alias := h.providerAlias(r)
+	p, ok := h.Providers[alias]
+	if !ok {
+		if r.Provider != "" {
+			return "", nil, nil, fmt.Errorf("undeclared provider %q", alias)
+		}
+		p = &Provider{}
+	}
+
+	name := p.Name
+	if name == "" {
+		name = alias
+	}
+
+	// In-process provider
+	if p.Path == "" {
+		instance, ok := reg.Schema(name)
+		if !ok {
+			return "", nil, nil, fmt.Errorf("unknown provider %q", name)
+		}
+		rp, ok := instance.ResourcesMap[r.ResourceType]
+		if !ok {
+			return "", nil, nil, fmt.Errorf("provider %q (%s) has no resource type %s", alias, name, r.ResourceType)
+		}
+		return name, rp, nil, nil
+	}
+
+	// Out-of-process provider
+	if p.schemas == nil {
+		schemas, err := pluginSchemas(ctx, p.Path)
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("provider %q: %s", alias, err)
+		}
+		p.schemas = schemas
+	}
+	rs, ok := p.schemas.ResourceTypes[r.ResourceType]
+	if !ok {
+		return "", nil, nil, fmt.Errorf("provider %q (%s) has no resource type %s", alias, name, r.ResourceType)
+	}
+	return name, nil, rs, nil
+}
+
+// providerAlias returns the explicit provider of a resource or the one its
+// type is routed to by the registry.
+func (h *Handler) providerAlias(r *resource.Handler) string {
+	if r.Provider == "" && h.registry != nil {
+		if name, ok := h.registry.Route(r.ResourceType); ok {
+			return name
+		}
+	}
+	return r.ProviderAlias()
+}
+
+func (p *Provider) configure(ctx context.Context, log logger.Logger, alias string, instance *schema.Provider, vars map[string]interface{}) tfd.Diagnostics {
+
+	var diags tfd.Diagnostics
+
+	if p.Config == nil {
+		p.Config = map[string]interface{}{}
+	}
+
+	// Resolve the variables
+	config, err := interpolate(p.Config, vars)
+	if err != nil {
+		return diags.Append(tfd.Sourceless(tfd.Error, "Invalid provider configuration",
+			fmt.Sprintf("provider %q: %s", alias, err)))
+	}
+
+	rc := terraform.NewResourceConfigRaw(config.(map[string]interface{}))
+
+	// Validate against the schema
+	diags = diags.Append(fromSDK(instance.Validate(rc)))
+	if diags.HasErrors() {
+		return diags
+	}
+
+	// Configure
+	log.Info("Configuring the provider", logger.F("name", p.Name))
+	diags = diags.Append(fromSDK(instance.Configure(ctx, rc)))
+	if !diags.HasErrors() {
+		p.instance = instance
+	}
+
+	return diags
+}
+
+func (p *Provider) configurePlugin(ctx context.Context, log logger.Logger, alias string, vars map[string]interface{}) tfd.Diagnostics {
+
+	var diags tfd.Diagnostics
+
+	if p.Config == nil {
+		p.Config = map[string]interface{}{}
+	}
+
+	// Resolve the variables
+	config, err := interpolate(p.Config, vars)
+	if err != nil {
+		return diags.Append(tfd.Sourceless(tfd.Error, "Invalid provider configuration",
+			fmt.Sprintf("provider %q: %s", alias, err)))
+	}
+
+	// Launch the plugin
+	log.Info("Launching the provider", logger.F("path", p.Path))
+	plugin, err := provider.Launch(p.Path)
+	if err != nil {
+		return diags.Append(err)
+	}
+
+	schemas, schemaDiags := plugin.GetSchema(ctx)
+	diags = diags.Append(schemaDiags)
+	if diags.HasErrors() {
+		plugin.Close()
+		return diags
+	}
+
+	// Validate against the schema
+	val, err := schemas.Provider.Block.CoerceValue(config.(map[string]interface{}))
+	if err != nil {
+		plugin.Close()
+		if perr, ok := err.(cty.PathError); ok && len(perr.Path) > 0 {
+			err = fmt.Errorf("%s: %s", pathString(perr.Path), perr.Error())
+		}
+		return diags.Append(tfd.Sourceless(tfd.Error, "Invalid provider configuration",
+			fmt.Sprintf("provider %q: %s", alias, err)))
+	}
+
+	prepared, prepareDiags := plugin.PrepareProviderConfig(ctx, val)
+	diags = diags.Append(prepareDiags)
+	if diags.HasErrors() {
+		plugin.Close()
+		return diags
+	}
+
+	// Configure
+	log.Info("Configuring the provider", logger.F("name", p.Name))
+	diags = diags.Append(plugin.Configure(ctx, prepared))
+	if diags.HasErrors() {
+		plugin.Close()
+		return diags
+	}
+
+	p.plugin = plugin
+	return diags
+}
+
+func (h *Handler) providerAliases() []string {
+	aliases := []string{}
+	for alias := range h.Providers {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+//-----------------------------------------------------------------------------
+// Helpers
+//-----------------------------------------------------------------------------
+
+// pluginSchemas launches the plugin at path for its schemas only
+func pluginSchemas(ctx context.Context, path string) (*provider.ProviderSchema, error) {
+
+	plugin, err := provider.Launch(path)
+	if err != nil {
+		return nil, err
+	}
+	defer plugin.Close()
+
+	schemas, diags := plugin.GetSchema(ctx)
+	if diags.HasErrors() {
+		return nil, diags.Err()
+	}
+
+	return schemas, nil
+}
+
+// fromSDK converts plugin SDK diagnostics into tfd.Diagnostics
+func fromSDK(in diag.Diagnostics) tfd.Diagnostics {
+
+	var diags tfd.Diagnostics
+
+	for _, d := range in {
+
+		severity := tfd.Warning
+		if d.Severity == diag.Error {
+			severity = tfd.Error
+		}
+
+		detail := d.Detail
+		if len(d.AttributePath) > 0 {
+			detail = fmt.Sprintf("%s (attribute %s)", detail, pathString(d.AttributePath))
+		}
+
+		diags = diags.Append(tfd.Sourceless(severity, d.Summary, detail))
+	}
+
+	return diags
+}
+
+// pathString renders an attribute path as assume_role.0.role_arn
+func pathString(path cty.Path) string {
+	s := ""
+	for _, step := range path {
+		switch step := step.(type) {
+		case cty.GetAttrStep:
+			if s != "" {
+				s += "."
+			}
+			s += step.Name
+		case cty.IndexStep:
+			switch step.Key.Type() {
+			case cty.Number:
+				s += "." + step.Key.AsBigFloat().String()
+			case cty.String:
+				s += "." + step.Key.AsString()
+			}
+		}
+	}
+	return s
+}
@@ -0,0 +1,196 @@
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+
+	"github.com/h0tbird/terramorph/pkg/logger"
+	"github.com/h0tbird/terramorph/pkg/provider"
+	"github.com/h0tbird/terramorph/pkg/resource"
+)
+
+func testProvider(configured map[string]interface{}) *schema.Provider {
+	return &schema.Provider{
+		Schema: map[string]*schema.Schema{
+			"region":      {Type: schema.TypeString, Required: true},
+			"max_retries": {Type: schema.TypeInt, Optional: true},
+			"assume_role": {
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"role_arn": {Type: schema.TypeString, Optional: true},
+					},
+				},
+			},
+		},
+		ResourcesMap: map[string]*schema.Resource{
+			"test_role":   {Schema: map[string]*schema.Schema{}},
+			"test_bucket": {Schema: map[string]*schema.Schema{}},
+		},
+		ConfigureContextFunc: func(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
+			configured["region"] = d.Get("region")
+			configured["role_arn"] = d.Get("assume_role.0.role_arn")
+			return nil, nil
+		},
+	}
+}
+
+func testRegistry(configured map[string]interface{}) *provider.Registry {
+	reg := provider.NewRegistry()
+	reg.Register("test", func() *schema.Provider {
+		return testProvider(configured)
+	})
+	return reg
+}
+
+func TestConfigureProvider(t *testing.T) {
+	configured := map[string]interface{}{}
+
+	h := New()
+	h.Variables["region"] = "eu-west-1"
+	h.Variables["role"] = "arn:aws:iam::0:role/admin"
+	h.Providers["test"] = &Provider{
+		Config: map[string]interface{}{
+			"region":      "var.region",
+			"max_retries": 5,
+			"assume_role": []interface{}{
+				map[string]interface{}{"role_arn": "var.role"},
+			},
+		},
+	}
+
+	if diags := h.ConfigureProviders(context.Background(), testRegistry(configured)); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+
+	if configured["region"] != "eu-west-1" || configured["role_arn"] != "arn:aws:iam::0:role/admin" {
+		t.Fatalf("bad: %#v", configured)
+	}
+}
+
+func TestConfigureProviderLog(t *testing.T) {
+
+	b := &bytes.Buffer{}
+	h := New()
+	h.Log, _ = logger.New(logger.Config{Level: logger.Info, Output: b})
+	h.Providers["test"] = &Provider{Config: map[string]interface{}{"region": "eu-west-1"}}
+
+	// Providers log through the logger of the manifest, by alias
+	if diags := h.ConfigureProviders(context.Background(), testRegistry(map[string]interface{}{})); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	if out := b.String(); !strings.Contains(out, `msg="Configuring the provider"`) || !strings.Contains(out, "alias=test") {
+		t.Fatalf("bad: %s", out)
+	}
+}
+
+func TestConfigureProviderInvalid(t *testing.T) {
+	cases := map[string]map[string]interface{}{
+		"unknown key":        {"region": "eu-west-1", "regoin": "eu-west-1"},
+		"missing required":   {"max_retries": 5},
+		"type mismatch":      {"region": "eu-west-1", "max_retries": "many"},
+		"undefined variable": {"region": "var.nope"},
+	}
+
+	for name, config := range cases {
+		t.Run(name, func(t *testing.T) {
+			configured := map[string]interface{}{}
+
+			h := New()
+			h.Providers["test"] = &Provider{Config: config}
+
+			if diags := h.ConfigureProviders(context.Background(), testRegistry(configured)); !diags.HasErrors() {
+				t.Fatal("expected error")
+			}
+			if len(configured) != 0 {
+				t.Fatalf("provider was configured: %#v", configured)
+			}
+		})
+	}
+}
+
+func TestProviderAliases(t *testing.T) {
+	regions := map[*schema.Provider]string{}
+
+	h := New()
+	h.Providers["test"] = &Provider{Config: map[string]interface{}{"region": "us-east-2"}}
+	h.Providers["west"] = &Provider{Name: "test", Config: map[string]interface{}{"region": "eu-west-1"}}
+
+	reg := provider.NewRegistry()
+	reg.Register("test", func() *schema.Provider {
+		p := testProvider(map[string]interface{}{})
+		configure := p.ConfigureContextFunc
+		p.ConfigureContextFunc = func(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
+			regions[p] = d.Get("region").(string)
+			return configure(ctx, d)
+		}
+		return p
+	})
+
+	diags := h.ConfigureProviders(context.Background(), reg)
+	if diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+
+	cases := map[*resource.Handler]string{
+		{ResourceLogicalID: "Default", ResourceType: "test_role"}:                  "us-east-2",
+		{ResourceLogicalID: "West", ResourceType: "test_bucket", Provider: "west"}: "eu-west-1",
+	}
+
+	for r, expected := range cases {
+		p, err := h.Provider(r)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if regions[p.Instance()] != expected {
+			t.Fatalf("%s: bad: %s", r.ResourceLogicalID, regions[p.Instance()])
+		}
+	}
+
+	if _, err := h.Provider(&resource.Handler{ResourceLogicalID: "Bad", ResourceType: "test_role", Provider: "east"}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestImpliedProviders(t *testing.T) {
+	reg := testRegistry(map[string]interface{}{})
+	reg.Register("test_random", func() *schema.Provider {
+		return &schema.Provider{
+			ResourcesMap: map[string]*schema.Resource{
+				"test_random_id": {Schema: map[string]*schema.Schema{}},
+			},
+		}
+	})
+
+	h := New()
+	h.Providers["test"] = &Provider{Config: map[string]interface{}{"region": "us-east-2"}}
+	h.Resources["role"] = &resource.Handler{ResourceLogicalID: "Role", ResourceType: "test_role"}
+	h.Resources["suffix"] = &resource.Handler{ResourceLogicalID: "Suffix", ResourceType: "test_random_id"}
+
+	if diags := h.ConfigureProviders(context.Background(), reg); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+
+	if h.Providers["test_random"] == nil {
+		t.Fatalf("bad: %#v", h.Providers)
+	}
+
+	for _, r := range h.Resources {
+		if _, err := h.Provider(r); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	// Unknown provider names are reported
+	h.Providers["bad"] = &Provider{Name: "nope"}
+	if diags := h.ConfigureProviders(context.Background(), reg); !diags.HasErrors() {
+		t.Fatal("expected error")
+	}
+}
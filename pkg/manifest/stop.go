@@ -0,0 +1,278 @@
+package manifest
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	// terramorph
+	"github.com/h0tbird/terramorph/pkg/dag"
+	"github.com/h0tbird/terramorph/pkg/event"
+	"github.com/h0tbird/terramorph/pkg/resource"
+	"github.com/h0tbird/terramorph/pkg/tfd"
+)
+
+//-----------------------------------------------------------------------------
+// Methods
+//-----------------------------------------------------------------------------
+
+// InFlight returns the logical IDs of the resources being worked on, sorted
+func (h *Handler) InFlight() []string {
+
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	ids := []string{}
+	for r := range h.inFlight {
+		ids = append(ids, r.ResourceLogicalID)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// run walks g running op on the resources, then records the Summary. The
+// walk and the outcome of each resource are sent to Events as operation. A
+// failure is handled by OnFailure, fail-fast by default. Once
+// Stop is closed, or after a failure with fail-fast, no more resources are
+// started: the vertices still waiting are cancelled and the ones in flight
+// finish. The resources an interrupt left behind are reported.
+func (h *Handler) run(operation string, g *dag.AcyclicGraph, only map[*resource.Handler]bool, op func(*resource.Handler, *Provider) error) tfd.Diagnostics {
+
+	var diags tfd.Diagnostics
+	var l sync.Mutex
+	var once sync.Once
+
+	policy := h.OnFailure
+	if policy == "" {
+		policy = FailFast
+	}
+	if policy != FailFast && policy != Continue && policy != ContinueIndependent {
+		return diags.Append(tfd.Sourceless(tfd.Error, "Invalid failure policy",
+			fmt.Sprintf("%q is not one of %s, %s or %s", policy, FailFast, Continue, ContinueIndependent)))
+	}
+
+	considered := []*resource.Handler{}
+	for _, v := range g.Vertices() {
+		if rh, ok := v.(*resource.Handler); ok && (only == nil || only[rh]) {
+			considered = append(considered, rh)
+		}
+	}
+	sort.Slice(considered, func(i, j int) bool { return considered[i].ResourceLogicalID < considered[j].ResourceLogicalID })
+
+	start := time.Now()
+	event.Emit(h.Events, event.Event{Kind: event.WalkStarted, Operation: operation, Resources: len(considered)})
+
+	halt := make(chan struct{})
+	stop := func() { once.Do(func() { close(halt) }) }
+	started := map[*resource.Handler]bool{}
+	failed := map[*resource.Handler]bool{}
+	skipped := map[*resource.Handler]bool{}
+	hidden := map[string]tfd.Diagnostics{}
+
+	cb := walk(h, only, halt, func(rh *resource.Handler, p *Provider) error {
+		l.Lock()
+		started[rh] = true
+		l.Unlock()
+
+		rh.Events, rh.Log = h.Events, h.Log
+		t := time.Now()
+		err := op(rh, p)
+
+		e := event.Event{Kind: event.Done, LogicalID: rh.ResourceLogicalID, Type: rh.ResourceType, Duration: time.Since(t)}
+		if err != nil {
+			// Nothing starts once the failure is heard of
+			if policy == FailFast {
+				stop()
+			}
+			e.Kind, e.Error = event.Failed, err.Error()
+		}
+		event.Emit(h.Events, e)
+
+		return err
+	})
+
+	w := &dag.Walker{Callback: func(v dag.Vertex) tfd.Diagnostics {
+
+		// Continue does not run what needs a failed resource
+		if rh, ok := v.(*resource.Handler); ok && policy == Continue {
+			l.Lock()
+			skip := h.needsFailed(g, rh, failed, skipped)
+			if skip {
+				skipped[rh] = true
+			}
+			l.Unlock()
+			if skip {
+				return nil
+			}
+		}
+
+		diags := cb(v)
+		if !diags.HasErrors() || policy != Continue {
+			return diags
+		}
+
+		// The walker would skip the dependents of an error
+		rh := v.(*resource.Handler)
+		l.Lock()
+		failed[rh] = true
+		hidden[rh.ResourceLogicalID] = diags
+		l.Unlock()
+		return nil
+	}}
+	w.Update(g)
+
+	// Removing every vertex closes their CancelCh
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-h.Stop:
+		case <-halt:
+		case <-done:
+			return
+		}
+		stop()
+		w.Update(nil)
+	}()
+
+	diags = diags.Append(w.Wait())
+	close(done)
+
+	ids := []string{}
+	for id := range hidden {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		diags = diags.Append(hidden[id])
+	}
+
+	summary := summarize(w, considered, started, failed)
+	h.setSummary(summary)
+
+	// Skipped resources, after a failure or before a stop
+	_, _, upstream := w.Results()
+	reasons := map[string]string{}
+	for _, v := range upstream {
+		if rh, ok := v.(*resource.Handler); ok {
+			reasons[rh.ResourceLogicalID] = "a dependency failed"
+		}
+	}
+	for rh := range skipped {
+		reasons[rh.ResourceLogicalID] = "a dependency failed"
+	}
+
+	// Also when the stop came before the walker got to them
+	down := map[string]bool{}
+	for _, id := range summary.Failed {
+		down[id] = true
+	}
+	for id := range reasons {
+		down[id] = true
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, rh := range considered {
+			if started[rh] || down[rh.ResourceLogicalID] {
+				continue
+			}
+			for _, v := range g.UpEdges(rh) {
+				if dep, ok := v.(*resource.Handler); ok && down[dep.ResourceLogicalID] {
+					reasons[rh.ResourceLogicalID] = "a dependency failed"
+					down[rh.ResourceLogicalID], changed = true, true
+					break
+				}
+			}
+		}
+	}
+	for _, rh := range considered {
+		if !started[rh] || reasons[rh.ResourceLogicalID] != "" {
+			reason := reasons[rh.ResourceLogicalID]
+			if reason == "" {
+				reason = "not started"
+			}
+			event.Emit(h.Events, event.Event{Kind: event.Skipped, LogicalID: rh.ResourceLogicalID, Type: rh.ResourceType, Reason: reason})
+		}
+	}
+	event.Emit(h.Events, event.Event{Kind: event.WalkDone, Operation: operation, Duration: time.Since(start),
+		Succeeded: len(summary.Succeeded), Failed: len(summary.Failed), Skipped: len(summary.Skipped)})
+
+	if !closed(h.Stop) {
+		return diags
+	}
+
+	ids = []string{}
+	for _, rh := range considered {
+		if !started[rh] {
+			ids = append(ids, rh.ResourceLogicalID)
+		}
+	}
+	if len(ids) == 0 {
+		return diags
+	}
+	sort.Strings(ids)
+
+	return diags.Append(tfd.Sourceless(tfd.Error, "Interrupted",
+		fmt.Sprintf("Stopped before starting %s. The resources in flight were left to finish and their state is saved, run the command again to carry on.",
+			strings.Join(ids, ", "))))
+}
+
+// closed tells whether ch is closed
+func closed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// needsFailed tells whether r needs a failed or skipped resource. Walking
+// the DAG, r needs the resources whose state it references. Walking it
+// reversed, to destroy, r is still needed by the resources referring to it,
+// the ones it waits on in g.
+func (h *Handler) needsFailed(g *dag.AcyclicGraph, r *resource.Handler, failed, skipped map[*resource.Handler]bool) bool {
+	if g != &h.Dag {
+		for _, v := range g.UpEdges(r) {
+			if dependent, ok := v.(*resource.Handler); ok && (failed[dependent] || skipped[dependent]) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, v := range r.ResourceConfig {
+		str, _ := v.(string)
+		submatch := resource.Reg.FindStringSubmatch(str)
+		if submatch == nil || submatch[2] != "ResourceState" {
+			continue
+		}
+		if target := h.Resources[submatch[1]]; failed[target] || skipped[target] {
+			return true
+		}
+	}
+	return false
+}
+
+// track records whether r is in flight
+func (h *Handler) track(r *resource.Handler, on bool) {
+
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	if h.inFlight == nil {
+		h.inFlight = map[*resource.Handler]bool{}
+	}
+	if on {
+		h.inFlight[r] = true
+	} else {
+		delete(h.inFlight, r)
+	}
+}
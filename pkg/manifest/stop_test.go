@@ -0,0 +1,121 @@
+package manifest
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/h0tbird/terramorph/pkg/event"
+	"github.com/h0tbird/terramorph/pkg/logger"
+	"github.com/h0tbird/terramorph/pkg/resource"
+	"github.com/h0tbird/terramorph/pkg/tfd"
+)
+
+func TestRunStop(t *testing.T) {
+
+	h := testWalk(t)
+	stop := make(chan struct{})
+	h.Stop = stop
+
+	// One resource at a time, each waiting to be released
+	started := make(chan string, 3)
+	release := make(chan struct{})
+	done := make(chan tfd.Diagnostics, 1)
+	go func() {
+		done <- h.run("apply", &h.Dag, nil, func(rh *resource.Handler, p *Provider) error {
+			started <- rh.ResourceLogicalID
+			<-release
+			return nil
+		})
+	}()
+
+	// Stop while the first one is in flight, it is released once stopped
+	first := <-started
+	if ids := h.InFlight(); !reflect.DeepEqual(ids, []string{first}) {
+		t.Fatalf("bad: %v", ids)
+	}
+	close(stop)
+	close(release)
+	diags := <-done
+
+	// It finishes and nothing else is started
+	if len(started) != 0 {
+		t.Fatalf("bad: %s started", <-started)
+	}
+	if ids := h.InFlight(); len(ids) != 0 {
+		t.Fatalf("bad: %v", ids)
+	}
+	if s := h.Summary(); !reflect.DeepEqual(s.Succeeded, []string{first}) || len(s.Skipped) != 2 {
+		t.Fatalf("bad: %#v", s)
+	}
+
+	// The resources left behind are reported
+	if len(diags) != 1 || diags[0].Description().Summary != "Interrupted" {
+		t.Fatalf("bad: %v", diags)
+	}
+	for _, id := range h.Summary().Skipped {
+		if !strings.Contains(diags[0].Description().Detail, id) {
+			t.Fatalf("bad: %s", diags[0].Description().Detail)
+		}
+	}
+}
+
+func TestRunEvents(t *testing.T) {
+
+	h := testWalk(t)
+	h.OnFailure = ContinueIndependent
+
+	var l sync.Mutex
+	events := map[string][]event.Event{}
+	h.Events = event.SinkFunc(func(e event.Event) {
+		l.Lock()
+		defer l.Unlock()
+		e.Time, e.Duration = time.Time{}, 0
+		events[e.LogicalID] = append(events[e.LogicalID], e)
+	})
+
+	// Resources emit through the sink of the walk
+	h.run("apply", &h.Dag, nil, func(rh *resource.Handler, p *Provider) error {
+		if rh.ResourceLogicalID == "Policy" {
+			return errors.New("AccessDenied")
+		}
+		event.Emit(rh.Events, event.Event{Kind: event.Applying, LogicalID: rh.ResourceLogicalID})
+		return nil
+	})
+
+	expected := map[string][]event.Event{
+		"": {
+			{Kind: event.WalkStarted, Operation: "apply", Resources: 3},
+			{Kind: event.WalkDone, Operation: "apply", Succeeded: 1, Failed: 1, Skipped: 1},
+		},
+		"Role": {
+			{Kind: event.Applying, LogicalID: "Role"},
+			{Kind: event.Done, LogicalID: "Role", Type: "test_role"},
+		},
+		"Policy":     {{Kind: event.Failed, LogicalID: "Policy", Type: "test_policy", Error: "AccessDenied"}},
+		"Attachment": {{Kind: event.Skipped, LogicalID: "Attachment", Type: "test_attachment", Reason: "a dependency failed"}},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("bad: %#v", events)
+	}
+}
+
+func TestRunLog(t *testing.T) {
+
+	b := &bytes.Buffer{}
+	h := testWalk(t)
+	h.Log, _ = logger.New(logger.Config{Level: logger.Info, Output: b})
+
+	// Resources log through the logger of the manifest
+	h.run("apply", &h.Dag, nil, func(rh *resource.Handler, p *Provider) error {
+		rh.Log.With(logger.F("id", rh.ResourceLogicalID)).Info("Walked")
+		return nil
+	})
+	if out := b.String(); strings.Count(out, "msg=Walked") != 3 || !strings.Contains(out, "id=Attachment") {
+		t.Fatalf("bad: %s", out)
+	}
+}
@@ -0,0 +1,92 @@
+package manifest
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"fmt"
+	"sort"
+	"strings"
+
+	// terramorph
+	"github.com/h0tbird/terramorph/pkg/resource"
+	"github.com/h0tbird/terramorph/pkg/tfd"
+)
+
+//-----------------------------------------------------------------------------
+// Methods
+//-----------------------------------------------------------------------------
+
+// selection returns the resources a walk is limited to by Targets, or nil
+// without targets. The edges of the DAG go from a dependency to its
+// dependents, so the dependencies of a target are its Descendents and its
+// dependents are its Ancestors. Apply and Plan take the targets with what
+// they depend on, Destroy the targets with what depends on them. The DAG
+// must be built.
+func (h *Handler) selection(dependents bool) (map[*resource.Handler]bool, tfd.Diagnostics) {
+
+	var diags tfd.Diagnostics
+
+	if len(h.Targets) == 0 {
+		return nil, diags
+	}
+
+	selected := map[*resource.Handler]bool{}
+	for _, name := range h.Targets {
+
+		r, ok := h.lookup(name)
+		if !ok {
+			diags = diags.Append(tfd.Sourceless(tfd.Error, "Invalid target",
+				fmt.Sprintf("%q is not declared in the manifest", name)))
+			continue
+		}
+		selected[r] = true
+
+		walk := h.Dag.Descendents
+		if dependents {
+			walk = h.Dag.Ancestors
+		}
+		set, err := walk(r)
+		if err != nil {
+			diags = diags.Append(err)
+			continue
+		}
+		for _, v := range set {
+			if rh, ok := v.(*resource.Handler); ok {
+				selected[rh] = true
+			}
+		}
+	}
+
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	ids := []string{}
+	for r := range selected {
+		ids = append(ids, r.ResourceLogicalID)
+	}
+	sort.Strings(ids)
+
+	diags = diags.Append(tfd.Sourceless(tfd.Warning, "Resource targeting is in effect",
+		fmt.Sprintf("Only %d of %d resources are considered: %s. The result is partial, the rest of the stack may not match the manifest.",
+			len(ids), len(h.Resources), strings.Join(ids, ", "))))
+
+	return selected, diags
+}
+
+// lookup finds a resource by its key or its logical ID
+func (h *Handler) lookup(name string) (*resource.Handler, bool) {
+	if r, ok := h.Resources[name]; ok {
+		return r, true
+	}
+	for _, r := range h.Resources {
+		if r.ResourceLogicalID == name {
+			return r, true
+		}
+	}
+	return nil, false
+}
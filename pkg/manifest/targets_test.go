@@ -0,0 +1,91 @@
+package manifest
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/h0tbird/terramorph/pkg/resource"
+)
+
+// testWalk returns a manifest to walk without providers: the attachment
+// uses the state of the policy and the config of the role
+func testWalk(t *testing.T) *Handler {
+	h := New()
+	h.Resources["role"] = &resource.Handler{ResourceLogicalID: "Role", ResourceType: "test_role", ResourceConfig: map[string]interface{}{
+		"name": "nodes",
+	}}
+	h.Resources["policy"] = &resource.Handler{ResourceLogicalID: "Policy", ResourceType: "test_policy", ResourceConfig: map[string]interface{}{
+		"document": "{}",
+	}}
+	h.Resources["attachment"] = &resource.Handler{ResourceLogicalID: "Attachment", ResourceType: "test_attachment", ResourceConfig: map[string]interface{}{
+		"role":       "role.ResourceConfig.name",
+		"policy_arn": "policy.ResourceState.ID",
+	}}
+	if diags := h.graph(); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	return h
+}
+
+// logicalIDs returns the logical IDs of a set of resources, sorted
+func logicalIDs(set map[*resource.Handler]bool) []string {
+	ids := []string{}
+	for r := range set {
+		ids = append(ids, r.ResourceLogicalID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestSelection(t *testing.T) {
+
+	h := testWalk(t)
+
+	// Without targets nothing is left out
+	if only, diags := h.selection(false); only != nil || len(diags) != 0 {
+		t.Fatalf("bad: %v %v", only, diags)
+	}
+
+	cases := map[string]struct {
+		targets    []string
+		dependents bool
+		expected   []string
+	}{
+		"dependencies": {[]string{"attachment"}, false, []string{"Attachment", "Policy", "Role"}},
+		"logical ID":   {[]string{"Role"}, false, []string{"Role"}},
+		"several":      {[]string{"Role", "policy"}, false, []string{"Policy", "Role"}},
+		"dependents":   {[]string{"Policy"}, true, []string{"Attachment", "Policy"}},
+		"leaf":         {[]string{"Attachment"}, true, []string{"Attachment"}},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			h.Targets = tc.targets
+			only, diags := h.selection(tc.dependents)
+			if diags.HasErrors() {
+				t.Fatalf("err: %s", diags.Err())
+			}
+			if got := logicalIDs(only); !reflect.DeepEqual(got, tc.expected) {
+				t.Fatalf("bad: %v", got)
+			}
+
+			// The partial walk is warned about
+			if len(diags) != 1 {
+				t.Fatalf("bad: %v", diags)
+			}
+			if desc := diags[0].Description(); desc.Summary != "Resource targeting is in effect" || !strings.Contains(desc.Detail, fmt.Sprintf("Only %d of 3", len(tc.expected))) {
+				t.Fatalf("bad: %s: %s", desc.Summary, desc.Detail)
+			}
+		})
+	}
+
+	// Unknown targets are rejected
+	h.Targets = []string{"Role", "Nope"}
+	only, diags := h.selection(false)
+	if only != nil || !diags.HasErrors() || diags[0].Description().Summary != "Invalid target" || !strings.Contains(diags[0].Description().Detail, "Nope") {
+		t.Fatalf("bad: %v %v", only, diags)
+	}
+}
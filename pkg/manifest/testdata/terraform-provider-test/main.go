@@ -0,0 +1,82 @@
+// Command terraform-provider-test is a provider used by the plugin tests. It
+// manages files in the directory given in the provider configuration.
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/plugin"
+)
+
+func main() {
+	plugin.Serve(&plugin.ServeOpts{ProviderFunc: provider})
+}
+
+func provider() *schema.Provider {
+	return &schema.Provider{
+		Schema: map[string]*schema.Schema{
+			"dir": {Type: schema.TypeString, Required: true},
+		},
+		ResourcesMap: map[string]*schema.Resource{
+			"test_file": resourceFile(),
+		},
+		ConfigureContextFunc: func(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
+			return d.Get("dir").(string), nil
+		},
+	}
+}
+
+func resourceFile() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"name":    {Type: schema.TypeString, Required: true, ForceNew: true},
+			"content": {Type: schema.TypeString, Optional: true},
+			"path":    {Type: schema.TypeString, Computed: true},
+		},
+		CreateContext: func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+			d.SetId(d.Get("name").(string))
+			return write(d, meta)
+		},
+		ReadContext: func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+			data, err := ioutil.ReadFile(filepath.Join(meta.(string), d.Id()))
+			if os.IsNotExist(err) {
+				d.SetId("")
+				return nil
+			}
+			if err != nil {
+				return fromErr(err)
+			}
+			d.Set("content", string(data))
+			return nil
+		},
+		UpdateContext: func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+			// The plugin dies mid-call, the client gets no state back
+			if d.Get("content").(string) == "crash" {
+				os.Exit(1)
+			}
+			return write(d, meta)
+		},
+		DeleteContext: func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+			return fromErr(os.Remove(filepath.Join(meta.(string), d.Id())))
+		},
+	}
+}
+
+func write(d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	path := filepath.Join(meta.(string), d.Id())
+	d.Set("path", path)
+	return fromErr(ioutil.WriteFile(path, []byte(d.Get("content").(string)), 0644))
+}
+
+// fromErr is diag.FromErr without the nil panic of this SDK version
+func fromErr(err error) diag.Diagnostics {
+	if err == nil {
+		return nil
+	}
+	return diag.FromErr(err)
+}
@@ -0,0 +1,169 @@
+package manifest
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"context"
+	"fmt"
+	"sort"
+
+	// terraform
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+
+	// terramorph
+	"github.com/h0tbird/terramorph/pkg/provider"
+	"github.com/h0tbird/terramorph/pkg/resource"
+	"github.com/h0tbird/terramorph/pkg/tfd"
+)
+
+//-----------------------------------------------------------------------------
+// Methods
+//-----------------------------------------------------------------------------
+
+// Validate checks every resource config against the schema of its provider
+// before anything is changed: unknown resource types, unknown arguments,
+// missing required arguments, type mismatches, the schema validation
+// functions and the timeouts block. References to the state of other
+// resources are unknown at this point and, like outputs, are only checked for
+// their target.
+func (h *Handler) Validate(ctx context.Context) tfd.Diagnostics {
+
+	var diags tfd.Diagnostics
+
+	keys := []string{}
+	for key := range h.Resources {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+
+		r := h.Resources[key]
+
+		p, err := h.Provider(r)
+		if err != nil {
+			diags = diags.Append(tfd.Sourceless(tfd.Error, "Invalid resource", err.Error()))
+			continue
+		}
+
+		config, refDiags := h.validationConfig(r)
+		diags = diags.Append(refDiags)
+		if refDiags.HasErrors() {
+			continue
+		}
+
+		// Out-of-process provider
+		if p.Plugin() != nil {
+			if _, err := r.ConfigTimeouts(); err != nil {
+				diags = diags.Append(tfd.Sourceless(tfd.Error, "Invalid timeouts",
+					fmt.Sprintf("%s (%s): %s", r.ResourceLogicalID, r.ResourceType, err)))
+				continue
+			}
+			rs, _ := p.Plugin().ResourceSchema(r.ResourceType)
+			val, err := rs.Block.CoerceValue(config)
+			if err != nil {
+				if perr, ok := err.(cty.PathError); ok && len(perr.Path) > 0 {
+					err = fmt.Errorf("%s: %s", pathString(perr.Path), perr.Error())
+				}
+				diags = diags.Append(tfd.Sourceless(tfd.Error, "Invalid resource configuration",
+					fmt.Sprintf("%s (%s): %s", r.ResourceLogicalID, r.ResourceType, err)))
+				continue
+			}
+			diags = diags.Append(prefixed(r, p.Plugin().ValidateResourceTypeConfig(ctx, r.ResourceType, val)))
+			continue
+		}
+
+		rc := terraform.NewResourceConfigRaw(config)
+		if err := (&schema.ResourceTimeout{}).ConfigDecode(p.Instance().ResourcesMap[r.ResourceType], rc); err != nil {
+			diags = diags.Append(tfd.Sourceless(tfd.Error, "Invalid timeouts",
+				fmt.Sprintf("%s (%s): %s", r.ResourceLogicalID, r.ResourceType, err)))
+			continue
+		}
+		diags = diags.Append(prefixed(r, fromSDK(p.Instance().ValidateResource(r.ResourceType, rc))))
+	}
+
+	diags = diags.Append(h.validateOutputs())
+
+	return diags
+}
+
+// validationConfig returns the ResourceConfig of a resource with the
+// references resolved: config references to their value and state
+// references to the unknown placeholder.
+func (h *Handler) validationConfig(r *resource.Handler) (map[string]interface{}, tfd.Diagnostics) {
+
+	var diags tfd.Diagnostics
+	config := map[string]interface{}{}
+
+	for k, v := range r.ResourceConfig {
+
+		config[k] = v
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+
+		submatch := resource.Reg.FindStringSubmatch(s)
+		if submatch == nil {
+			continue
+		}
+
+		target, ok := h.Resources[submatch[1]]
+		if !ok {
+			diags = diags.Append(undeclaredReference(r, k, submatch[1]))
+			continue
+		}
+
+		switch submatch[2] {
+		case "ResourceConfig":
+			config[k] = target.ResourceConfig[submatch[3]]
+			if config[k] == nil {
+				config[k] = provider.UnknownValue
+			}
+		case "ResourceState":
+			config[k] = provider.UnknownValue
+		}
+	}
+
+	return config, diags
+}
+
+//-----------------------------------------------------------------------------
+// Helpers
+//-----------------------------------------------------------------------------
+
+// undeclaredReference reports an argument referencing a missing resource
+func undeclaredReference(r *resource.Handler, arg, name string) tfd.Diagnostic {
+	return tfd.Sourceless(tfd.Error, "Reference to undeclared resource",
+		fmt.Sprintf("%s: argument %q references %q which is not declared", r.ResourceLogicalID, arg, name))
+}
+
+// sortedFields returns the arguments of a config, sorted
+func sortedFields(config map[string]interface{}) []string {
+	keys := []string{}
+	for k := range config {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// prefixed adds the resource to the summary of each diagnostic
+func prefixed(r *resource.Handler, in tfd.Diagnostics) tfd.Diagnostics {
+
+	var diags tfd.Diagnostics
+
+	for _, d := range in {
+		desc := d.Description()
+		diags = diags.Append(tfd.Sourceless(d.Severity(),
+			fmt.Sprintf("%s (%s): %s", r.ResourceLogicalID, r.ResourceType, desc.Summary), desc.Detail))
+	}
+
+	return diags
+}
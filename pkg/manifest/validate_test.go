@@ -0,0 +1,103 @@
+package manifest
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+
+	"github.com/h0tbird/terramorph/pkg/provider"
+	"github.com/h0tbird/terramorph/pkg/resource"
+	"github.com/h0tbird/terramorph/pkg/state"
+)
+
+func validateRegistry() *provider.Registry {
+	reg := provider.NewRegistry()
+	reg.Register("test", func() *schema.Provider {
+		return &schema.Provider{
+			ResourcesMap: map[string]*schema.Resource{
+				"test_role": {
+					Schema: map[string]*schema.Schema{
+						"name":                 {Type: schema.TypeString, Required: true},
+						"assume_role_policy":   {Type: schema.TypeString, Optional: true, ValidateFunc: validation.StringIsJSON},
+						"max_session_duration": {Type: schema.TypeInt, Optional: true},
+						"name_prefix":          {Type: schema.TypeString, Optional: true, ConflictsWith: []string{"path"}},
+						"path":                 {Type: schema.TypeString, Optional: true},
+					},
+				},
+			},
+		}
+	})
+	return reg
+}
+
+func TestValidate(t *testing.T) {
+	cases := map[string]struct {
+		config   map[string]interface{}
+		expected string
+	}{
+		"valid":            {map[string]interface{}{"name": "nodes", "assume_role_policy": "{}"}, ""},
+		"unknown key":      {map[string]interface{}{"name": "nodes", "assume_role_polciy": "{}"}, "assume_role_polciy"},
+		"missing required": {map[string]interface{}{"path": "/"}, "name"},
+		"type mismatch":    {map[string]interface{}{"name": "nodes", "max_session_duration": "long"}, "max_session_duration"},
+		"validate func":    {map[string]interface{}{"name": "nodes", "assume_role_policy": "{"}, "assume_role_policy"},
+		"conflicts with":   {map[string]interface{}{"name": "nodes", "name_prefix": "n", "path": "/"}, "conflicts"},
+		"state reference":  {map[string]interface{}{"name": "policy.ResourceState.ID"}, ""},
+		"config reference": {map[string]interface{}{"name": "policy.ResourceConfig.name"}, ""},
+		"dangling":         {map[string]interface{}{"name": "nope.ResourceState.ID"}, "nope"},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			h := New()
+			h.Resources["role"] = &resource.Handler{ResourceLogicalID: "Role", ResourceType: "test_role", ResourceConfig: tc.config}
+			h.Resources["policy"] = &resource.Handler{ResourceLogicalID: "Policy", ResourceType: "test_role", ResourceConfig: map[string]interface{}{"name": "policy"}}
+
+			if diags := h.ConfigureProviders(context.Background(), validateRegistry()); diags.HasErrors() {
+				t.Fatalf("err: %s", diags.Err())
+			}
+
+			diags := h.Validate(context.Background())
+			if tc.expected == "" {
+				if diags.HasErrors() {
+					t.Fatalf("err: %s", diags.Err())
+				}
+				return
+			}
+
+			if !diags.HasErrors() {
+				t.Fatal("expected error")
+			}
+			if err := diags.Err().Error(); !strings.Contains(err, tc.expected) || !strings.Contains(err, "Role") {
+				t.Fatalf("bad: %s", err)
+			}
+		})
+	}
+}
+
+func TestValidateUnknownType(t *testing.T) {
+	h := New()
+	h.Resources["a"] = &resource.Handler{ResourceLogicalID: "A", ResourceType: "test_nope"}
+	h.Resources["b"] = &resource.Handler{ResourceLogicalID: "B", ResourceType: "test_role", ResourceConfig: map[string]interface{}{}}
+
+	if diags := h.ConfigureProviders(context.Background(), validateRegistry()); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+
+	// Every problem is reported, not only the first one
+	diags := h.Validate(context.Background())
+	if len(diags) != 2 {
+		t.Fatalf("bad: %s", diags.Err())
+	}
+
+	// Apply stops before touching the state
+	s := state.NewMemory()
+	if diags := h.Apply(context.Background(), s); !diags.HasErrors() {
+		t.Fatalf("bad: %v", diags)
+	}
+	if ids, _ := s.List(); len(ids) != 0 {
+		t.Fatalf("bad: %v", ids)
+	}
+}
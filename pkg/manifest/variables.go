@@ -0,0 +1,66 @@
+package manifest
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"fmt"
+	"regexp"
+)
+
+//-----------------------------------------------------------------------------
+// Globals
+//-----------------------------------------------------------------------------
+
+// VarReg var.<name>
+var VarReg = regexp.MustCompile("^var\\.(\\w+)$")
+
+//-----------------------------------------------------------------------------
+// Functions
+//-----------------------------------------------------------------------------
+
+// interpolate returns a deep copy of v with every var.<name> reference
+// replaced by the value of the variable.
+func interpolate(v interface{}, vars map[string]interface{}) (interface{}, error) {
+
+	switch v := v.(type) {
+
+	case string:
+		submatch := VarReg.FindStringSubmatch(v)
+		if submatch == nil {
+			return v, nil
+		}
+		val, ok := vars[submatch[1]]
+		if !ok {
+			return nil, fmt.Errorf("undefined variable %q", submatch[1])
+		}
+		return val, nil
+
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			i, err := interpolate(val, vars)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", key, err)
+			}
+			m[key] = i
+		}
+		return m, nil
+
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for idx, val := range v {
+			i, err := interpolate(val, vars)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %s", idx, err)
+			}
+			l[idx] = i
+		}
+		return l, nil
+	}
+
+	return v, nil
+}
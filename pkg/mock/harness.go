@@ -0,0 +1,103 @@
+package mock
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"context"
+
+	// terraform
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+
+	// terramorph
+	"github.com/h0tbird/terramorph/pkg/manifest"
+	"github.com/h0tbird/terramorph/pkg/provider"
+	"github.com/h0tbird/terramorph/pkg/resource"
+	"github.com/h0tbird/terramorph/pkg/state"
+	"github.com/h0tbird/terramorph/pkg/tfd"
+)
+
+//-----------------------------------------------------------------------------
+// Types
+//-----------------------------------------------------------------------------
+
+// Harness runs a manifest against the mock provider and an in-memory state,
+// so manifests can be applied, planned and destroyed in go test.
+type Harness struct {
+	Backend    *Backend
+	State      *state.Memory
+	Manifest   *manifest.Handler
+	Registry   *provider.Registry
+	configured bool
+}
+
+//-----------------------------------------------------------------------------
+// Constructor
+//-----------------------------------------------------------------------------
+
+// NewHarness registers the mock provider under name. The fake resource types
+// must be prefixed with it, mock_role for the mock provider.
+func NewHarness(name string, resources map[string]*Resource) *Harness {
+
+	h := &Harness{
+		Backend:  NewBackend(),
+		State:    state.NewMemory(),
+		Manifest: manifest.New(),
+		Registry: provider.NewRegistry(),
+	}
+
+	h.Registry.Register(name, func() *schema.Provider {
+		return NewProvider(h.Backend, resources)
+	})
+
+	return h
+}
+
+//-----------------------------------------------------------------------------
+// Methods
+//-----------------------------------------------------------------------------
+
+// Apply ...
+func (h *Harness) Apply(ctx context.Context) tfd.Diagnostics {
+	if diags := h.configure(ctx); diags.HasErrors() {
+		return diags
+	}
+	return h.Manifest.Apply(ctx, h.State)
+}
+
+// Plan ...
+func (h *Harness) Plan(ctx context.Context) ([]*resource.Change, tfd.Diagnostics) {
+	if diags := h.configure(ctx); diags.HasErrors() {
+		return nil, diags
+	}
+	return h.Manifest.Plan(ctx, h.State)
+}
+
+// Destroy ...
+func (h *Harness) Destroy(ctx context.Context) tfd.Diagnostics {
+	if diags := h.configure(ctx); diags.HasErrors() {
+		return diags
+	}
+	return h.Manifest.Destroy(ctx, h.State)
+}
+
+// Import ...
+func (h *Harness) Import(ctx context.Context, key, id string) tfd.Diagnostics {
+	if diags := h.configure(ctx); diags.HasErrors() {
+		return diags
+	}
+	return h.Manifest.Import(ctx, h.State, key, id)
+}
+
+// configure configures the providers on first use
+func (h *Harness) configure(ctx context.Context) tfd.Diagnostics {
+	if h.configured {
+		return nil
+	}
+	diags := h.Manifest.ConfigureProviders(ctx, h.Registry)
+	h.configured = !diags.HasErrors()
+	return diags
+}
@@ -0,0 +1,464 @@
+package mock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+
+	"github.com/h0tbird/terramorph/pkg/event"
+	"github.com/h0tbird/terramorph/pkg/logger"
+	"github.com/h0tbird/terramorph/pkg/manifest"
+	"github.com/h0tbird/terramorph/pkg/resource"
+)
+
+func testHarness() *Harness {
+	h := NewHarness("mock", map[string]*Resource{
+		"mock_role": {
+			IDAttribute: "name",
+			Timeouts:    &schema.ResourceTimeout{Create: schema.DefaultTimeout(time.Minute)},
+			Schema: map[string]*schema.Schema{
+				"name":   {Type: schema.TypeString, Required: true, ForceNew: true},
+				"policy": {Type: schema.TypeString, Optional: true},
+				"arn":    {Type: schema.TypeString, Computed: true},
+			},
+		},
+		"mock_policy": {
+			Schema: map[string]*schema.Schema{
+				"document": {Type: schema.TypeString, Required: true},
+				"arn":      {Type: schema.TypeString, Computed: true},
+			},
+		},
+		"mock_attachment": {
+			Schema: map[string]*schema.Schema{
+				"role":       {Type: schema.TypeString, Required: true, ForceNew: true},
+				"policy_arn": {Type: schema.TypeString, Required: true, ForceNew: true},
+			},
+		},
+	})
+
+	h.Manifest.Resources["role"] = &resource.Handler{
+		ResourceLogicalID: "Role",
+		ResourceType:      "mock_role",
+		ResourceConfig:    map[string]interface{}{"name": "nodes", "policy": "{}"},
+	}
+	h.Manifest.Resources["policy"] = &resource.Handler{
+		ResourceLogicalID: "Policy",
+		ResourceType:      "mock_policy",
+		ResourceConfig:    map[string]interface{}{"document": "{}"},
+	}
+	h.Manifest.Resources["attachment"] = &resource.Handler{
+		ResourceLogicalID: "Attachment",
+		ResourceType:      "mock_attachment",
+		ResourceConfig: map[string]interface{}{
+			"role":       "role.ResourceConfig.name",
+			"policy_arn": "policy.ResourceState.ID",
+		},
+	}
+
+	return h
+}
+
+func actions(changes []*resource.Change) map[string]resource.Action {
+	m := map[string]resource.Action{}
+	for _, c := range changes {
+		m[c.LogicalID] = c.Action
+	}
+	return m
+}
+
+func TestHarness(t *testing.T) {
+	ctx := context.Background()
+	h := testHarness()
+
+	// Plan from scratch
+	changes, diags := h.Plan(ctx)
+	if diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	expected := map[string]resource.Action{"Attachment": resource.Create, "Policy": resource.Create, "Role": resource.Create}
+	if !reflect.DeepEqual(actions(changes), expected) {
+		t.Fatalf("bad: %#v", actions(changes))
+	}
+	if len(h.Backend.IDs("mock_role")) != 0 {
+		t.Fatal("plan created resources")
+	}
+
+	// Apply resolves the references in dependency order
+	if diags := h.Apply(ctx); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	attachments := h.Backend.IDs("mock_attachment")
+	if len(attachments) != 1 {
+		t.Fatalf("bad: %#v", attachments)
+	}
+	obj, _ := h.Backend.Get("mock_attachment", attachments[0])
+	if obj["role"] != "nodes" || obj["policy_arn"] != "mock_policy-1" {
+		t.Fatalf("bad: %#v", obj)
+	}
+	role, _ := h.Backend.Get("mock_role", "nodes")
+	if role["arn"] != "mock:mock_role:nodes:arn" {
+		t.Fatalf("bad: %#v", role)
+	}
+
+	// Nothing to do the second time
+	changes, diags = h.Plan(ctx)
+	if diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	for _, c := range changes {
+		if c.Action != resource.NoOp {
+			t.Fatalf("bad: %#v", c)
+		}
+	}
+
+	// Drift is planned as an update
+	role["policy"] = "changed"
+	h.Backend.Put("mock_role", "nodes", role)
+	changes, _ = h.Plan(ctx)
+	if a := actions(changes); a["Role"] != resource.Update || a["Attachment"] != resource.NoOp {
+		t.Fatalf("bad: %#v", a)
+	}
+
+	// ForceNew changes replace the resource and its dependents see unknowns
+	h.Manifest.Resources["role"].ResourceConfig["name"] = "workers"
+	changes, _ = h.Plan(ctx)
+	if a := actions(changes); a["Role"] != resource.Replace || a["Attachment"] != resource.Replace {
+		t.Fatalf("bad: %#v", a)
+	}
+
+	if diags := h.Apply(ctx); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	if ids := h.Backend.IDs("mock_role"); !reflect.DeepEqual(ids, []string{"workers"}) {
+		t.Fatalf("bad: %#v", ids)
+	}
+
+	// Destroy removes dependents first
+	h.Backend.ResetCalls()
+	if diags := h.Destroy(ctx); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	deletes := []string{}
+	for _, c := range h.Backend.Calls() {
+		if c[:6] == "delete" {
+			deletes = append(deletes, c)
+		}
+	}
+	if len(deletes) != 3 || deletes[0][:22] != "delete mock_attachment" {
+		t.Fatalf("bad: %#v", deletes)
+	}
+	for _, resourceType := range []string{"mock_role", "mock_policy", "mock_attachment"} {
+		if ids := h.Backend.IDs(resourceType); len(ids) != 0 {
+			t.Fatalf("%s: bad: %#v", resourceType, ids)
+		}
+	}
+}
+
+func TestHarnessFailure(t *testing.T) {
+	ctx := context.Background()
+	h := testHarness()
+	h.Manifest.OnFailure = manifest.ContinueIndependent
+	h.Backend.Fail("mock_policy", errors.New("throttled"))
+
+	diags := h.Apply(ctx)
+	if !diags.HasErrors() {
+		t.Fatal("expected error")
+	}
+
+	// The independent role is created, the attachment is skipped
+	if len(h.Backend.IDs("mock_role")) != 1 || len(h.Backend.IDs("mock_attachment")) != 0 {
+		t.Fatalf("bad: %#v", h.Backend.Calls())
+	}
+
+	h.Backend.Fail("mock_policy", nil)
+	if diags := h.Apply(ctx); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	if len(h.Backend.IDs("mock_attachment")) != 1 {
+		t.Fatalf("bad: %#v", h.Backend.Calls())
+	}
+}
+
+func TestHarnessRetry(t *testing.T) {
+	ctx := context.Background()
+	h := testHarness()
+	retry := &resource.RetryPolicy{
+		MaxAttempts: 3,
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  time.Millisecond,
+		Retryable:   resource.DefaultRetryable,
+	}
+	for _, r := range h.Manifest.Resources {
+		r.Retry = retry
+	}
+
+	// Eventual consistency is retried until it goes away
+	h.Backend.FailNext("mock_attachment", errors.New("NoSuchEntity: role nodes not found"), 2)
+	if diags := h.Apply(ctx); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	if n := count(h.Backend.Calls(), "create mock_attachment"); n != 3 {
+		t.Fatalf("bad: %#v", h.Backend.Calls())
+	}
+
+	// Other errors are not retried
+	h.Backend.ResetCalls()
+	h.Manifest.Resources["policy"].ResourceConfig["document"] = "{\"Version\":\"2012-10-17\"}"
+	h.Backend.Fail("mock_policy", errors.New("AccessDenied"))
+	if diags := h.Apply(ctx); !diags.HasErrors() {
+		t.Fatal("expected error")
+	}
+	if n := count(h.Backend.Calls(), "read mock_policy"); n != 1 {
+		t.Fatalf("bad: %#v", h.Backend.Calls())
+	}
+
+	// Attempts are bounded
+	h.Backend.ResetCalls()
+	h.Backend.Fail("mock_policy", errors.New("Throttling: Rate exceeded"))
+	if diags := h.Apply(ctx); !diags.HasErrors() {
+		t.Fatal("expected error")
+	}
+	if n := count(h.Backend.Calls(), "read mock_policy"); n != 3 {
+		t.Fatalf("bad: %#v", h.Backend.Calls())
+	}
+}
+
+func count(calls []string, prefix string) int {
+	n := 0
+	for _, c := range calls {
+		if strings.HasPrefix(c, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestHarnessTimeouts(t *testing.T) {
+	ctx := context.Background()
+	h := testHarness()
+
+	// Unsupported and malformed timeouts are rejected upfront
+	h.Manifest.Resources["role"].ResourceConfig["timeouts"] = map[string]interface{}{"delete": "1m"}
+	h.Manifest.Resources["policy"].ResourceConfig["timeouts"] = map[string]interface{}{"create": "1m"}
+	diags := h.Apply(ctx)
+	if len(diags) != 2 || diags[0].Description().Summary != "Invalid timeouts" {
+		t.Fatalf("bad: %s", diags.Err())
+	}
+	delete(h.Manifest.Resources["policy"].ResourceConfig, "timeouts")
+
+	// The operation is cancelled at the deadline
+	h.Manifest.Resources["role"].ResourceConfig["timeouts"] = map[string]interface{}{"create": "50ms"}
+	h.Backend.Slow("mock_role", time.Minute)
+	start := time.Now()
+	diags = h.Apply(ctx)
+	if !diags.HasErrors() || diags[0].Description().Summary != "Role (mock_role) timed out" {
+		t.Fatalf("bad: %s", diags.Err())
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Fatalf("too slow: %s", d)
+	}
+	if !strings.Contains(diags[0].Description().Detail, "create did not complete within 50ms") {
+		t.Fatalf("bad: %s", diags[0].Description().Detail)
+	}
+
+	// It succeeds within the timeout
+	h.Backend.Slow("mock_role", 0)
+	if diags := h.Apply(ctx); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+}
+
+func TestHarnessImport(t *testing.T) {
+	ctx := context.Background()
+	h := testHarness()
+	h.Backend.Put("mock_role", "nodes", Object{"name": "nodes", "policy": "{}", "arn": "arn:nodes"})
+
+	// Unknown resources and IDs are rejected
+	if diags := h.Import(ctx, "nope", "nodes"); !diags.HasErrors() {
+		t.Fatal("expected an error")
+	}
+	if diags := h.Import(ctx, "role", "other"); !diags.HasErrors() {
+		t.Fatal("expected an error")
+	}
+
+	// The role is adopted by its logical ID
+	if diags := h.Import(ctx, "Role", "nodes"); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	changes, diags := h.Plan(ctx)
+	if diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	if a := actions(changes)["Role"]; a != resource.NoOp {
+		t.Fatalf("bad: %s", a)
+	}
+
+	// Managed resources are not imported twice
+	if diags := h.Import(ctx, "role", "nodes"); !diags.HasErrors() {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestHarnessParallelism(t *testing.T) {
+	ctx := context.Background()
+	h := testHarness()
+	h.Manifest.Parallelism = 10
+	h.Backend.Slow("mock_role", 200*time.Millisecond)
+	h.Backend.Slow("mock_policy", 200*time.Millisecond)
+
+	// The role and the policy are created at the same time
+	start := time.Now()
+	if diags := h.Apply(ctx); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	if d := time.Since(start); d >= 350*time.Millisecond {
+		t.Fatalf("too slow: %s", d)
+	}
+	if ids := h.Backend.IDs("mock_attachment"); len(ids) != 1 {
+		t.Fatalf("bad: %v", ids)
+	}
+}
+
+func TestHarnessTargets(t *testing.T) {
+	ctx := context.Background()
+	h := testHarness()
+
+	// A target is applied with its dependencies only
+	h.Manifest.Targets = []string{"Role"}
+	diags := h.Apply(ctx)
+	if diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	if len(diags) != 1 || diags[0].Description().Summary != "Resource targeting is in effect" {
+		t.Fatalf("bad: %v", diags)
+	}
+	if len(h.Backend.IDs("mock_role")) != 1 || len(h.Backend.IDs("mock_policy")) != 0 {
+		t.Fatalf("bad: %v %v", h.Backend.IDs("mock_role"), h.Backend.IDs("mock_policy"))
+	}
+
+	h.Manifest.Targets = []string{"attachment"}
+	changes, diags := h.Plan(ctx)
+	if diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	expected := map[string]resource.Action{"Attachment": resource.Create, "Policy": resource.Create, "Role": resource.NoOp}
+	if got := actions(changes); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("bad: %v", got)
+	}
+	if diags := h.Apply(ctx); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+
+	// A target is destroyed with its dependents only
+	h.Manifest.Targets = []string{"Policy"}
+	changes, err := h.Manifest.PlanDestroy(h.State)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(changes) != 2 || changes[0].LogicalID != "Attachment" || changes[1].LogicalID != "Policy" {
+		t.Fatalf("bad: %v", changes)
+	}
+	if diags := h.Destroy(ctx); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	if len(h.Backend.IDs("mock_role")) != 1 || len(h.Backend.IDs("mock_policy")) != 0 || len(h.Backend.IDs("mock_attachment")) != 0 {
+		t.Fatalf("bad: %v %v", h.Backend.IDs("mock_role"), h.Backend.IDs("mock_policy"))
+	}
+}
+
+func TestHarnessStop(t *testing.T) {
+	ctx := context.Background()
+	h := testHarness()
+	h.Manifest.Parallelism = 10
+	h.Backend.Slow("mock_role", 200*time.Millisecond)
+
+	// Stop while the role is being created
+	stop := make(chan struct{})
+	h.Manifest.Stop = stop
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(stop)
+	}()
+
+	// The role finishes and its state is saved, the attachment is never
+	// started
+	if diags := h.Apply(ctx); !diags.HasErrors() {
+		t.Fatal("expected an error")
+	}
+	if len(h.Backend.IDs("mock_role")) != 1 || len(h.Backend.IDs("mock_attachment")) != 0 {
+		t.Fatalf("bad: %v %v", h.Backend.IDs("mock_role"), h.Backend.IDs("mock_attachment"))
+	}
+	is := &terraform.InstanceState{}
+	if err := h.State.Read("Role", is); err != nil || is.ID != "nodes" {
+		t.Fatalf("bad: %#v %v", is, err)
+	}
+
+	// The next run carries on
+	h.Manifest.Stop = nil
+	if diags := h.Apply(ctx); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	if ids := h.Backend.IDs("mock_attachment"); len(ids) != 1 {
+		t.Fatalf("bad: %v", ids)
+	}
+	if ids := h.Backend.IDs("mock_role"); len(ids) != 1 {
+		t.Fatalf("bad: %v", ids)
+	}
+}
+
+func TestHarnessEvents(t *testing.T) {
+	ctx := context.Background()
+	h := testHarness()
+	h.Manifest.OnFailure = manifest.ContinueIndependent
+
+	var l sync.Mutex
+	events := map[string][]event.Kind{}
+	h.Manifest.Events = event.SinkFunc(func(e event.Event) {
+		l.Lock()
+		defer l.Unlock()
+		if e.Time.IsZero() {
+			t.Errorf("bad: %#v", e)
+		}
+		events[e.LogicalID] = append(events[e.LogicalID], e.Kind)
+	})
+
+	// The policy fails and the attachment is skipped
+	h.Backend.Fail("mock_policy", errors.New("AccessDenied"))
+	if diags := h.Apply(ctx); !diags.HasErrors() {
+		t.Fatal("expected an error")
+	}
+
+	expected := map[string][]event.Kind{
+		"":           {event.WalkStarted, event.WalkDone},
+		"Role":       {event.Refreshing, event.Diffing, event.Applying, event.Done},
+		"Policy":     {event.Refreshing, event.Diffing, event.Applying, event.Failed},
+		"Attachment": {event.Skipped},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("bad: %v", events)
+	}
+}
+
+func TestHarnessLog(t *testing.T) {
+	ctx := context.Background()
+	h := testHarness()
+
+	// Resources log through the logger of the manifest
+	b := &bytes.Buffer{}
+	h.Manifest.Log, _ = logger.New(logger.Config{Level: logger.Warn, Output: b})
+	h.Backend.FailNext("mock_policy", errors.New("Throttling: Rate exceeded"), 1)
+	if diags := h.Apply(ctx); diags.HasErrors() {
+		t.Fatalf("err: %s", diags.Err())
+	}
+	if out := b.String(); !strings.Contains(out, "msg=Retrying") || !strings.Contains(out, "id=Policy") || strings.Contains(out, "level=info") {
+		t.Fatalf("bad: %s", out)
+	}
+}
@@ -0,0 +1,340 @@
+package mock
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	// terraform
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+//-----------------------------------------------------------------------------
+// Types
+//-----------------------------------------------------------------------------
+
+// Object is the stored form of a fake resource
+type Object map[string]interface{}
+
+// Resource declares a fake resource type
+type Resource struct {
+
+	// Schema of the resource type
+	Schema map[string]*schema.Schema
+
+	// IDAttribute names the attribute used as the ID. A sequence number is
+	// used when empty.
+	IDAttribute string
+
+	// Timeouts supported by the resource type, with their defaults
+	Timeouts *schema.ResourceTimeout
+}
+
+// Backend is the in-memory API behind the mock provider. Objects are kept by
+// resource type and ID and every call is recorded.
+type Backend struct {
+	mu       sync.Mutex
+	objects  map[string]map[string]Object
+	failures map[string]error
+	failNext map[string]int
+	delays   map[string]time.Duration
+	calls    []string
+	next     int
+}
+
+//-----------------------------------------------------------------------------
+// Constructor
+//-----------------------------------------------------------------------------
+
+// NewBackend ...
+func NewBackend() *Backend {
+	return &Backend{
+		objects:  map[string]map[string]Object{},
+		failures: map[string]error{},
+		failNext: map[string]int{},
+		delays:   map[string]time.Duration{},
+	}
+}
+
+// NewProvider returns a provider serving the given fake resource types from
+// the backend. Computed string attributes not set in the config are filled
+// with a value derived from the ID, the way an ARN would be.
+func NewProvider(b *Backend, resources map[string]*Resource) *schema.Provider {
+
+	p := &schema.Provider{
+		ResourcesMap: map[string]*schema.Resource{},
+	}
+
+	for resourceType, r := range resources {
+		p.ResourcesMap[resourceType] = b.resource(resourceType, r)
+	}
+
+	return p
+}
+
+//-----------------------------------------------------------------------------
+// Backend
+//-----------------------------------------------------------------------------
+
+// Get returns a copy of a stored object
+func (b *Backend) Get(resourceType, id string) (Object, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	obj, ok := b.objects[resourceType][id]
+	return obj.copy(), ok
+}
+
+// Put stores an object, out of band. Use it to simulate drift.
+func (b *Backend) Put(resourceType, id string, obj Object) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.put(resourceType, id, obj.copy())
+}
+
+// Remove deletes an object, out of band
+func (b *Backend) Remove(resourceType, id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.objects[resourceType], id)
+}
+
+// IDs returns the IDs of the stored objects of a type, sorted
+func (b *Backend) IDs(resourceType string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ids := []string{}
+	for id := range b.objects[resourceType] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Fail makes every call for a resource type return err. A nil err clears it.
+func (b *Backend) Fail(resourceType string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.failNext, resourceType)
+	if err == nil {
+		delete(b.failures, resourceType)
+		return
+	}
+	b.failures[resourceType] = err
+}
+
+// FailNext makes the next n calls for a resource type return err, the way a
+// transient error would.
+func (b *Backend) FailNext(resourceType string, err error, n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures[resourceType] = err
+	b.failNext[resourceType] = n
+}
+
+// Slow makes every call for a resource type take d, or until its context is
+// done. A zero d clears it.
+func (b *Backend) Slow(resourceType string, d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.delays[resourceType] = d
+}
+
+// Calls returns the calls made so far as "create mock_role nodes"
+func (b *Backend) Calls() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string{}, b.calls...)
+}
+
+// ResetCalls forgets the recorded calls
+func (b *Backend) ResetCalls() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls = nil
+}
+
+func (b *Backend) resource(resourceType string, r *Resource) *schema.Resource {
+
+	// Store the config and fill the computed attributes
+	write := func(d *schema.ResourceData) {
+		obj := Object{}
+		for name, s := range r.Schema {
+			v, ok := d.GetOk(name)
+			switch {
+			case ok:
+				obj[name] = plain(v)
+			case s.Computed && s.Type == schema.TypeString:
+				obj[name] = fmt.Sprintf("mock:%s:%s:%s", resourceType, d.Id(), name)
+			}
+		}
+		b.put(resourceType, d.Id(), obj)
+	}
+
+	rp := &schema.Resource{
+		Schema:   r.Schema,
+		Timeouts: r.Timeouts,
+		CreateContext: func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+			if err := b.wait(ctx, resourceType); err != nil {
+				return diag.FromErr(err)
+			}
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			id := ""
+			if r.IDAttribute != "" {
+				id = d.Get(r.IDAttribute).(string)
+			} else {
+				b.next++
+				id = fmt.Sprintf("%s-%d", resourceType, b.next)
+			}
+
+			if err := b.call("create", resourceType, id); err != nil {
+				return diag.FromErr(err)
+			}
+
+			d.SetId(id)
+			write(d)
+			return b.read(resourceType, d)
+		},
+		ReadContext: func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+			if err := b.wait(ctx, resourceType); err != nil {
+				return diag.FromErr(err)
+			}
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			if err := b.call("read", resourceType, d.Id()); err != nil {
+				return diag.FromErr(err)
+			}
+			return b.read(resourceType, d)
+		},
+		DeleteContext: func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+			if err := b.wait(ctx, resourceType); err != nil {
+				return diag.FromErr(err)
+			}
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			if err := b.call("delete", resourceType, d.Id()); err != nil {
+				return diag.FromErr(err)
+			}
+			delete(b.objects[resourceType], d.Id())
+			return nil
+		},
+		Importer: &schema.ResourceImporter{
+			StateContext: schema.ImportStatePassthroughContext,
+		},
+	}
+
+	// The SDK refuses an Update when every argument forces a new resource
+	for _, s := range r.Schema {
+		if !s.ForceNew && (s.Required || s.Optional) {
+			rp.UpdateContext = func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+				if err := b.wait(ctx, resourceType); err != nil {
+					return diag.FromErr(err)
+				}
+				b.mu.Lock()
+				defer b.mu.Unlock()
+				if err := b.call("update", resourceType, d.Id()); err != nil {
+					return diag.FromErr(err)
+				}
+				write(d)
+				return b.read(resourceType, d)
+			}
+			break
+		}
+	}
+
+	return rp
+}
+
+// read sets the resource data from the stored object or clears the ID when
+// the object is gone.
+func (b *Backend) read(resourceType string, d *schema.ResourceData) diag.Diagnostics {
+
+	obj, ok := b.objects[resourceType][d.Id()]
+	if !ok {
+		d.SetId("")
+		return nil
+	}
+
+	for name, v := range obj {
+		if err := d.Set(name, v); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	return nil
+}
+
+// wait delays a call of a slow resource type
+func (b *Backend) wait(ctx context.Context, resourceType string) error {
+
+	b.mu.Lock()
+	d := b.delays[resourceType]
+	b.mu.Unlock()
+	if d == 0 {
+		return nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *Backend) call(op, resourceType, id string) error {
+	b.calls = append(b.calls, fmt.Sprintf("%s %s %s", op, resourceType, id))
+	err := b.failures[resourceType]
+	if n, ok := b.failNext[resourceType]; ok && err != nil {
+		if n--; n > 0 {
+			b.failNext[resourceType] = n
+		} else {
+			delete(b.failNext, resourceType)
+			delete(b.failures, resourceType)
+		}
+	}
+	return err
+}
+
+func (b *Backend) put(resourceType, id string, obj Object) {
+	if b.objects[resourceType] == nil {
+		b.objects[resourceType] = map[string]Object{}
+	}
+	b.objects[resourceType][id] = obj
+}
+
+//-----------------------------------------------------------------------------
+// Helpers
+//-----------------------------------------------------------------------------
+
+func (o Object) copy() Object {
+	if o == nil {
+		return nil
+	}
+	c := Object{}
+	for k, v := range o {
+		c[k] = v
+	}
+	return c
+}
+
+// plain converts sets into lists so objects can be set back
+func plain(v interface{}) interface{} {
+	if s, ok := v.(*schema.Set); ok {
+		return s.List()
+	}
+	return v
+}
@@ -0,0 +1,194 @@
+package provider
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"fmt"
+	"sort"
+	"strings"
+
+	// terraform
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+//-----------------------------------------------------------------------------
+// Types
+//-----------------------------------------------------------------------------
+
+// Kinds of schema
+const (
+	KindResource   = "resource"
+	KindDataSource = "data source"
+)
+
+// TypeDescription is the schema of a resource or data source type
+type TypeDescription struct {
+	Type     string            `json:"type"`
+	Kind     string            `json:"kind"`
+	Provider string            `json:"provider"`
+	Version  int               `json:"version"`
+	Block    *BlockDescription `json:"block"`
+}
+
+// BlockDescription describes a configuration block
+type BlockDescription struct {
+	Attributes map[string]*AttributeDescription   `json:"attributes,omitempty"`
+	BlockTypes map[string]*NestedBlockDescription `json:"block_types,omitempty"`
+}
+
+// AttributeDescription describes an attribute
+type AttributeDescription struct {
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+	Optional    bool   `json:"optional,omitempty"`
+	Computed    bool   `json:"computed,omitempty"`
+	ForceNew    bool   `json:"force_new,omitempty"`
+	Sensitive   bool   `json:"sensitive,omitempty"`
+}
+
+// NestedBlockDescription describes a nested block
+type NestedBlockDescription struct {
+	Nesting     string            `json:"nesting"`
+	Description string            `json:"description,omitempty"`
+	Required    bool              `json:"required,omitempty"`
+	Optional    bool              `json:"optional,omitempty"`
+	Computed    bool              `json:"computed,omitempty"`
+	ForceNew    bool              `json:"force_new,omitempty"`
+	MinItems    int               `json:"min_items,omitempty"`
+	MaxItems    int               `json:"max_items,omitempty"`
+	Block       *BlockDescription `json:"block"`
+}
+
+//-----------------------------------------------------------------------------
+// Methods
+//-----------------------------------------------------------------------------
+
+// Types returns the resource and data source types of the registered
+// providers, sorted. Data sources are prefixed with data.
+func (r *Registry) Types() []string {
+
+	types := []string{}
+
+	for _, name := range r.Names() {
+		p, _ := r.Schema(name)
+		for t := range p.ResourcesMap {
+			types = append(types, t)
+		}
+		for t := range p.DataSourcesMap {
+			types = append(types, "data."+t)
+		}
+	}
+
+	sort.Strings(types)
+	return types
+}
+
+// Describe returns the schema of a resource type, or of a data source type
+// when the name is prefixed with data.
+func (r *Registry) Describe(typeName string) (*TypeDescription, error) {
+
+	kind, name := KindResource, typeName
+	if strings.HasPrefix(typeName, "data.") {
+		kind, name = KindDataSource, strings.TrimPrefix(typeName, "data.")
+	}
+
+	providerName, ok := r.Route(name)
+	if !ok {
+		return nil, fmt.Errorf("no registered provider manages %s", name)
+	}
+
+	p, _ := r.Schema(providerName)
+	rp, ok := p.ResourcesMap[name]
+	if kind == KindDataSource {
+		rp, ok = p.DataSourcesMap[name]
+	}
+	if !ok {
+		return nil, fmt.Errorf("provider %s has no %s type %s", providerName, kind, name)
+	}
+
+	return &TypeDescription{
+		Type:     name,
+		Kind:     kind,
+		Provider: providerName,
+		Version:  rp.SchemaVersion,
+		Block:    describeBlock(rp.Schema),
+	}, nil
+}
+
+//-----------------------------------------------------------------------------
+// Helpers
+//-----------------------------------------------------------------------------
+
+// describeBlock splits a schema map into attributes and nested blocks, the
+// way the plugin SDK does: lists and sets of resources are blocks.
+func describeBlock(m map[string]*schema.Schema) *BlockDescription {
+
+	b := &BlockDescription{
+		Attributes: map[string]*AttributeDescription{},
+		BlockTypes: map[string]*NestedBlockDescription{},
+	}
+
+	for name, s := range m {
+
+		if elem, ok := s.Elem.(*schema.Resource); ok && s.Type != schema.TypeMap {
+			nesting := "list"
+			if s.Type == schema.TypeSet {
+				nesting = "set"
+			}
+			b.BlockTypes[name] = &NestedBlockDescription{
+				Nesting:     nesting,
+				Description: s.Description,
+				Required:    s.Required,
+				Optional:    s.Optional,
+				Computed:    s.Computed,
+				ForceNew:    s.ForceNew,
+				MinItems:    s.MinItems,
+				MaxItems:    s.MaxItems,
+				Block:       describeBlock(elem.Schema),
+			}
+			continue
+		}
+
+		b.Attributes[name] = &AttributeDescription{
+			Type:        typeString(s),
+			Description: s.Description,
+			Required:    s.Required,
+			Optional:    s.Optional,
+			Computed:    s.Computed,
+			ForceNew:    s.ForceNew,
+			Sensitive:   s.Sensitive,
+		}
+	}
+
+	return b
+}
+
+// typeString renders a schema type as list(string)
+func typeString(s *schema.Schema) string {
+
+	switch s.Type {
+	case schema.TypeBool:
+		return "bool"
+	case schema.TypeInt, schema.TypeFloat:
+		return "number"
+	case schema.TypeString:
+		return "string"
+	case schema.TypeList, schema.TypeSet, schema.TypeMap:
+		elem := "string"
+		switch e := s.Elem.(type) {
+		case *schema.Schema:
+			elem = typeString(e)
+		case *schema.Resource:
+			elem = "object"
+		}
+		kind := map[schema.ValueType]string{schema.TypeList: "list", schema.TypeSet: "set", schema.TypeMap: "map"}[s.Type]
+		return fmt.Sprintf("%s(%s)", kind, elem)
+	}
+
+	return "dynamic"
+}
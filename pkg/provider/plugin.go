@@ -0,0 +1,613 @@
+package provider
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strconv"
+	"sync"
+
+	// community
+	hclog "github.com/hashicorp/go-hclog"
+	goplugin "github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	// terraform
+	"github.com/hashicorp/go-cty/cty"
+	ctyjson "github.com/hashicorp/go-cty/cty/json"
+	"github.com/hashicorp/go-cty/cty/msgpack"
+	tfplugin "github.com/hashicorp/terraform-plugin-sdk/v2/plugin"
+
+	// terramorph
+	"github.com/h0tbird/terramorph/pkg/tfd"
+)
+
+//-----------------------------------------------------------------------------
+// Globals
+//-----------------------------------------------------------------------------
+
+// TerraformVersion is reported to out-of-process providers
+const TerraformVersion = "0.14.2"
+
+// protocolVersion is the only plugin protocol version spoken
+const protocolVersion = 5
+
+//-----------------------------------------------------------------------------
+// Types
+//-----------------------------------------------------------------------------
+
+// Plugin is a provider running in its own process. It speaks the tfplugin5
+// gRPC protocol through the message descriptors registered by the plugin SDK,
+// so no generated stubs are needed on the client side.
+type Plugin struct {
+	client *goplugin.Client
+	conn   *grpc.ClientConn
+	mu     sync.Mutex
+	schema *ProviderSchema
+}
+
+// PlannedChange is the provider answer to a PlanResourceChange call
+type PlannedChange struct {
+	PlannedState    cty.Value
+	PlannedPrivate  []byte
+	RequiresReplace []string
+}
+
+// grpcPlugin hands the raw client connection over to the Plugin
+type grpcPlugin struct {
+	goplugin.NetRPCUnsupportedPlugin
+}
+
+// codec marshals the dynamic messages without the legacy proto API
+type codec struct{}
+
+//-----------------------------------------------------------------------------
+// Constructor
+//-----------------------------------------------------------------------------
+
+// Launch starts an installed provider binary and connects to it over the
+// go-plugin gRPC protocol version 5. The returned provider must be closed to
+// stop the plugin process.
+func Launch(path string) (*Plugin, error) {
+
+	client := goplugin.NewClient(&goplugin.ClientConfig{
+		Cmd:             exec.Command(path),
+		HandshakeConfig: tfplugin.Handshake,
+		VersionedPlugins: map[int]goplugin.PluginSet{
+			protocolVersion: {tfplugin.ProviderPluginName: &grpcPlugin{}},
+		},
+		Managed:          true,
+		AllowedProtocols: []goplugin.Protocol{goplugin.ProtocolGRPC},
+		AutoMTLS:         true,
+		Logger: hclog.New(&hclog.LoggerOptions{
+			Name:   "plugin",
+			Output: ioutil.Discard,
+		}),
+	})
+
+	// Start the process and negotiate the protocol
+	rpcClient, err := client.Client()
+	if err != nil {
+		client.Kill()
+		return nil, fmt.Errorf("error launching provider %s: %s", path, err)
+	}
+
+	raw, err := rpcClient.Dispense(tfplugin.ProviderPluginName)
+	if err != nil {
+		client.Kill()
+		return nil, fmt.Errorf("error dispensing provider %s: %s", path, err)
+	}
+
+	return &Plugin{client: client, conn: raw.(*grpc.ClientConn)}, nil
+}
+
+//-----------------------------------------------------------------------------
+// Methods
+//-----------------------------------------------------------------------------
+
+// GetSchema returns the provider and resource schemas. The result is cached.
+func (p *Plugin) GetSchema(ctx context.Context) (*ProviderSchema, tfd.Diagnostics) {
+
+	var diags tfd.Diagnostics
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.schema != nil {
+		return p.schema, diags
+	}
+
+	resp, err := p.call(ctx, "GetSchema", newMessage("GetProviderSchema.Request"))
+	if err != nil {
+		return nil, diags.Append(err)
+	}
+
+	diags = diags.Append(diagnostics(resp))
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	s := &ProviderSchema{ResourceTypes: map[string]*Schema{}}
+	if s.Provider, err = schemaFromProto(field(resp, "provider").Message()); err != nil {
+		return nil, diags.Append(err)
+	}
+
+	field(resp, "resource_schemas").Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+		var rs *Schema
+		if rs, err = schemaFromProto(v.Message()); err == nil {
+			s.ResourceTypes[k.String()] = rs
+		}
+		return err == nil
+	})
+	if err != nil {
+		return nil, diags.Append(err)
+	}
+
+	p.schema = s
+	return s, diags
+}
+
+// ResourceSchema returns the cached schema of a resource type
+func (p *Plugin) ResourceSchema(resourceType string) (*Schema, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.schema == nil {
+		return nil, false
+	}
+	s, ok := p.schema.ResourceTypes[resourceType]
+	return s, ok
+}
+
+// PrepareProviderConfig validates the provider config and returns it with
+// the provider defaults applied.
+func (p *Plugin) PrepareProviderConfig(ctx context.Context, config cty.Value) (cty.Value, tfd.Diagnostics) {
+
+	s, diags := p.GetSchema(ctx)
+	if diags.HasErrors() {
+		return cty.NilVal, diags
+	}
+	ty := s.Provider.Block.ImpliedType()
+
+	req := newMessage("PrepareProviderConfig.Request")
+	if err := setValue(req, "config", config, ty); err != nil {
+		return cty.NilVal, diags.Append(err)
+	}
+
+	resp, err := p.call(ctx, "PrepareProviderConfig", req)
+	if err != nil {
+		return cty.NilVal, diags.Append(err)
+	}
+
+	diags = diags.Append(diagnostics(resp))
+	if diags.HasErrors() {
+		return cty.NilVal, diags
+	}
+
+	prepared, err := value(resp, "prepared_config", ty)
+	if err != nil {
+		return cty.NilVal, diags.Append(err)
+	}
+
+	// Providers may leave the config untouched
+	if prepared.IsNull() {
+		prepared = config
+	}
+
+	return prepared, diags
+}
+
+// Configure configures the provider
+func (p *Plugin) Configure(ctx context.Context, config cty.Value) tfd.Diagnostics {
+
+	s, diags := p.GetSchema(ctx)
+	if diags.HasErrors() {
+		return diags
+	}
+
+	req := newMessage("Configure.Request")
+	req.Set(fieldDesc(req, "terraform_version"), protoreflect.ValueOfString(TerraformVersion))
+	if err := setValue(req, "config", config, s.Provider.Block.ImpliedType()); err != nil {
+		return diags.Append(err)
+	}
+
+	resp, err := p.call(ctx, "Configure", req)
+	if err != nil {
+		return diags.Append(err)
+	}
+
+	return diags.Append(diagnostics(resp))
+}
+
+// ValidateResourceTypeConfig validates a resource config
+func (p *Plugin) ValidateResourceTypeConfig(ctx context.Context, resourceType string, config cty.Value) tfd.Diagnostics {
+
+	rs, diags := p.resourceSchema(ctx, resourceType)
+	if diags.HasErrors() {
+		return diags
+	}
+
+	req := newMessage("ValidateResourceTypeConfig.Request")
+	req.Set(fieldDesc(req, "type_name"), protoreflect.ValueOfString(resourceType))
+	if err := setValue(req, "config", config, rs.Block.ImpliedType()); err != nil {
+		return diags.Append(err)
+	}
+
+	resp, err := p.call(ctx, "ValidateResourceTypeConfig", req)
+	if err != nil {
+		return diags.Append(err)
+	}
+
+	return diags.Append(diagnostics(resp))
+}
+
+// UpgradeResourceState upgrades a flatmap state written by an older version
+// of the resource schema.
+func (p *Plugin) UpgradeResourceState(ctx context.Context, resourceType string, version int, flatmap map[string]string) (cty.Value, tfd.Diagnostics) {
+
+	rs, diags := p.resourceSchema(ctx, resourceType)
+	if diags.HasErrors() {
+		return cty.NilVal, diags
+	}
+
+	req := newMessage("UpgradeResourceState.Request")
+	req.Set(fieldDesc(req, "type_name"), protoreflect.ValueOfString(resourceType))
+	req.Set(fieldDesc(req, "version"), protoreflect.ValueOfInt64(int64(version)))
+
+	raw := req.Mutable(fieldDesc(req, "raw_state")).Message()
+	m := raw.Mutable(fieldDesc(raw, "flatmap")).Map()
+	for k, v := range flatmap {
+		m.Set(protoreflect.ValueOfString(k).MapKey(), protoreflect.ValueOfString(v))
+	}
+
+	resp, err := p.call(ctx, "UpgradeResourceState", req)
+	if err != nil {
+		return cty.NilVal, diags.Append(err)
+	}
+
+	diags = diags.Append(diagnostics(resp))
+	if diags.HasErrors() {
+		return cty.NilVal, diags
+	}
+
+	state, err := value(resp, "upgraded_state", rs.Block.ImpliedType())
+	if err != nil {
+		return cty.NilVal, diags.Append(err)
+	}
+
+	return state, diags
+}
+
+// ReadResource refreshes a resource state
+func (p *Plugin) ReadResource(ctx context.Context, resourceType string, prior cty.Value, private []byte) (cty.Value, []byte, tfd.Diagnostics) {
+
+	rs, diags := p.resourceSchema(ctx, resourceType)
+	if diags.HasErrors() {
+		return cty.NilVal, nil, diags
+	}
+	ty := rs.Block.ImpliedType()
+
+	req := newMessage("ReadResource.Request")
+	req.Set(fieldDesc(req, "type_name"), protoreflect.ValueOfString(resourceType))
+	req.Set(fieldDesc(req, "private"), protoreflect.ValueOfBytes(private))
+	if err := setValue(req, "current_state", prior, ty); err != nil {
+		return cty.NilVal, nil, diags.Append(err)
+	}
+
+	resp, err := p.call(ctx, "ReadResource", req)
+	if err != nil {
+		return cty.NilVal, nil, diags.Append(err)
+	}
+
+	diags = diags.Append(diagnostics(resp))
+	if diags.HasErrors() {
+		return cty.NilVal, nil, diags
+	}
+
+	state, err := value(resp, "new_state", ty)
+	if err != nil {
+		return cty.NilVal, nil, diags.Append(err)
+	}
+
+	return state, field(resp, "private").Bytes(), diags
+}
+
+// PlanResourceChange plans the change from prior to the proposed state
+func (p *Plugin) PlanResourceChange(ctx context.Context, resourceType string, prior, proposed, config cty.Value, private []byte) (*PlannedChange, tfd.Diagnostics) {
+
+	rs, diags := p.resourceSchema(ctx, resourceType)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	ty := rs.Block.ImpliedType()
+
+	req := newMessage("PlanResourceChange.Request")
+	req.Set(fieldDesc(req, "type_name"), protoreflect.ValueOfString(resourceType))
+	req.Set(fieldDesc(req, "prior_private"), protoreflect.ValueOfBytes(private))
+	for name, v := range map[string]cty.Value{"prior_state": prior, "proposed_new_state": proposed, "config": config} {
+		if err := setValue(req, name, v, ty); err != nil {
+			return nil, diags.Append(err)
+		}
+	}
+
+	resp, err := p.call(ctx, "PlanResourceChange", req)
+	if err != nil {
+		return nil, diags.Append(err)
+	}
+
+	diags = diags.Append(diagnostics(resp))
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	planned, err := value(resp, "planned_state", ty)
+	if err != nil {
+		return nil, diags.Append(err)
+	}
+
+	change := &PlannedChange{
+		PlannedState:   planned,
+		PlannedPrivate: field(resp, "planned_private").Bytes(),
+	}
+
+	paths := field(resp, "requires_replace").List()
+	for i := 0; i < paths.Len(); i++ {
+		change.RequiresReplace = append(change.RequiresReplace, pathString(paths.Get(i).Message()))
+	}
+
+	return change, diags
+}
+
+// ApplyResourceChange applies a planned change. A null planned state
+// destroys the resource.
+func (p *Plugin) ApplyResourceChange(ctx context.Context, resourceType string, prior, planned, config cty.Value, private []byte) (cty.Value, []byte, tfd.Diagnostics) {
+
+	rs, diags := p.resourceSchema(ctx, resourceType)
+	if diags.HasErrors() {
+		return cty.NilVal, nil, diags
+	}
+	ty := rs.Block.ImpliedType()
+
+	req := newMessage("ApplyResourceChange.Request")
+	req.Set(fieldDesc(req, "type_name"), protoreflect.ValueOfString(resourceType))
+	req.Set(fieldDesc(req, "planned_private"), protoreflect.ValueOfBytes(private))
+	for name, v := range map[string]cty.Value{"prior_state": prior, "planned_state": planned, "config": config} {
+		if err := setValue(req, name, v, ty); err != nil {
+			return cty.NilVal, nil, diags.Append(err)
+		}
+	}
+
+	resp, err := p.call(ctx, "ApplyResourceChange", req)
+	if err != nil {
+		return cty.NilVal, nil, diags.Append(err)
+	}
+
+	// The new state is returned even on errors, the resource may be half created
+	diags = diags.Append(diagnostics(resp))
+	state, err := value(resp, "new_state", ty)
+	if err != nil {
+		return cty.NilVal, nil, diags.Append(err)
+	}
+
+	return state, field(resp, "private").Bytes(), diags
+}
+
+// ImportResourceState returns the state of an existing resource by its ID.
+// Only the imported resource of the requested type is returned.
+func (p *Plugin) ImportResourceState(ctx context.Context, resourceType, id string) (cty.Value, []byte, tfd.Diagnostics) {
+
+	rs, diags := p.resourceSchema(ctx, resourceType)
+	if diags.HasErrors() {
+		return cty.NilVal, nil, diags
+	}
+	ty := rs.Block.ImpliedType()
+
+	req := newMessage("ImportResourceState.Request")
+	req.Set(fieldDesc(req, "type_name"), protoreflect.ValueOfString(resourceType))
+	req.Set(fieldDesc(req, "id"), protoreflect.ValueOfString(id))
+
+	resp, err := p.call(ctx, "ImportResourceState", req)
+	if err != nil {
+		return cty.NilVal, nil, diags.Append(err)
+	}
+
+	diags = diags.Append(diagnostics(resp))
+	if diags.HasErrors() {
+		return cty.NilVal, nil, diags
+	}
+
+	list := field(resp, "imported_resources").List()
+	for i := 0; i < list.Len(); i++ {
+		ir := list.Get(i).Message()
+		if field(ir, "type_name").String() != resourceType {
+			continue
+		}
+		state, err := value(ir, "state", ty)
+		if err != nil {
+			return cty.NilVal, nil, diags.Append(err)
+		}
+		return state, field(ir, "private").Bytes(), diags
+	}
+
+	return cty.NilVal, nil, diags.Append(fmt.Errorf("%s %s was not imported", resourceType, id))
+}
+
+// Close stops the plugin process
+func (p *Plugin) Close() error {
+	p.client.Kill()
+	return nil
+}
+
+func (p *Plugin) resourceSchema(ctx context.Context, resourceType string) (*Schema, tfd.Diagnostics) {
+
+	s, diags := p.GetSchema(ctx)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	rs, ok := s.ResourceTypes[resourceType]
+	if !ok {
+		return nil, diags.Append(fmt.Errorf("unknown resource type %s", resourceType))
+	}
+
+	return rs, diags
+}
+
+func (p *Plugin) call(ctx context.Context, method string, req *dynamicpb.Message) (*dynamicpb.Message, error) {
+
+	name := string(req.Descriptor().FullName().Parent()) + ".Response"
+	resp := newMessage(name[len("tfplugin5."):])
+
+	if err := p.conn.Invoke(ctx, "/tfplugin5.Provider/"+method, req, resp, grpc.ForceCodec(codec{})); err != nil {
+		return nil, fmt.Errorf("%s: %s", method, err)
+	}
+
+	return resp, nil
+}
+
+// GRPCServer is not supported, terramorph only consumes providers
+func (*grpcPlugin) GRPCServer(*goplugin.GRPCBroker, *grpc.Server) error {
+	return fmt.Errorf("terramorph does not serve providers")
+}
+
+// GRPCClient returns the connection to the plugin
+func (*grpcPlugin) GRPCClient(ctx context.Context, broker *goplugin.GRPCBroker, c *grpc.ClientConn) (interface{}, error) {
+	return c, nil
+}
+
+// Marshal ...
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	return proto.Marshal(v.(proto.Message))
+}
+
+// Unmarshal ...
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	return proto.Unmarshal(data, v.(proto.Message))
+}
+
+// Name ...
+func (codec) Name() string {
+	return "proto"
+}
+
+//-----------------------------------------------------------------------------
+// Helpers
+//-----------------------------------------------------------------------------
+
+// newMessage returns an empty tfplugin5 message such as Configure.Request
+func newMessage(name string) *dynamicpb.Message {
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName("tfplugin5." + name))
+	if err != nil {
+		panic(fmt.Sprintf("tfplugin5 protocol is not registered: %s", err))
+	}
+	return dynamicpb.NewMessage(d.(protoreflect.MessageDescriptor))
+}
+
+func fieldDesc(m protoreflect.Message, name string) protoreflect.FieldDescriptor {
+	fd := m.Descriptor().Fields().ByName(protoreflect.Name(name))
+	if fd == nil {
+		panic(fmt.Sprintf("%s has no field %s", m.Descriptor().FullName(), name))
+	}
+	return fd
+}
+
+func field(m protoreflect.Message, name string) protoreflect.Value {
+	return m.Get(fieldDesc(m, name))
+}
+
+// setValue stores a value as a msgpack DynamicValue
+func setValue(m protoreflect.Message, name string, v cty.Value, ty cty.Type) error {
+
+	if v == cty.NilVal {
+		v = cty.NullVal(ty)
+	}
+
+	b, err := msgpack.Marshal(v, ty)
+	if err != nil {
+		return fmt.Errorf("error encoding %s: %s", name, err)
+	}
+
+	dv := m.Mutable(fieldDesc(m, name)).Message()
+	dv.Set(fieldDesc(dv, "msgpack"), protoreflect.ValueOfBytes(b))
+
+	return nil
+}
+
+// value decodes a DynamicValue in either of its encodings
+func value(m protoreflect.Message, name string, ty cty.Type) (cty.Value, error) {
+
+	if !m.Has(fieldDesc(m, name)) {
+		return cty.NullVal(ty), nil
+	}
+
+	dv := field(m, name).Message()
+	if b := field(dv, "msgpack").Bytes(); len(b) > 0 {
+		return msgpack.Unmarshal(b, ty)
+	}
+	if b := field(dv, "json").Bytes(); len(b) > 0 {
+		return ctyjson.Unmarshal(b, ty)
+	}
+
+	return cty.NullVal(ty), nil
+}
+
+// diagnostics converts the diagnostics of a response
+func diagnostics(m protoreflect.Message) tfd.Diagnostics {
+
+	var diags tfd.Diagnostics
+
+	list := field(m, "diagnostics").List()
+	for i := 0; i < list.Len(); i++ {
+
+		d := list.Get(i).Message()
+
+		severity := tfd.Warning
+		if field(d, "severity").Enum() == 1 {
+			severity = tfd.Error
+		}
+
+		detail := field(d, "detail").String()
+		if d.Has(fieldDesc(d, "attribute")) {
+			detail = fmt.Sprintf("%s (attribute %s)", detail, pathString(field(d, "attribute").Message()))
+		}
+
+		diags = diags.Append(tfd.Sourceless(severity, field(d, "summary").String(), detail))
+	}
+
+	return diags
+}
+
+// pathString renders an AttributePath as assume_role.0.role_arn
+func pathString(m protoreflect.Message) string {
+
+	s := ""
+
+	steps := field(m, "steps").List()
+	for i := 0; i < steps.Len(); i++ {
+
+		step := steps.Get(i).Message()
+		if s != "" {
+			s += "."
+		}
+
+		switch {
+		case step.Has(fieldDesc(step, "attribute_name")):
+			s += field(step, "attribute_name").String()
+		case step.Has(fieldDesc(step, "element_key_string")):
+			s += field(step, "element_key_string").String()
+		case step.Has(fieldDesc(step, "element_key_int")):
+			s += strconv.FormatInt(field(step, "element_key_int").Int(), 10)
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,115 @@
+package provider
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"sort"
+	"strings"
+	"sync"
+
+	// terraform
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+//-----------------------------------------------------------------------------
+// Types
+//-----------------------------------------------------------------------------
+
+// Factory returns a new unconfigured provider
+type Factory func() *schema.Provider
+
+// Registry holds the provider factories keyed by provider name
+type Registry struct {
+	mu        sync.Mutex
+	factories map[string]Factory
+	schemas   map[string]*schema.Provider
+}
+
+//-----------------------------------------------------------------------------
+// Constructor
+//-----------------------------------------------------------------------------
+
+// NewRegistry ...
+func NewRegistry() *Registry {
+	return &Registry{
+		factories: map[string]Factory{},
+		schemas:   map[string]*schema.Provider{},
+	}
+}
+
+//-----------------------------------------------------------------------------
+// Methods
+//-----------------------------------------------------------------------------
+
+// Register adds a provider factory under name
+func (r *Registry) Register(name string, f Factory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.factories[name] = f
+	delete(r.schemas, name)
+}
+
+// Factory ...
+func (r *Registry) Factory(name string) (Factory, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, ok := r.factories[name]
+	return f, ok
+}
+
+// Names returns the registered provider names, sorted
+func (r *Registry) Names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := []string{}
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Route returns the provider managing a resource type. The longest provider
+// name that prefixes the type wins: random_id is routed to random.
+func (r *Registry) Route(resourceType string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	match := ""
+	for name := range r.factories {
+		if (resourceType == name || strings.HasPrefix(resourceType, name+"_")) && len(name) > len(match) {
+			match = name
+		}
+	}
+	return match, match != ""
+}
+
+// Schema returns an unconfigured instance of a provider which is only meant
+// for schema lookups. Instances are cached.
+func (r *Registry) Schema(name string) (*schema.Provider, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p, ok := r.schemas[name]; ok {
+		return p, true
+	}
+	f, ok := r.factories[name]
+	if !ok {
+		return nil, false
+	}
+	r.schemas[name] = f()
+	return r.schemas[name], true
+}
+
+// Resource returns the schema of a resource type
+func (r *Registry) Resource(resourceType string) (*schema.Resource, bool) {
+	name, ok := r.Route(resourceType)
+	if !ok {
+		return nil, false
+	}
+	p, _ := r.Schema(name)
+	rp, ok := p.ResourcesMap[resourceType]
+	return rp, ok
+}
@@ -0,0 +1,129 @@
+package provider
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestRegistryRoute(t *testing.T) {
+	r := NewRegistry()
+	for _, name := range []string{"aws", "random", "google", "google_beta"} {
+		r.Register(name, func() *schema.Provider { return &schema.Provider{} })
+	}
+
+	if expected := []string{"aws", "google", "google_beta", "random"}; !reflect.DeepEqual(r.Names(), expected) {
+		t.Fatalf("bad: %#v", r.Names())
+	}
+
+	cases := map[string]string{
+		"aws_iam_role":            "aws",
+		"random_id":               "random",
+		"google_compute_instance": "google",
+		"google_beta_thing":       "google_beta",
+		"awsome_thing":            "",
+		"tls_private_key":         "",
+	}
+
+	for resourceType, expected := range cases {
+		actual, ok := r.Route(resourceType)
+		if actual != expected || ok != (expected != "") {
+			t.Fatalf("%s: bad: %q", resourceType, actual)
+		}
+	}
+}
+
+func TestRegistryResource(t *testing.T) {
+	calls := 0
+	r := NewRegistry()
+	r.Register("random", func() *schema.Provider {
+		calls++
+		return &schema.Provider{
+			ResourcesMap: map[string]*schema.Resource{
+				"random_id": {},
+			},
+		}
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, ok := r.Resource("random_id"); !ok {
+			t.Fatal("expected resource")
+		}
+	}
+	if _, ok := r.Resource("random_pet"); ok {
+		t.Fatal("unexpected resource")
+	}
+
+	// Schema instances are cached
+	if calls != 1 {
+		t.Fatalf("bad: %d", calls)
+	}
+}
+
+func TestRegistryDescribe(t *testing.T) {
+	r := NewRegistry()
+	r.Register("test", func() *schema.Provider {
+		return &schema.Provider{
+			ResourcesMap: map[string]*schema.Resource{
+				"test_bucket": {
+					SchemaVersion: 2,
+					Schema: map[string]*schema.Schema{
+						"bucket": {Type: schema.TypeString, Required: true, ForceNew: true, Description: "Bucket name"},
+						"tags":   {Type: schema.TypeMap, Optional: true, Elem: &schema.Schema{Type: schema.TypeString}},
+						"token":  {Type: schema.TypeString, Computed: true, Sensitive: true},
+						"rule": {
+							Type:     schema.TypeList,
+							Optional: true,
+							MaxItems: 1,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"days": {Type: schema.TypeInt, Optional: true},
+								},
+							},
+						},
+					},
+				},
+			},
+			DataSourcesMap: map[string]*schema.Resource{
+				"test_bucket": {Schema: map[string]*schema.Schema{}},
+			},
+		}
+	})
+
+	if expected := []string{"data.test_bucket", "test_bucket"}; !reflect.DeepEqual(r.Types(), expected) {
+		t.Fatalf("bad: %#v", r.Types())
+	}
+
+	d, err := r.Describe("test_bucket")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if d.Kind != KindResource || d.Provider != "test" || d.Version != 2 {
+		t.Fatalf("bad: %#v", d)
+	}
+
+	expected := &AttributeDescription{Type: "string", Description: "Bucket name", Required: true, ForceNew: true}
+	if !reflect.DeepEqual(d.Block.Attributes["bucket"], expected) {
+		t.Fatalf("bad: %#v", d.Block.Attributes["bucket"])
+	}
+	if d.Block.Attributes["tags"].Type != "map(string)" || !d.Block.Attributes["token"].Sensitive {
+		t.Fatalf("bad: %#v", d.Block.Attributes)
+	}
+
+	rule := d.Block.BlockTypes["rule"]
+	if rule == nil || rule.Nesting != "list" || rule.MaxItems != 1 || rule.Block.Attributes["days"].Type != "number" {
+		t.Fatalf("bad: %#v", rule)
+	}
+
+	if d, err := r.Describe("data.test_bucket"); err != nil || d.Kind != KindDataSource {
+		t.Fatalf("bad: %#v %v", d, err)
+	}
+
+	for _, name := range []string{"test_nope", "data.test_nope", "nope_bucket"} {
+		if _, err := r.Describe(name); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+package provider
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"fmt"
+
+	// community
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	// terraform
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/go-cty/cty/convert"
+)
+
+//-----------------------------------------------------------------------------
+// Globals
+//-----------------------------------------------------------------------------
+
+// UnknownValue is the plugin SDK placeholder for values only known after
+// apply, such as the ID of a resource that is not created yet.
+const UnknownValue = "74D93920-ED26-11E3-AC10-0800200C9A66"
+
+//-----------------------------------------------------------------------------
+// Types
+//-----------------------------------------------------------------------------
+
+// NestingMode is the nesting of a nested block
+type NestingMode int
+
+// Nesting modes, numbered as in the plugin protocol
+const (
+	NestingInvalid NestingMode = iota
+	NestingSingle
+	NestingList
+	NestingSet
+	NestingMap
+	NestingGroup
+)
+
+// ProviderSchema holds the schemas reported by an out-of-process provider
+type ProviderSchema struct {
+	Provider      *Schema
+	ResourceTypes map[string]*Schema
+}
+
+// Schema is a versioned configuration block
+type Schema struct {
+	Version int64
+	Block   *Block
+}
+
+// Block is a configuration block
+type Block struct {
+	Attributes map[string]*Attribute
+	BlockTypes map[string]*NestedBlock
+}
+
+// Attribute is an attribute of a configuration block
+type Attribute struct {
+	Type      cty.Type
+	Required  bool
+	Optional  bool
+	Computed  bool
+	Sensitive bool
+}
+
+// NestedBlock is a block nested in a configuration block
+type NestedBlock struct {
+	Block
+	Nesting  NestingMode
+	MinItems int
+	MaxItems int
+}
+
+//-----------------------------------------------------------------------------
+// Methods
+//-----------------------------------------------------------------------------
+
+// ImpliedType returns the object type of the values conforming to the block
+func (b *Block) ImpliedType() cty.Type {
+
+	atys := map[string]cty.Type{}
+
+	for name, attr := range b.Attributes {
+		atys[name] = attr.Type
+	}
+
+	for name, nb := range b.BlockTypes {
+		ety := nb.Block.ImpliedType()
+		switch nb.Nesting {
+		case NestingSingle, NestingGroup:
+			atys[name] = ety
+		case NestingList:
+			atys[name] = cty.List(ety)
+		case NestingSet:
+			atys[name] = cty.Set(ety)
+		case NestingMap:
+			atys[name] = cty.Map(ety)
+		}
+	}
+
+	return cty.Object(atys)
+}
+
+// CoerceValue converts a config map, as written in the manifest, into a
+// value of the block implied type. Nested blocks may be given either as a
+// single map or as a list of maps, the way the plugin SDK accepts them.
+func (b *Block) CoerceValue(config map[string]interface{}) (cty.Value, error) {
+	return b.coerce(config, nil)
+}
+
+func (b *Block) coerce(config map[string]interface{}, path cty.Path) (cty.Value, error) {
+
+	vals := map[string]cty.Value{}
+
+	for key := range config {
+		if b.Attributes[key] == nil && b.BlockTypes[key] == nil {
+			return cty.NilVal, path.NewErrorf("unsupported argument %q", key)
+		}
+	}
+
+	for name, attr := range b.Attributes {
+
+		v, ok := config[name]
+		if !ok || v == nil {
+			if attr.Required {
+				return cty.NilVal, path.NewErrorf("missing required argument %q", name)
+			}
+			vals[name] = cty.NullVal(attr.Type)
+			continue
+		}
+
+		val, err := convert.Convert(configValue(v), attr.Type)
+		if err != nil {
+			return cty.NilVal, path.GetAttr(name).NewError(err)
+		}
+		vals[name] = val
+	}
+
+	for name, nb := range b.BlockTypes {
+		val, err := nb.coerce(config[name], path.GetAttr(name))
+		if err != nil {
+			return cty.NilVal, err
+		}
+		vals[name] = val
+	}
+
+	return cty.ObjectVal(vals), nil
+}
+
+func (nb *NestedBlock) coerce(v interface{}, path cty.Path) (cty.Value, error) {
+
+	ety := nb.Block.ImpliedType()
+
+	// Maps of blocks are keyed by label
+	if nb.Nesting == NestingMap {
+		m, _ := v.(map[string]interface{})
+		if len(m) == 0 {
+			return cty.MapValEmpty(ety), nil
+		}
+		elems := map[string]cty.Value{}
+		for k, e := range m {
+			em, ok := e.(map[string]interface{})
+			if !ok {
+				return cty.NilVal, path.NewErrorf("block %q must be a map", k)
+			}
+			val, err := nb.Block.coerce(em, path.Index(cty.StringVal(k)))
+			if err != nil {
+				return cty.NilVal, err
+			}
+			elems[k] = val
+		}
+		return cty.MapVal(elems), nil
+	}
+
+	// Everything else is a list of blocks
+	var items []interface{}
+	switch v := v.(type) {
+	case nil:
+	case []interface{}:
+		items = v
+	case []map[string]interface{}:
+		for _, m := range v {
+			items = append(items, m)
+		}
+	case map[string]interface{}:
+		items = []interface{}{v}
+	default:
+		return cty.NilVal, path.NewErrorf("unsupported block value %T", v)
+	}
+
+	if len(items) < nb.MinItems {
+		return cty.NilVal, path.NewErrorf("at least %d blocks are required", nb.MinItems)
+	}
+	if nb.MaxItems > 0 && len(items) > nb.MaxItems {
+		return cty.NilVal, path.NewErrorf("no more than %d blocks are allowed", nb.MaxItems)
+	}
+
+	elems := []cty.Value{}
+	for i, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return cty.NilVal, path.Index(cty.NumberIntVal(int64(i))).NewErrorf("block must be a map")
+		}
+		val, err := nb.Block.coerce(m, path.Index(cty.NumberIntVal(int64(i))))
+		if err != nil {
+			return cty.NilVal, err
+		}
+		elems = append(elems, val)
+	}
+
+	switch nb.Nesting {
+	case NestingSingle:
+		if len(elems) == 0 {
+			return cty.NullVal(ety), nil
+		}
+		if len(elems) > 1 {
+			return cty.NilVal, path.NewErrorf("only one block is allowed")
+		}
+		return elems[0], nil
+	case NestingGroup:
+		if len(elems) == 0 {
+			return nb.Block.coerce(map[string]interface{}{}, path)
+		}
+		return elems[0], nil
+	case NestingSet:
+		if len(elems) == 0 {
+			return cty.SetValEmpty(ety), nil
+		}
+		return cty.SetVal(elems), nil
+	default:
+		if len(elems) == 0 {
+			return cty.ListValEmpty(ety), nil
+		}
+		return cty.ListVal(elems), nil
+	}
+}
+
+// ProposedNewObject merges the prior state and the config into the object
+// proposed to the provider for planning: computed attributes not set in the
+// config keep their prior value.
+func (b *Block) ProposedNewObject(prior, config cty.Value) cty.Value {
+
+	if config.IsNull() || !config.IsKnown() {
+		return prior
+	}
+
+	vals := map[string]cty.Value{}
+
+	for name, attr := range b.Attributes {
+
+		configV := config.GetAttr(name)
+		priorV := cty.NullVal(attr.Type)
+		if !prior.IsNull() {
+			priorV = prior.GetAttr(name)
+		}
+
+		switch {
+		case attr.Computed && attr.Optional && configV.IsNull():
+			vals[name] = priorV
+		case attr.Computed && !attr.Optional:
+			vals[name] = priorV
+		default:
+			vals[name] = configV
+		}
+	}
+
+	for name, nb := range b.BlockTypes {
+
+		configV := config.GetAttr(name)
+		priorV := cty.NullVal(configV.Type())
+		if !prior.IsNull() {
+			priorV = prior.GetAttr(name)
+		}
+
+		switch nb.Nesting {
+		case NestingSingle, NestingGroup:
+			vals[name] = nb.Block.ProposedNewObject(priorV, configV)
+		case NestingList:
+			vals[name] = nb.proposedList(priorV, configV)
+		default:
+			// Set and map elements are not correlated with the prior ones
+			vals[name] = configV
+		}
+	}
+
+	return cty.ObjectVal(vals)
+}
+
+// proposedList correlates list blocks by index
+func (nb *NestedBlock) proposedList(prior, config cty.Value) cty.Value {
+
+	if config.IsNull() || !config.IsKnown() || config.LengthInt() == 0 {
+		return config
+	}
+
+	priors := []cty.Value{}
+	if !prior.IsNull() && prior.IsKnown() {
+		priors = prior.AsValueSlice()
+	}
+
+	elems := []cty.Value{}
+	for i, c := range config.AsValueSlice() {
+		p := cty.NullVal(c.Type())
+		if i < len(priors) {
+			p = priors[i]
+		}
+		elems = append(elems, nb.Block.ProposedNewObject(p, c))
+	}
+
+	return cty.ListVal(elems)
+}
+
+//-----------------------------------------------------------------------------
+// Helpers
+//-----------------------------------------------------------------------------
+
+// schemaFromProto converts a tfplugin5 Schema message
+func schemaFromProto(m protoreflect.Message) (*Schema, error) {
+
+	if !m.IsValid() {
+		return &Schema{Block: &Block{}}, nil
+	}
+
+	block, err := blockFromProto(field(m, "block").Message())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Schema{Version: field(m, "version").Int(), Block: block}, nil
+}
+
+func blockFromProto(m protoreflect.Message) (*Block, error) {
+
+	b := &Block{
+		Attributes: map[string]*Attribute{},
+		BlockTypes: map[string]*NestedBlock{},
+	}
+
+	if !m.IsValid() {
+		return b, nil
+	}
+
+	attrs := field(m, "attributes").List()
+	for i := 0; i < attrs.Len(); i++ {
+
+		a := attrs.Get(i).Message()
+		name := field(a, "name").String()
+
+		attr := &Attribute{
+			Required:  field(a, "required").Bool(),
+			Optional:  field(a, "optional").Bool(),
+			Computed:  field(a, "computed").Bool(),
+			Sensitive: field(a, "sensitive").Bool(),
+		}
+		if err := attr.Type.UnmarshalJSON(field(a, "type").Bytes()); err != nil {
+			return nil, fmt.Errorf("invalid type of attribute %s: %s", name, err)
+		}
+
+		b.Attributes[name] = attr
+	}
+
+	blocks := field(m, "block_types").List()
+	for i := 0; i < blocks.Len(); i++ {
+
+		n := blocks.Get(i).Message()
+
+		nested, err := blockFromProto(field(n, "block").Message())
+		if err != nil {
+			return nil, err
+		}
+
+		b.BlockTypes[field(n, "type_name").String()] = &NestedBlock{
+			Block:    *nested,
+			Nesting:  NestingMode(field(n, "nesting").Enum()),
+			MinItems: int(field(n, "min_items").Int()),
+			MaxItems: int(field(n, "max_items").Int()),
+		}
+	}
+
+	return b, nil
+}
+
+// configValue converts a manifest value into a cty value
+func configValue(v interface{}) cty.Value {
+
+	switch v := v.(type) {
+	case nil:
+		return cty.NullVal(cty.DynamicPseudoType)
+	case cty.Value:
+		return v
+	case string:
+		if v == UnknownValue {
+			return cty.DynamicVal
+		}
+		return cty.StringVal(v)
+	case bool:
+		return cty.BoolVal(v)
+	case int:
+		return cty.NumberIntVal(int64(v))
+	case int64:
+		return cty.NumberIntVal(v)
+	case float64:
+		return cty.NumberFloatVal(v)
+	case []string:
+		elems := []cty.Value{}
+		for _, e := range v {
+			elems = append(elems, cty.StringVal(e))
+		}
+		return cty.TupleVal(elems)
+	case []interface{}:
+		elems := []cty.Value{}
+		for _, e := range v {
+			elems = append(elems, configValue(e))
+		}
+		return cty.TupleVal(elems)
+	case map[string]interface{}:
+		attrs := map[string]cty.Value{}
+		for k, e := range v {
+			attrs[k] = configValue(e)
+		}
+		return cty.ObjectVal(attrs)
+	default:
+		return cty.StringVal(fmt.Sprintf("%v", v))
+	}
+}
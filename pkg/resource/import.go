@@ -0,0 +1,152 @@
+package resource
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"context"
+	"fmt"
+
+	// terraform
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+
+	// terramorph
+	"github.com/h0tbird/terramorph/pkg/event"
+	"github.com/h0tbird/terramorph/pkg/provider"
+	"github.com/h0tbird/terramorph/pkg/tfd"
+)
+
+//-----------------------------------------------------------------------------
+// Methods
+//-----------------------------------------------------------------------------
+
+// Import adopts an existing resource by its ID: the provider imports and
+// refreshes it and the result is written as the state of the resource. A
+// resource that already has a state is not imported.
+func (h *Handler) Import(ctx context.Context, p *schema.Provider, s State, id string) error {
+
+	rp, ok := p.ResourcesMap[h.ResourceType]
+	if !ok {
+		return fmt.Errorf("unknown resource type %s", h.ResourceType)
+	}
+
+	if err := h.checkNoState(s); err != nil {
+		return err
+	}
+
+	h.emit(event.Event{Kind: event.Importing})
+
+	var imported *terraform.InstanceState
+	err := h.retry(ctx, "import", func() error {
+		states, err := p.ImportState(ctx, &terraform.InstanceInfo{Type: h.ResourceType}, id)
+		if err != nil {
+			return err
+		}
+		for _, is := range states {
+			if is.Ephemeral.Type == "" || is.Ephemeral.Type == h.ResourceType {
+				imported = is
+				return nil
+			}
+		}
+		return fmt.Errorf("%s %s was not imported", h.ResourceType, id)
+	})
+	if err != nil {
+		return fmt.Errorf("error importing resource: %s", err)
+	}
+
+	// Refresh the state
+	var state *terraform.InstanceState
+	err = h.deadline(ctx, schema.TimeoutRead, h.timeout(schema.TimeoutRead, rp.Timeouts), func(ctx context.Context) error {
+		return h.retry(ctx, "refresh", func() error {
+			var diags diag.Diagnostics
+			state, diags = rp.RefreshWithoutUpgrade(ctx, imported, p.Meta())
+			return diagsError("error reading the instance state", diags)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if state == nil || state.ID == "" {
+		return fmt.Errorf("%s %s does not exist", h.ResourceType, id)
+	}
+
+	// Write the state
+	setSchemaVersion(state, rp)
+	h.ResourceState = state
+	return h.write(s, state)
+}
+
+// ImportPlugin is the Import counterpart for out-of-process providers
+func (h *Handler) ImportPlugin(ctx context.Context, p *provider.Plugin, s State, id string) error {
+
+	rs, ok := p.ResourceSchema(h.ResourceType)
+	if !ok {
+		return fmt.Errorf("unknown resource type %s", h.ResourceType)
+	}
+
+	if err := h.checkNoState(s); err != nil {
+		return err
+	}
+
+	h.emit(event.Event{Kind: event.Importing})
+
+	var imported, state cty.Value
+	var private []byte
+	err := h.retry(ctx, "import", func() error {
+		var diags tfd.Diagnostics
+		imported, private, diags = p.ImportResourceState(ctx, h.ResourceType, id)
+		if diags.HasErrors() {
+			return fmt.Errorf("error importing resource: %s", diags.Err())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Refresh the state
+	err = h.deadline(ctx, schema.TimeoutRead, h.timeout(schema.TimeoutRead, nil), func(ctx context.Context) error {
+		return h.retry(ctx, "refresh", func() error {
+			var diags tfd.Diagnostics
+			state, private, diags = p.ReadResource(ctx, h.ResourceType, imported, private)
+			if diags.HasErrors() {
+				return fmt.Errorf("error reading the instance state: %s", diags.Err())
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	is := stateFromValue(state, private, rs.Version)
+	if is == nil || is.ID == "" {
+		return fmt.Errorf("%s %s does not exist", h.ResourceType, id)
+	}
+
+	// Write the state
+	h.ResourceState = is
+	return h.write(s, is)
+}
+
+//-----------------------------------------------------------------------------
+// Helpers
+//-----------------------------------------------------------------------------
+
+// checkNoState fails if the resource already has a state
+func (h *Handler) checkNoState(s State) error {
+	is := &terraform.InstanceState{}
+	if err := s.Read(h.ResourceLogicalID, is); err != nil {
+		return err
+	}
+	if is.ID != "" {
+		return fmt.Errorf("%s is already managed as %s", h.ResourceLogicalID, is.ID)
+	}
+	return nil
+}
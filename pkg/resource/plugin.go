@@ -0,0 +1,388 @@
+package resource
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	// terraform
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+
+	// terramorph
+	"github.com/h0tbird/terramorph/pkg/event"
+	"github.com/h0tbird/terramorph/pkg/provider"
+	"github.com/h0tbird/terramorph/pkg/tfd"
+)
+
+//-----------------------------------------------------------------------------
+// Types
+//-----------------------------------------------------------------------------
+
+// pluginPlan is what ReconcilePlugin needs to apply a change
+type pluginPlan struct {
+	rs      *provider.Schema
+	ty      cty.Type
+	config  cty.Value
+	prior   cty.Value
+	private []byte
+	change  *provider.PlannedChange
+}
+
+//-----------------------------------------------------------------------------
+// Methods
+//-----------------------------------------------------------------------------
+
+// ReconcilePlugin is the Reconcile counterpart for out-of-process providers.
+// Instead of calling the schema.Resource directly the resource is refreshed,
+// planned and applied through the provider protocol.
+func (h *Handler) ReconcilePlugin(ctx context.Context, p *provider.Plugin, s State, r map[string]*Handler) error {
+
+	pl, err := h.planPlugin(ctx, p, s, r)
+	if err != nil {
+		return err
+	}
+
+	// Return if there is nothing to sync
+	if pl.noOp() {
+		h.emit(event.Event{Kind: event.Unchanged})
+		return nil
+	}
+
+	// Only the approved change is applied
+	if err := h.approved(h.pluginChange(pl)); err != nil {
+		return err
+	}
+
+	// Out-of-sync attributes
+	diff := diffKeys(pl.prior, pl.change.PlannedState)
+
+	// Replace by destroying first
+	if pl.replace() {
+		h.emit(event.Event{Kind: event.Replacing, Diff: diff})
+		err := h.deadline(ctx, schema.TimeoutDelete, h.timeout(schema.TimeoutDelete, nil), func(ctx context.Context) error {
+			return h.retry(ctx, "destroy", func() error {
+				_, _, diags := p.ApplyResourceChange(ctx, h.ResourceType, pl.prior, cty.NullVal(pl.ty), cty.NullVal(pl.ty), pl.private)
+				if diags.HasErrors() {
+					return fmt.Errorf("error destroying resource: %s", diags.Err())
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		pl.prior, pl.private = cty.NullVal(pl.ty), nil
+		if err := h.remove(s); err != nil {
+			return err
+		}
+
+		if pl.change, err = h.planChange(ctx, p, pl); err != nil {
+			return err
+		}
+	}
+
+	// Apply the changes
+	h.emit(event.Event{Kind: event.Applying, Diff: diff})
+	op := schema.TimeoutUpdate
+	if pl.prior.IsNull() {
+		op = schema.TimeoutCreate
+	}
+
+	var newState cty.Value
+	var newPrivate []byte
+	err = h.deadline(ctx, op, h.timeout(op, nil), func(ctx context.Context) error {
+		return h.retry(ctx, "apply", func() error {
+			var diags tfd.Diagnostics
+			newState, newPrivate, diags = p.ApplyResourceChange(ctx, h.ResourceType, pl.prior, pl.change.PlannedState, pl.config, pl.change.PlannedPrivate)
+			if !diags.HasErrors() {
+				return nil
+			}
+			err := fmt.Errorf("error configuring resource: %s", diags.Err())
+
+			// A resource created before the error must not be created twice
+			if pl.prior.IsNull() && newState != cty.NilVal && !newState.IsNull() {
+				return &permanentError{err}
+			}
+			return err
+		})
+	})
+
+	// The outcome of an operation left running is unknown
+	if abandoned(err) {
+		return err
+	}
+
+	// Without a new state, as after a transport error, the prior one is kept
+	if newState == cty.NilVal || newState.IsNull() || !newState.IsWhollyKnown() {
+		newState, newPrivate = pl.prior, pl.private
+	}
+
+	// Write the state even on errors, the resource may be half created
+	state := stateFromValue(newState, newPrivate, pl.rs.Version)
+	h.ResourceState = state
+	h.log().Debug("Writing the state")
+	if err := h.write(s, state); err != nil {
+		return err
+	}
+
+	return err
+}
+
+// PlanPlugin is the Plan counterpart for out-of-process providers
+func (h *Handler) PlanPlugin(ctx context.Context, p *provider.Plugin, s State, r map[string]*Handler) (*Change, error) {
+
+	pl, err := h.planPlugin(ctx, p, s, r)
+	if err != nil {
+		return nil, err
+	}
+
+	c := h.pluginChange(pl)
+
+	// The state of created and replaced resources is unknown to dependents
+	if c.Action == Create || c.Action == Replace {
+		h.ResourceState = nil
+	}
+
+	return c, nil
+}
+
+// DestroyPlugin is the Destroy counterpart for out-of-process providers
+func (h *Handler) DestroyPlugin(ctx context.Context, p *provider.Plugin, s State) error {
+
+	rs, ok := p.ResourceSchema(h.ResourceType)
+	if !ok {
+		return fmt.Errorf("unknown resource type %s", h.ResourceType)
+	}
+	ty := rs.Block.ImpliedType()
+
+	state := &terraform.InstanceState{}
+	if err := s.Read(h.ResourceLogicalID, state); err != nil {
+		return err
+	}
+	if state.ID == "" {
+		return nil
+	}
+
+	prior, private, err := priorFromState(ctx, p, h.ResourceType, state)
+	if err != nil {
+		return err
+	}
+
+	h.emit(event.Event{Kind: event.Destroying})
+	err = h.deadline(ctx, schema.TimeoutDelete, h.timeout(schema.TimeoutDelete, nil), func(ctx context.Context) error {
+		return h.retry(ctx, "destroy", func() error {
+			if _, _, diags := p.ApplyResourceChange(ctx, h.ResourceType, prior, cty.NullVal(ty), cty.NullVal(ty), private); diags.HasErrors() {
+				return fmt.Errorf("error destroying resource: %s", diags.Err())
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	h.ResourceState = nil
+	return h.remove(s)
+}
+
+// planPlugin reads, upgrades and refreshes the state and plans the change
+func (h *Handler) planPlugin(ctx context.Context, p *provider.Plugin, s State, r map[string]*Handler) (*pluginPlan, error) {
+
+	// Resource schema and config
+	rs, ok := p.ResourceSchema(h.ResourceType)
+	if !ok {
+		return nil, fmt.Errorf("unknown resource type %s", h.ResourceType)
+	}
+	pl := &pluginPlan{rs: rs, ty: rs.Block.ImpliedType()}
+
+	config, err := rs.Block.CoerceValue(h.resolve(r))
+	if err != nil {
+		return nil, fmt.Errorf("invalid resource config: %s", err)
+	}
+	pl.config = config
+
+	if diags := p.ValidateResourceTypeConfig(ctx, h.ResourceType, config); diags.HasErrors() {
+		return nil, fmt.Errorf("invalid resource config: %s", diags.Err())
+	}
+
+	// Read the stored state
+	h.ResourceState = &terraform.InstanceState{}
+	if err := s.Read(h.ResourceLogicalID, h.ResourceState); err != nil {
+		return nil, err
+	}
+
+	pl.prior = cty.NullVal(pl.ty)
+
+	if h.ResourceState.ID != "" {
+
+		prior, private, err := priorFromState(ctx, p, h.ResourceType, h.ResourceState)
+		if err != nil {
+			return nil, err
+		}
+
+		// Refresh the state
+		h.emit(event.Event{Kind: event.Refreshing})
+		err = h.deadline(ctx, schema.TimeoutRead, h.timeout(schema.TimeoutRead, nil), func(ctx context.Context) error {
+			return h.retry(ctx, "refresh", func() error {
+				var diags tfd.Diagnostics
+				pl.prior, pl.private, diags = p.ReadResource(ctx, h.ResourceType, prior, private)
+				if diags.HasErrors() {
+					return fmt.Errorf("error reading the instance state: %s", diags.Err())
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Plan
+	h.emit(event.Event{Kind: event.Diffing})
+	if pl.change, err = h.planChange(ctx, p, pl); err != nil {
+		return nil, err
+	}
+
+	return pl, nil
+}
+
+//-----------------------------------------------------------------------------
+// Helpers
+//-----------------------------------------------------------------------------
+
+// planChange plans the change from the prior state to the config
+func (h *Handler) planChange(ctx context.Context, p *provider.Plugin, pl *pluginPlan) (*provider.PlannedChange, error) {
+
+	proposed := pl.rs.Block.ProposedNewObject(pl.prior, pl.config)
+
+	var change *provider.PlannedChange
+	err := h.retry(ctx, "diff", func() error {
+		var diags tfd.Diagnostics
+		change, diags = p.PlanResourceChange(ctx, h.ResourceType, pl.prior, proposed, pl.config, pl.private)
+		if diags.HasErrors() {
+			return fmt.Errorf("error planning resource: %s", diags.Err())
+		}
+		return nil
+	})
+
+	return change, err
+}
+
+// pluginChange returns the change of a plan
+func (h *Handler) pluginChange(pl *pluginPlan) *Change {
+
+	c := &Change{LogicalID: h.ResourceLogicalID, Type: h.ResourceType, Action: NoOp}
+	if pl.noOp() {
+		return c
+	}
+
+	c.Attributes = diffKeys(pl.prior, pl.change.PlannedState)
+	switch {
+	case pl.prior.IsNull():
+		c.Action = Create
+	case pl.replace():
+		c.Action = Replace
+	default:
+		c.Action = Update
+	}
+
+	return c
+}
+
+func (pl *pluginPlan) noOp() bool {
+	return pl.prior.RawEquals(pl.change.PlannedState)
+}
+
+func (pl *pluginPlan) replace() bool {
+	return !pl.prior.IsNull() && len(pl.change.RequiresReplace) > 0
+}
+
+// priorFromState upgrades a stored state into a protocol value. The meta data
+// goes back to the provider as the private blob.
+func priorFromState(ctx context.Context, p *provider.Plugin, resourceType string, state *terraform.InstanceState) (cty.Value, []byte, error) {
+
+	flatmap := map[string]string{}
+	for k, v := range state.Attributes {
+		flatmap[k] = v
+	}
+	flatmap["id"] = state.ID
+
+	prior, diags := p.UpgradeResourceState(ctx, resourceType, SchemaVersion(state), flatmap)
+	if diags.HasErrors() {
+		return cty.NilVal, nil, fmt.Errorf("error upgrading the instance state: %s", diags.Err())
+	}
+
+	var private []byte
+	if state.Meta != nil {
+		meta := map[string]interface{}{}
+		for k, v := range state.Meta {
+			if k != TypeKey {
+				meta[k] = v
+			}
+		}
+		var err error
+		if private, err = json.Marshal(meta); err != nil {
+			return cty.NilVal, nil, err
+		}
+	}
+
+	return prior, private, nil
+}
+
+// stateFromValue converts a protocol state value into an InstanceState
+func stateFromValue(v cty.Value, private []byte, version int64) *terraform.InstanceState {
+
+	// Null means no state
+	if v == cty.NilVal || v.IsNull() || !v.IsWhollyKnown() {
+		return nil
+	}
+
+	state := terraform.NewInstanceStateShimmedFromValue(v, int(version))
+
+	// SDK providers keep their meta data as JSON in the private blob
+	meta := map[string]interface{}{}
+	if len(private) > 0 && json.Unmarshal(private, &meta) == nil {
+		for k, v := range meta {
+			state.Meta[k] = v
+		}
+	}
+	state.Meta[SchemaVersionKey] = strconv.FormatInt(version, 10)
+
+	return state
+}
+
+// diffKeys lists the top-level attributes that differ between two values
+func diffKeys(prior, planned cty.Value) []string {
+
+	keys := []string{}
+	if planned.IsNull() {
+		return keys
+	}
+
+	for name := range planned.Type().AttributeTypes() {
+		after := planned.GetAttr(name)
+		if prior.IsNull() {
+			if !after.IsNull() {
+				keys = append(keys, name)
+			}
+			continue
+		}
+		if !prior.GetAttr(name).RawEquals(after) {
+			keys = append(keys, name)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys
+}
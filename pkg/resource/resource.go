@@ -11,15 +11,18 @@ import (
 	"fmt"
 	"reflect"
 	"regexp"
+	"sort"
 	"strings"
 
-	// community
-	"github.com/sirupsen/logrus"
-
 	// terraform
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+
+	// terramorph
+	"github.com/h0tbird/terramorph/pkg/event"
+	"github.com/h0tbird/terramorph/pkg/logger"
+	"github.com/h0tbird/terramorph/pkg/provider"
 )
 
 //-----------------------------------------------------------------------------
@@ -35,123 +38,437 @@ var importStateIgnore = map[string][]string{
 // Reg <resource>.<ResourceConfig|ResourceState>.<field>
 var Reg = regexp.MustCompile("(\\w+)\\.(ResourceConfig|ResourceState)\\.(\\w+)")
 
+// LogicalIDReg matches the logical IDs state can be stored under
+var LogicalIDReg = regexp.MustCompile("^[A-Za-z0-9][A-Za-z0-9_.-]*$")
+
+// TypeKey is the InstanceState.Meta key holding the resource type, so the
+// state can be read without the manifest
+const TypeKey = "terramorph_type"
+
 //-----------------------------------------------------------------------------
 // Types
 //-----------------------------------------------------------------------------
 
+// Action is the kind of change planned for a resource
+type Action string
+
+// Actions
+const (
+	NoOp    Action = "no-op"
+	Create  Action = "create"
+	Update  Action = "update"
+	Replace Action = "replace"
+	Delete  Action = "delete"
+)
+
+// Change is the planned change of a resource
+type Change struct {
+	LogicalID  string   `json:"logicalID"`
+	Type       string   `json:"type"`
+	Action     Action   `json:"action"`
+	Attributes []string `json:"attributes,omitempty"`
+}
+
 // State ...
 type State interface {
 	Read(string, interface{}) error
 	Write(string, interface{}) error
 }
 
+// sdkPlan is what Reconcile needs to apply a change
+type sdkPlan struct {
+	rp       *schema.Resource
+	state    *terraform.InstanceState
+	diff     *terraform.InstanceDiff
+	upgraded bool
+}
+
 // Handler ...
 type Handler struct {
 	ResourceLogicalID string
 	ResourceType      string
+	Provider          string
 	ResourceConfig    map[string]interface{}
 	ResourceState     *terraform.InstanceState
+	Retry             *RetryPolicy
+	Events            event.Sink
+	Log               logger.Logger
+
+	// Approved is the change the resource was planned and approved with.
+	// When set, a different change is refused instead of applied.
+	Approved *Change
 }
 
 //-----------------------------------------------------------------------------
 // Methods
 //-----------------------------------------------------------------------------
 
+// ProviderAlias returns the alias of the provider instance managing the
+// resource. It defaults to the resource type prefix: aws_iam_role uses aws.
+func (h *Handler) ProviderAlias() string {
+	if h.Provider != "" {
+		return h.Provider
+	}
+	return strings.SplitN(h.ResourceType, "_", 2)[0]
+}
+
+// describe tells the action of a change and the attributes it touches
+func (c *Change) describe() string {
+	if len(c.Attributes) == 0 {
+		return string(c.Action)
+	}
+	return fmt.Sprintf("%s of %s", c.Action, strings.Join(c.Attributes, ", "))
+}
+
 // Reconcile ...
 func (h *Handler) Reconcile(ctx context.Context, p *schema.Provider, s State, r map[string]*Handler) error {
 
-	// Fixed log fields
-	logFields := logrus.Fields{
-		"id":   h.ResourceLogicalID,
-		"type": h.ResourceType,
+	pl, err := h.plan(ctx, p, s, r)
+	if err != nil {
+		return err
 	}
 
-	// Update the ResourceConfig
-	for k, v := range h.ResourceConfig {
-		submatch := Reg.FindStringSubmatch(v.(string))
-		if submatch != nil {
-			switch submatch[2] {
-			case "ResourceConfig":
-				h.ResourceConfig[k] = r[submatch[1]].ResourceConfig[submatch[3]]
-			case "ResourceState":
-				h.ResourceConfig[k] = reflect.ValueOf(r[submatch[1]].ResourceState).Elem().FieldByName(submatch[3]).String()
+	// Persist an upgraded state even when there is nothing else to do
+	if pl.upgraded {
+		if err := h.write(s, h.ResourceState); err != nil {
+			return err
+		}
+	}
+
+	// Return if there is nothing to sync
+	if pl.diff == nil {
+		h.emit(event.Event{Kind: event.Unchanged})
+		return nil
+	}
+
+	// Only the approved change is applied
+	if err := h.approved(h.sdkChange(pl)); err != nil {
+		return err
+	}
+
+	// Apply the changes
+	h.emit(event.Event{Kind: event.Applying, Diff: diffAttributes(pl.diff)})
+	op, timeout := schema.TimeoutUpdate, h.timeout(schema.TimeoutUpdate, pl.rp.Timeouts)
+	switch {
+	case pl.state == nil || pl.state.ID == "":
+		op, timeout = schema.TimeoutCreate, h.timeout(schema.TimeoutCreate, pl.rp.Timeouts)
+	case pl.diff.RequiresNew():
+		op, timeout = "replace", h.timeout(schema.TimeoutDelete, pl.rp.Timeouts)+h.timeout(schema.TimeoutCreate, pl.rp.Timeouts)
+	}
+
+	var state *terraform.InstanceState
+	err = h.deadline(ctx, op, timeout, func(ctx context.Context) error {
+		return h.retry(ctx, "apply", func() error {
+			var diags diag.Diagnostics
+			state, diags = pl.rp.Apply(ctx, pl.state, pl.diff, p.Meta())
+			err := diagsError("error configuring resource", diags)
+
+			// A resource created before the error must not be created twice
+			if err != nil && state != nil && state.ID != "" && (pl.state == nil || pl.state.ID == "") {
+				return &permanentError{err}
+			}
+			return err
+		})
+	})
+	if err != nil {
+
+		// Record what the provider left behind, the resource may be half
+		// created
+		if !abandoned(err) && state != nil && state.ID != "" {
+			setSchemaVersion(state, pl.rp)
+			h.ResourceState = state
+			h.log().Debug("Writing the state")
+			if werr := h.write(s, state); werr != nil {
+				return fmt.Errorf("%s, and the state was not written: %s", err, werr)
 			}
 		}
+		return err
+	}
+
+	// Write the state
+	setSchemaVersion(state, pl.rp)
+	h.ResourceState = state
+	h.log().Debug("Writing the state")
+	if err := h.write(s, state); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Plan returns the change Reconcile would make without making it
+func (h *Handler) Plan(ctx context.Context, p *schema.Provider, s State, r map[string]*Handler) (*Change, error) {
+
+	pl, err := h.plan(ctx, p, s, r)
+	if err != nil {
+		return nil, err
+	}
+
+	c := h.sdkChange(pl)
+
+	// The state of created and replaced resources is unknown to dependents
+	if c.Action == Create || c.Action == Replace {
+		h.ResourceState = nil
+	}
+
+	return c, nil
+}
+
+// Destroy deletes the resource, if it exists, and its state
+func (h *Handler) Destroy(ctx context.Context, p *schema.Provider, s State) error {
+
+	rp, ok := p.ResourcesMap[h.ResourceType]
+	if !ok {
+		return fmt.Errorf("unknown resource type %s", h.ResourceType)
+	}
+
+	state := &terraform.InstanceState{}
+	if err := s.Read(h.ResourceLogicalID, state); err != nil {
+		return err
+	}
+	if state.ID == "" {
+		return nil
 	}
 
+	h.emit(event.Event{Kind: event.Destroying})
+	err := h.deadline(ctx, schema.TimeoutDelete, h.timeout(schema.TimeoutDelete, rp.Timeouts), func(ctx context.Context) error {
+		return h.retry(ctx, "destroy", func() error {
+			_, diags := rp.Apply(ctx, state, &terraform.InstanceDiff{Destroy: true}, p.Meta())
+			return diagsError("error destroying resource", diags)
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	h.ResourceState = nil
+	return h.remove(s)
+}
+
+// plan reads, upgrades and refreshes the state and diffs it with the config
+func (h *Handler) plan(ctx context.Context, p *schema.Provider, s State, r map[string]*Handler) (*sdkPlan, error) {
+
 	// Resource pointer and config
-	rp := p.ResourcesMap[h.ResourceType]
-	rc := &terraform.ResourceConfig{
-		Config: h.ResourceConfig,
+	rp, ok := p.ResourcesMap[h.ResourceType]
+	if !ok {
+		return nil, fmt.Errorf("unknown resource type %s", h.ResourceType)
 	}
+	rc := terraform.NewResourceConfigRaw(h.resolve(r))
+	pl := &sdkPlan{rp: rp}
 
 	// Read the stored state
 	h.ResourceState = &terraform.InstanceState{}
 	if err := s.Read(h.ResourceLogicalID, h.ResourceState); err != nil {
-		return err
+		return nil, err
 	}
 
-	// Refresh the state
-	logrus.WithFields(logFields).Info("Refreshing the state")
-	state, diags := rp.RefreshWithoutUpgrade(ctx, h.ResourceState, p.Meta())
-	if diags != nil && diags.HasError() {
-		for _, d := range diags {
-			if d.Severity == diag.Error {
-				return fmt.Errorf("error reading the instance state: %s", d.Summary)
-			}
+	// Upgrade state written by an older schema
+	if h.ResourceState.ID != "" && SchemaVersion(h.ResourceState) < rp.SchemaVersion {
+		h.emit(event.Event{Kind: event.Upgrading})
+		upgraded, err := upgradeState(ctx, p, h.ResourceType, h.ResourceState)
+		if err != nil {
+			return nil, fmt.Errorf("error upgrading the instance state: %s", err)
 		}
+		h.ResourceState = upgraded
+		pl.upgraded = true
 	}
 
-	// Diff
-	logrus.WithFields(logFields).Info("Diffing state and config")
-	diff, err := rp.Diff(ctx, state, rc, p.Meta())
+	// Refresh the state
+	h.emit(event.Event{Kind: event.Refreshing})
+	err := h.deadline(ctx, schema.TimeoutRead, h.timeout(schema.TimeoutRead, rp.Timeouts), func(ctx context.Context) error {
+		return h.retry(ctx, "refresh", func() error {
+			var diags diag.Diagnostics
+			pl.state, diags = rp.RefreshWithoutUpgrade(ctx, h.ResourceState, p.Meta())
+			return diagsError("error reading the instance state", diags)
+		})
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Return if there is nothing to sync
-	if diff == nil {
-		logrus.WithFields(logFields).Info("All good")
-		return nil
+	// Diff
+	h.emit(event.Event{Kind: event.Diffing})
+	var diff *terraform.InstanceDiff
+	err = h.retry(ctx, "diff", func() error {
+		var err error
+		diff, err = rp.Diff(ctx, pl.state, rc, p.Meta())
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Remove all ignored attributes
-	for _, v := range importStateIgnore[h.ResourceType] {
-		for k := range diff.Attributes {
-			if strings.HasPrefix(k, v) {
-				delete(diff.Attributes, k)
+	if diff != nil {
+
+		// Remove all ignored attributes
+		for _, v := range importStateIgnore[h.ResourceType] {
+			for k := range diff.Attributes {
+				if strings.HasPrefix(k, v) {
+					delete(diff.Attributes, k)
+				}
 			}
 		}
+
+		if len(diff.Attributes) > 0 {
+			pl.diff = diff
+		}
 	}
 
-	// Return if there is nothing to sync
-	if len(diff.Attributes) == 0 {
-		logrus.WithFields(logFields).Info("All good")
+	return pl, nil
+}
+
+//-----------------------------------------------------------------------------
+// Helpers
+//-----------------------------------------------------------------------------
+
+// sdkChange returns the change of a plan
+func (h *Handler) sdkChange(pl *sdkPlan) *Change {
+
+	c := &Change{LogicalID: h.ResourceLogicalID, Type: h.ResourceType, Action: NoOp}
+	if pl.diff == nil {
+		return c
+	}
+
+	c.Attributes = diffAttributes(pl.diff)
+	switch {
+	case pl.state == nil || pl.state.ID == "":
+		c.Action = Create
+	case pl.diff.RequiresNew():
+		c.Action = Replace
+	default:
+		c.Action = Update
+	}
+
+	return c
+}
+
+// approved fails when c is not the approved change. It may touch fewer
+// attributes: values unknown to the plan can turn out to be the same.
+func (h *Handler) approved(c *Change) error {
+
+	if h.Approved == nil {
 		return nil
 	}
 
-	// Add out-of-sync attributes to the log
-	logFields["diff"] = []string{}
-	for k := range diff.Attributes {
-		logFields["diff"] = append(logFields["diff"].([]string), k)
+	approved := map[string]bool{}
+	for _, k := range h.Approved.Attributes {
+		approved[k] = true
 	}
 
-	// Apply the changes
-	logrus.WithFields(logFields).Info("Applying changes")
-	state, diags = rp.Apply(ctx, state, diff, p.Meta())
-	if diags != nil && diags.HasError() {
-		for _, d := range diags {
-			if d.Severity == diag.Error {
-				return fmt.Errorf("error configuring resource: %s", d.Summary)
-			}
+	ok := c.Action == h.Approved.Action
+	for _, k := range c.Attributes {
+		ok = ok && approved[k]
+	}
+	if ok {
+		return nil
+	}
+
+	return fmt.Errorf("the plan changed since it was approved, it is now %s instead of %s: run apply again to review it", c.describe(), h.Approved.describe())
+}
+
+// remove drops the state of the resource. The entry is deleted when the
+// backend can delete, its history is the backend's to keep.
+func (h *Handler) remove(s State) error {
+	if d, ok := s.(interface{ Delete(string) error }); ok {
+		return d.Delete(h.ResourceLogicalID)
+	}
+	return s.Write(h.ResourceLogicalID, (*terraform.InstanceState)(nil))
+}
+
+// write stores state as the state of the resource, stamped with its type
+func (h *Handler) write(s State, state *terraform.InstanceState) error {
+	if state != nil {
+		if state.Meta == nil {
+			state.Meta = map[string]interface{}{}
 		}
+		state.Meta[TypeKey] = h.ResourceType
 	}
+	return s.Write(h.ResourceLogicalID, state)
+}
 
-	// Write the state
-	h.ResourceState = state
-	if err := s.Write(h.ResourceLogicalID, state); err != nil {
-		return err
+// log returns Log with the resource fields, or a logger dropping everything
+// without one
+func (h *Handler) log() logger.Logger {
+	if h.Log == nil {
+		return logger.Nop
+	}
+	return h.Log.With(logger.F("id", h.ResourceLogicalID), logger.F("type", h.ResourceType))
+}
+
+// emit sends e, about the resource, to Events
+func (h *Handler) emit(e event.Event) {
+	e.LogicalID, e.Type = h.ResourceLogicalID, h.ResourceType
+	event.Emit(h.Events, e)
+}
+
+// resolve returns the ResourceConfig with the references to other resources
+// replaced by their values. References to the state of resources that do not
+// exist yet resolve to provider.UnknownValue.
+func (h *Handler) resolve(r map[string]*Handler) map[string]interface{} {
+
+	config := map[string]interface{}{}
+
+	for k, v := range h.ResourceConfig {
+		config[k] = resolveValue(v, r)
+	}
+
+	return config
+}
+
+func resolveValue(v interface{}, r map[string]*Handler) interface{} {
+
+	s, ok := v.(string)
+	if !ok {
+		return v
 	}
 
+	submatch := Reg.FindStringSubmatch(s)
+	if submatch == nil {
+		return v
+	}
+
+	target, ok := r[submatch[1]]
+	if !ok {
+		return provider.UnknownValue
+	}
+
+	switch submatch[2] {
+	case "ResourceConfig":
+		if v, ok := target.ResourceConfig[submatch[3]]; ok {
+			return resolveValue(v, r)
+		}
+	case "ResourceState":
+		if target.ResourceState != nil && target.ResourceState.ID != "" {
+			return reflect.ValueOf(target.ResourceState).Elem().FieldByName(submatch[3]).String()
+		}
+	}
+
+	return provider.UnknownValue
+}
+
+// StateType returns the resource type a state was written for, empty when
+// it was written before types were recorded
+func StateType(state *terraform.InstanceState) string {
+	t, _ := state.Meta[TypeKey].(string)
+	return t
+}
+
+// diagsError returns the first error of diags prefixed with msg, or nil
+func diagsError(msg string, diags diag.Diagnostics) error {
+	for _, d := range diags {
+		if d.Severity == diag.Error {
+			return fmt.Errorf("%s: %s", msg, d.Summary)
+		}
+	}
 	return nil
 }
+
+// diffAttributes lists the attributes of a diff, sorted
+func diffAttributes(diff *terraform.InstanceDiff) []string {
+	keys := []string{}
+	for k := range diff.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,218 @@
+package resource_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+
+	"github.com/h0tbird/terramorph/pkg/resource"
+	"github.com/h0tbird/terramorph/pkg/state"
+)
+
+func TestReconcileCreatedWithError(t *testing.T) {
+	creates := 0
+	p := &schema.Provider{
+		ResourcesMap: map[string]*schema.Resource{
+			"test_role": {
+				Schema: map[string]*schema.Schema{
+					"name": {Type: schema.TypeString, Required: true, ForceNew: true},
+				},
+				CreateContext: func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+					creates++
+					d.SetId(d.Get("name").(string))
+					return diag.Errorf("tagging failed")
+				},
+				ReadContext: func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+					return nil
+				},
+				DeleteContext: func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+					return nil
+				},
+			},
+		},
+	}
+
+	h := &resource.Handler{
+		ResourceLogicalID: "Role",
+		ResourceType:      "test_role",
+		ResourceConfig:    map[string]interface{}{"name": "nodes"},
+	}
+
+	s := state.NewMemory()
+	err := h.Reconcile(context.Background(), p, s, nil)
+	if err == nil || !strings.Contains(err.Error(), "tagging failed") {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// The created resource is recorded
+	is := &terraform.InstanceState{}
+	if err := s.Read("Role", is); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if is.ID != "nodes" || resource.StateType(is) != "test_role" {
+		t.Fatalf("bad: %#v", is)
+	}
+
+	// and not created again
+	if err := h.Reconcile(context.Background(), p, s, nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if creates != 1 {
+		t.Fatalf("bad: %d creates", creates)
+	}
+}
+
+func TestReconcileTimedOut(t *testing.T) {
+	p := &schema.Provider{
+		ResourcesMap: map[string]*schema.Resource{
+			"test_role": {
+				Schema: map[string]*schema.Schema{
+					"name": {Type: schema.TypeString, Required: true, ForceNew: true},
+				},
+				Timeouts: &schema.ResourceTimeout{Create: schema.DefaultTimeout(time.Minute)},
+				CreateContext: func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+					d.SetId(d.Get("name").(string))
+					<-ctx.Done()
+					return diag.FromErr(ctx.Err())
+				},
+				ReadContext: func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+					return nil
+				},
+				DeleteContext: func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+					return nil
+				},
+			},
+		},
+	}
+
+	h := &resource.Handler{
+		ResourceLogicalID: "Role",
+		ResourceType:      "test_role",
+		ResourceConfig: map[string]interface{}{
+			"name":     "nodes",
+			"timeouts": map[string]interface{}{"create": "20ms"},
+		},
+	}
+
+	// The create is waited for after the deadline and what it made recorded
+	s := state.NewMemory()
+	err := h.Reconcile(context.Background(), p, s, nil)
+	if _, ok := err.(*resource.TimeoutError); !ok {
+		t.Fatalf("bad: %#v", err)
+	}
+
+	is := &terraform.InstanceState{}
+	if err := s.Read("Role", is); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if is.ID != "nodes" {
+		t.Fatalf("bad: %#v", is)
+	}
+}
+
+func TestReconcileApproved(t *testing.T) {
+	creates := 0
+	p := &schema.Provider{
+		ResourcesMap: map[string]*schema.Resource{
+			"test_role": {
+				Schema: map[string]*schema.Schema{
+					"name": {Type: schema.TypeString, Required: true, ForceNew: true},
+				},
+				CreateContext: func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+					creates++
+					d.SetId(d.Get("name").(string))
+					return nil
+				},
+				ReadContext: func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+					return nil
+				},
+				DeleteContext: func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+					return nil
+				},
+			},
+		},
+	}
+
+	h := &resource.Handler{
+		ResourceLogicalID: "Role",
+		ResourceType:      "test_role",
+		ResourceConfig:    map[string]interface{}{"name": "nodes"},
+	}
+	s := state.NewMemory()
+
+	// A create approved as a no-op is refused
+	h.Approved = &resource.Change{LogicalID: "Role", Type: "test_role", Action: resource.NoOp}
+	err := h.Reconcile(context.Background(), p, s, nil)
+	if err == nil || !strings.Contains(err.Error(), "it is now create of name instead of no-op") {
+		t.Fatalf("bad: %v", err)
+	}
+	if creates != 0 {
+		t.Fatalf("bad: %d creates", creates)
+	}
+
+	// and applied once approved
+	c, err := h.Plan(context.Background(), p, s, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	h.Approved = c
+	if err := h.Reconcile(context.Background(), p, s, nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if creates != 1 {
+		t.Fatalf("bad: %d creates", creates)
+	}
+}
+
+func TestDestroyDeletesState(t *testing.T) {
+	p := &schema.Provider{
+		ResourcesMap: map[string]*schema.Resource{
+			"test_role": {
+				Schema: map[string]*schema.Schema{
+					"name": {Type: schema.TypeString, Required: true, ForceNew: true},
+				},
+				CreateContext: func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+					d.SetId(d.Get("name").(string))
+					return nil
+				},
+				ReadContext: func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+					return nil
+				},
+				DeleteContext: func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+					return nil
+				},
+			},
+		},
+	}
+
+	h := &resource.Handler{
+		ResourceLogicalID: "Role",
+		ResourceType:      "test_role",
+		ResourceConfig:    map[string]interface{}{"name": "nodes"},
+	}
+	s := state.NewFile(t.TempDir())
+	if err := h.Reconcile(context.Background(), p, s, nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := h.Destroy(context.Background(), p, s); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The entry is gone, its last version can still be restored
+	if ids, err := s.List(); err != nil || len(ids) != 0 {
+		t.Fatalf("bad: %v %v", ids, err)
+	}
+	versions, err := s.Versions("Role")
+	if err != nil || len(versions) == 0 {
+		t.Fatalf("bad: %v %v", versions, err)
+	}
+	is := &terraform.InstanceState{}
+	if err := s.ReadVersion("Role", versions[len(versions)-1].Number, is); err != nil || is.ID != "nodes" {
+		t.Fatalf("bad: %#v %v", is, err)
+	}
+}
@@ -0,0 +1,229 @@
+package resource
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"context"
+	"math/rand"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	// terramorph
+	"github.com/h0tbird/terramorph/pkg/event"
+	"github.com/h0tbird/terramorph/pkg/logger"
+)
+
+//-----------------------------------------------------------------------------
+// Globals
+//-----------------------------------------------------------------------------
+
+// DefaultRetryable matches the provider errors worth retrying: throttling,
+// transient server errors and IAM eventual consistency.
+var DefaultRetryable = regexp.MustCompile(strings.Join([]string{
+	"Throttl",
+	"Rate exceeded",
+	"RequestLimitExceeded",
+	"TooManyRequests",
+	"SlowDown",
+	"ServiceUnavailable",
+	"InternalFailure",
+	"NoSuchEntity",
+	"connection reset",
+	"i/o timeout",
+}, "|"))
+
+// DefaultRetryPolicy is used by resources without a retry policy
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxAttempts: 5,
+	MinBackoff:  time.Second,
+	MaxBackoff:  30 * time.Second,
+	Retryable:   DefaultRetryable,
+}
+
+// Limits is the per-service rate limiter shared by every walk. Services
+// without a rate are not limited.
+var Limits = NewLimiter()
+
+//-----------------------------------------------------------------------------
+// Types
+//-----------------------------------------------------------------------------
+
+// RetryPolicy retries the Refresh, Diff and Apply steps of a resource when
+// the provider returns a retryable error. The backoff doubles on every
+// attempt, from MinBackoff up to MaxBackoff, with full jitter.
+type RetryPolicy struct {
+	MaxAttempts int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	Retryable   *regexp.Regexp
+}
+
+// Rate of a token bucket: PerSecond tokens are added up to Burst
+type Rate struct {
+	PerSecond float64
+	Burst     int
+}
+
+// Limiter holds one token bucket per service
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	rate   Rate
+	tokens float64
+	last   time.Time
+}
+
+// permanentError is not retried whatever the policy says
+type permanentError struct {
+	error
+}
+
+//-----------------------------------------------------------------------------
+// Constructor
+//-----------------------------------------------------------------------------
+
+// NewLimiter ...
+func NewLimiter() *Limiter {
+	return &Limiter{buckets: map[string]*bucket{}}
+}
+
+//-----------------------------------------------------------------------------
+// Methods
+//-----------------------------------------------------------------------------
+
+// SetRate sets the rate of a service. A zero rate removes the limit.
+func (l *Limiter) SetRate(service string, rate Rate) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if rate.PerSecond <= 0 {
+		delete(l.buckets, service)
+		return
+	}
+	if rate.Burst < 1 {
+		rate.Burst = 1
+	}
+	l.buckets[service] = &bucket{rate: rate, tokens: float64(rate.Burst), last: time.Now()}
+}
+
+// Wait takes a token for a service, waiting for it if needed
+func (l *Limiter) Wait(ctx context.Context, service string) error {
+
+	l.mu.Lock()
+	b, ok := l.buckets[service]
+	if !ok {
+		l.mu.Unlock()
+		return nil
+	}
+
+	// Refill and reserve a token, going into debt if there is none
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate.PerSecond
+	if b.tokens > float64(b.rate.Burst) {
+		b.tokens = float64(b.rate.Burst)
+	}
+	b.last = now
+	b.tokens--
+	wait := time.Duration(-b.tokens / b.rate.PerSecond * float64(time.Second))
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(wait)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		b.tokens++
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// retryable ...
+func (p *RetryPolicy) retryable(err error) bool {
+	if _, ok := err.(*permanentError); ok {
+		return false
+	}
+	return p.Retryable != nil && p.Retryable.MatchString(err.Error())
+}
+
+// backoff returns the wait before the next attempt
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.MinBackoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+// retry runs a step of the resource under the service rate limit until it
+// succeeds, fails with an error the retry policy does not match or runs out
+// of attempts.
+func (h *Handler) retry(ctx context.Context, step string, f func() error) error {
+
+	policy := h.Retry
+	if policy == nil {
+		policy = DefaultRetryPolicy
+	}
+
+	for attempt := 1; ; attempt++ {
+
+		if err := Limits.Wait(ctx, Service(h.ResourceType)); err != nil {
+			return err
+		}
+
+		err := f()
+		if err == nil || attempt >= policy.MaxAttempts || !policy.retryable(err) {
+			if perr, ok := err.(*permanentError); ok {
+				return perr.error
+			}
+			return err
+		}
+
+		backoff := policy.backoff(attempt)
+		h.emit(event.Event{Kind: event.Retrying, Reason: step, Attempt: attempt, Duration: backoff, Error: err.Error()})
+		h.log().Warn("Retrying", logger.F("step", step), logger.F("attempt", attempt), logger.F("backoff", backoff), logger.F("error", err.Error()))
+
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+// Helpers
+//-----------------------------------------------------------------------------
+
+// Service returns the rate limiting key of a resource type, the provider and
+// the service: aws_iam_role is limited as aws_iam.
+func Service(resourceType string) string {
+	parts := strings.SplitN(resourceType, "_", 3)
+	if len(parts) < 2 {
+		return resourceType
+	}
+	return parts[0] + "_" + parts[1]
+}
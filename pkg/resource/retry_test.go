@@ -0,0 +1,66 @@
+package resource
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter()
+	l.SetRate("aws_iam", Rate{PerSecond: 20, Burst: 2})
+	ctx := context.Background()
+
+	// The burst is free, then tokens come at the rate
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := l.Wait(ctx, "aws_iam"); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Fatalf("too fast: %s", d)
+	}
+
+	// Other services are not limited
+	start = time.Now()
+	for i := 0; i < 100; i++ {
+		l.Wait(ctx, "aws_s3")
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Fatalf("too slow: %s", d)
+	}
+
+	// Waiting is cancelled with the context
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	l.SetRate("aws_iam", Rate{PerSecond: 0.1, Burst: 1})
+	l.Wait(ctx, "aws_iam")
+	if err := l.Wait(ctx, "aws_iam"); err != context.Canceled {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := &RetryPolicy{MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	for attempt, max := range map[int]time.Duration{1: 10, 2: 20, 3: 40, 4: 50, 10: 50} {
+		for i := 0; i < 100; i++ {
+			if d := p.backoff(attempt); d <= 0 || d > max*time.Millisecond {
+				t.Fatalf("attempt %d: bad: %s", attempt, d)
+			}
+		}
+	}
+}
+
+func TestService(t *testing.T) {
+	for resourceType, expected := range map[string]string{
+		"aws_iam_role":                   "aws_iam",
+		"aws_iam_role_policy_attachment": "aws_iam",
+		"mock_role":                      "mock_role",
+		"test":                           "test",
+	} {
+		if s := Service(resourceType); s != expected {
+			t.Fatalf("%s: bad: %s", resourceType, s)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package resource
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	// terraform
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+
+	// terramorph
+	"github.com/h0tbird/terramorph/pkg/logger"
+)
+
+//-----------------------------------------------------------------------------
+// Globals
+//-----------------------------------------------------------------------------
+
+// DefaultTimeout applies to operations without a timeout, as in Terraform
+const DefaultTimeout = 20 * time.Minute
+
+// TimeoutGrace is how long an operation is waited for once its context is
+// done. Providers that ignore the context are left running after it.
+var TimeoutGrace = 30 * time.Second
+
+//-----------------------------------------------------------------------------
+// Types
+//-----------------------------------------------------------------------------
+
+// TimeoutError is returned when an operation outlives its timeout
+type TimeoutError struct {
+	Operation string
+	Timeout   time.Duration
+}
+
+// abandonedError is returned for an operation still running after the grace
+// period. What it produces must not be read.
+type abandonedError struct {
+	err error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s did not complete within %s", e.Operation, e.Timeout)
+}
+
+func (e *abandonedError) Error() string {
+	return e.err.Error() + ", it was left running and its outcome is unknown"
+}
+
+// Unwrap ...
+func (e *abandonedError) Unwrap() error {
+	return e.err
+}
+
+//-----------------------------------------------------------------------------
+// Methods
+//-----------------------------------------------------------------------------
+
+// ConfigTimeouts parses the timeouts block of the ResourceConfig, like
+// "timeouts": {"create": "10m", "default": "5m"}, the way Terraform does.
+func (h *Handler) ConfigTimeouts() (map[string]time.Duration, error) {
+
+	timeouts := map[string]time.Duration{}
+
+	var blocks []map[string]interface{}
+	switch raw := h.ResourceConfig[schema.TimeoutsConfigKey].(type) {
+	case nil:
+		return timeouts, nil
+	case map[string]interface{}:
+		blocks = append(blocks, raw)
+	case []interface{}:
+		for _, b := range raw {
+			m, ok := b.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("timeouts must be a block")
+			}
+			blocks = append(blocks, m)
+		}
+	default:
+		return nil, fmt.Errorf("timeouts must be a block")
+	}
+
+	for _, b := range blocks {
+		keys := []string{}
+		for k := range b {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			switch k {
+			case schema.TimeoutCreate, schema.TimeoutRead, schema.TimeoutUpdate, schema.TimeoutDelete, schema.TimeoutDefault:
+			default:
+				return nil, fmt.Errorf("unsupported timeout %q", k)
+			}
+			s, ok := b[k].(string)
+			if !ok {
+				return nil, fmt.Errorf("timeout %q must be a duration like 10m", k)
+			}
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return nil, fmt.Errorf("timeout %q: %s", k, err)
+			}
+			timeouts[k] = d
+		}
+	}
+
+	return timeouts, nil
+}
+
+// timeout returns the timeout of an operation: the timeouts block of the
+// config, then the defaults of the resource type, then DefaultTimeout.
+func (h *Handler) timeout(op string, defaults *schema.ResourceTimeout) time.Duration {
+
+	timeouts, _ := h.ConfigTimeouts()
+	if d, ok := timeouts[op]; ok {
+		return d
+	}
+	if d, ok := timeouts[schema.TimeoutDefault]; ok {
+		return d
+	}
+
+	if defaults != nil {
+		d := map[string]*time.Duration{
+			schema.TimeoutCreate: defaults.Create,
+			schema.TimeoutRead:   defaults.Read,
+			schema.TimeoutUpdate: defaults.Update,
+			schema.TimeoutDelete: defaults.Delete,
+		}[op]
+		if d != nil {
+			return *d
+		}
+		if defaults.Default != nil {
+			return *defaults.Default
+		}
+	}
+
+	return DefaultTimeout
+}
+
+// deadline runs an operation within its timeout. The operation gets the
+// context with the deadline and is waited for, up to TimeoutGrace, once the
+// context is done so what it produced can be recorded. Providers that ignore
+// the context are left behind after that so the walk is not held up.
+func (h *Handler) deadline(ctx context.Context, op string, timeout time.Duration, f func(context.Context) error) error {
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- f(ctx) }()
+
+	var err error
+	left := false
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		grace := time.NewTimer(TimeoutGrace)
+		select {
+		case err = <-done:
+		case <-grace.C:
+			err, left = ctx.Err(), true
+		}
+		grace.Stop()
+	}
+
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		h.log().Warn("Timed out", logger.F("operation", op), logger.F("timeout", timeout), logger.F("leftRunning", left))
+		err = &TimeoutError{Operation: op, Timeout: timeout}
+	}
+	if left {
+		return &abandonedError{err}
+	}
+
+	return err
+}
+
+//-----------------------------------------------------------------------------
+// Helpers
+//-----------------------------------------------------------------------------
+
+// abandoned tells whether err left an operation running
+func abandoned(err error) bool {
+	_, ok := err.(*abandonedError)
+	return ok
+}
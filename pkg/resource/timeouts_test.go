@@ -0,0 +1,89 @@
+package resource
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestHandlerTimeout(t *testing.T) {
+	defaults := &schema.ResourceTimeout{
+		Create: schema.DefaultTimeout(5 * time.Minute),
+		Delete: schema.DefaultTimeout(time.Minute),
+	}
+
+	h := &Handler{ResourceConfig: map[string]interface{}{
+		"timeouts": []interface{}{
+			map[string]interface{}{"create": "10m", "default": "2m"},
+		},
+	}}
+
+	for op, expected := range map[string]time.Duration{
+		schema.TimeoutCreate: 10 * time.Minute,
+		schema.TimeoutDelete: 2 * time.Minute,
+		schema.TimeoutRead:   2 * time.Minute,
+	} {
+		if d := h.timeout(op, defaults); d != expected {
+			t.Fatalf("%s: bad: %s", op, d)
+		}
+	}
+
+	// Without a timeouts block the resource defaults apply
+	h.ResourceConfig = map[string]interface{}{}
+	if d := h.timeout(schema.TimeoutDelete, defaults); d != time.Minute {
+		t.Fatalf("bad: %s", d)
+	}
+	if d := h.timeout(schema.TimeoutUpdate, defaults); d != DefaultTimeout {
+		t.Fatalf("bad: %s", d)
+	}
+
+	// Malformed blocks
+	for _, timeouts := range []interface{}{
+		"10m",
+		map[string]interface{}{"create": "soon"},
+		map[string]interface{}{"import": "1m"},
+	} {
+		h.ResourceConfig["timeouts"] = timeouts
+		if _, err := h.ConfigTimeouts(); err == nil {
+			t.Fatalf("%#v: expected error", timeouts)
+		}
+	}
+}
+
+func TestHandlerDeadline(t *testing.T) {
+	h := &Handler{}
+	grace := TimeoutGrace
+	defer func() { TimeoutGrace = grace }()
+	TimeoutGrace = time.Second
+
+	// An operation cancelled by the deadline is waited for
+	finished := false
+	err := h.deadline(context.Background(), "create", 20*time.Millisecond, func(ctx context.Context) error {
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		finished = true
+		return ctx.Err()
+	})
+	if terr, ok := err.(*TimeoutError); !ok || terr.Operation != "create" || abandoned(err) {
+		t.Fatalf("bad: %#v", err)
+	}
+	if !finished {
+		t.Fatal("returned before the operation")
+	}
+
+	// One ignoring it is left running after the grace period
+	TimeoutGrace = 20 * time.Millisecond
+	release := make(chan struct{})
+	defer close(release)
+	err = h.deadline(context.Background(), "update", 20*time.Millisecond, func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	terr := (*TimeoutError)(nil)
+	if !abandoned(err) || !errors.As(err, &terr) || terr.Operation != "update" {
+		t.Fatalf("bad: %#v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package resource
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"context"
+	"fmt"
+	"strconv"
+
+	// terraform
+	"github.com/hashicorp/go-cty/cty/msgpack"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+//-----------------------------------------------------------------------------
+// Globals
+//-----------------------------------------------------------------------------
+
+// SchemaVersionKey is the InstanceState.Meta key holding the schema version
+const SchemaVersionKey = "schema_version"
+
+//-----------------------------------------------------------------------------
+// Functions
+//-----------------------------------------------------------------------------
+
+// SchemaVersion returns the schema version a state was written with
+func SchemaVersion(state *terraform.InstanceState) int {
+	switch v := state.Meta[SchemaVersionKey].(type) {
+	case string:
+		n, _ := strconv.Atoi(v)
+		return n
+	case float64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+// setSchemaVersion records the current schema version of the resource
+func setSchemaVersion(state *terraform.InstanceState, rp *schema.Resource) {
+	if state == nil {
+		return
+	}
+	if state.Meta == nil {
+		state.Meta = map[string]interface{}{}
+	}
+	state.Meta[SchemaVersionKey] = strconv.Itoa(rp.SchemaVersion)
+}
+
+// upgradeState runs the MigrateState and StateUpgraders of a resource on a
+// state written by an older schema, the same way Terraform does it through
+// the UpgradeResourceState RPC.
+func upgradeState(ctx context.Context, p *schema.Provider, resourceType string, state *terraform.InstanceState) (*terraform.InstanceState, error) {
+
+	rp := p.ResourcesMap[resourceType]
+
+	// The ID is an attribute for the upgraders
+	flatmap := map[string]string{}
+	for k, v := range state.Attributes {
+		flatmap[k] = v
+	}
+	flatmap["id"] = state.ID
+
+	resp, err := schema.NewGRPCProviderServer(p).UpgradeResourceState(ctx, &tfprotov5.UpgradeResourceStateRequest{
+		TypeName: resourceType,
+		Version:  int64(SchemaVersion(state)),
+		RawState: &tfprotov5.RawState{Flatmap: flatmap},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range resp.Diagnostics {
+		if d.Severity == tfprotov5.DiagnosticSeverityError {
+			return nil, fmt.Errorf("%s: %s", d.Summary, d.Detail)
+		}
+	}
+
+	if resp.UpgradedState == nil {
+		return state, nil
+	}
+
+	// Back to a flatmap state
+	val, err := msgpack.Unmarshal(resp.UpgradedState.MsgPack, rp.CoreConfigSchema().ImpliedType())
+	if err != nil {
+		return nil, err
+	}
+
+	upgraded, err := rp.ShimInstanceStateFromValue(val)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the meta data such as timeouts
+	upgraded.Meta = state.Meta
+	setSchemaVersion(upgraded, rp)
+
+	return upgraded, nil
+}
@@ -0,0 +1,80 @@
+package resource
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+func testUpgradeProvider() *schema.Provider {
+	return &schema.Provider{
+		ResourcesMap: map[string]*schema.Resource{
+			"test_role": {
+				SchemaVersion: 2,
+				Schema: map[string]*schema.Schema{
+					"full_name": {Type: schema.TypeString, Optional: true},
+				},
+
+				// v0 -> v1: name becomes title
+				MigrateState: func(v int, is *terraform.InstanceState, meta interface{}) (*terraform.InstanceState, error) {
+					is.Attributes["title"] = is.Attributes["name"]
+					delete(is.Attributes, "name")
+					return is, nil
+				},
+
+				// v1 -> v2: title becomes full_name
+				StateUpgraders: []schema.StateUpgrader{
+					{
+						Version: 1,
+						Type: cty.Object(map[string]cty.Type{
+							"id":    cty.String,
+							"title": cty.String,
+						}),
+						Upgrade: func(ctx context.Context, m map[string]interface{}, meta interface{}) (map[string]interface{}, error) {
+							m["full_name"] = m["title"]
+							delete(m, "title")
+							return m, nil
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestUpgradeState(t *testing.T) {
+	p := testUpgradeProvider()
+
+	cases := map[string]*terraform.InstanceState{
+		"v0": {
+			ID:         "nodes",
+			Attributes: map[string]string{"id": "nodes", "name": "nodes"},
+		},
+		"v1": {
+			ID:         "nodes",
+			Attributes: map[string]string{"id": "nodes", "title": "nodes"},
+			Meta:       map[string]interface{}{"schema_version": "1"},
+		},
+	}
+
+	for name, in := range cases {
+		t.Run(name, func(t *testing.T) {
+			out, err := upgradeState(context.Background(), p, "test_role", in)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if out.ID != "nodes" || out.Attributes["full_name"] != "nodes" {
+				t.Fatalf("bad: %#v", out)
+			}
+			if _, ok := out.Attributes["title"]; ok {
+				t.Fatalf("bad: %#v", out)
+			}
+			if SchemaVersion(out) != 2 {
+				t.Fatalf("bad: %#v", out.Meta)
+			}
+		})
+	}
+}
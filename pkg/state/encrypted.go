@@ -0,0 +1,360 @@
+package state
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	// community
+	"golang.org/x/crypto/scrypt"
+
+	// terramorph
+	"github.com/h0tbird/terramorph/pkg/resource"
+)
+
+//-----------------------------------------------------------------------------
+// Globals
+//-----------------------------------------------------------------------------
+
+const (
+	envelopeVersion = 1
+	keySize         = 32
+	saltSize        = 16
+)
+
+//-----------------------------------------------------------------------------
+// Types
+//-----------------------------------------------------------------------------
+
+// Encrypted wraps any resource.State backend and seals every document with
+// AES-GCM before handing it over. Each write uses a fresh data key which is
+// itself sealed with a key-encryption key (KEK) read from a key file or
+// derived from a passphrase.
+type Encrypted struct {
+	Backend resource.State
+
+	// AllowPlaintext reads the documents written before encryption was
+	// enabled. Off by default, so plaintext can not replace encrypted state.
+	AllowPlaintext bool
+
+	// Passphrase mode
+	passphrase []byte
+	salt       []byte
+
+	// Derived KEKs by salt
+	mu   sync.Mutex
+	keks map[string][]byte
+}
+
+// envelope is the on-disk form of an encrypted document
+type envelope struct {
+	Version    int    `json:"encrypted"`
+	Salt       []byte `json:"salt,omitempty"`
+	WrappedKey []byte `json:"wrapped_key"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+//-----------------------------------------------------------------------------
+// Constructors
+//-----------------------------------------------------------------------------
+
+// NewEncryptedWithKeyFile reads a hex encoded 256-bit KEK from path. The key
+// file is generated when it does not exist and there is no state yet, a
+// missing key for existing state is an error.
+func NewEncryptedWithKeyFile(b resource.State, path string) (*Encrypted, error) {
+
+	kek, err := readKeyFile(path)
+	if os.IsNotExist(err) {
+		if empty, lerr := isEmpty(b); lerr != nil || !empty {
+			return nil, fmt.Errorf("key file %s not found, the state was encrypted with another key", path)
+		}
+		kek, err = writeKeyFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Encrypted{
+		Backend: b,
+		keks:    map[string][]byte{"": kek},
+	}, nil
+}
+
+// NewEncryptedWithPassphrase derives the KEK from a passphrase using scrypt
+func NewEncryptedWithPassphrase(b resource.State, passphrase string) (*Encrypted, error) {
+
+	if passphrase == "" {
+		return nil, errors.New("empty state passphrase")
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	return &Encrypted{
+		Backend:    b,
+		passphrase: []byte(passphrase),
+		salt:       salt,
+		keks:       map[string][]byte{},
+	}, nil
+}
+
+//-----------------------------------------------------------------------------
+// Methods
+//-----------------------------------------------------------------------------
+
+// Read ...
+func (e *Encrypted) Read(logicalID string, state interface{}) error {
+	raw := json.RawMessage{}
+	if err := e.Backend.Read(logicalID, &raw); err != nil {
+		return err
+	}
+	return e.open(logicalID, raw, state)
+}
+
+// Write ...
+func (e *Encrypted) Write(logicalID string, state interface{}) error {
+	env, err := e.seal(logicalID, state)
+	if err != nil {
+		return err
+	}
+	return e.Backend.Write(logicalID, env)
+}
+
+// Versions ...
+func (e *Encrypted) Versions(logicalID string) ([]Version, error) {
+	h, ok := e.Backend.(History)
+	if !ok {
+		return nil, fmt.Errorf("%T does not keep history", e.Backend)
+	}
+	return h.Versions(logicalID)
+}
+
+// ReadVersion ...
+func (e *Encrypted) ReadVersion(logicalID string, version int, state interface{}) error {
+	h, ok := e.Backend.(History)
+	if !ok {
+		return fmt.Errorf("%T does not keep history", e.Backend)
+	}
+	raw := json.RawMessage{}
+	if err := h.ReadVersion(logicalID, version, &raw); err != nil {
+		return err
+	}
+	return e.open(logicalID, raw, state)
+}
+
+// List ...
+func (e *Encrypted) List() ([]string, error) {
+	b, ok := e.Backend.(Backend)
+	if !ok {
+		return nil, fmt.Errorf("%T can not list entries", e.Backend)
+	}
+	return b.List()
+}
+
+// Delete ...
+func (e *Encrypted) Delete(logicalID string) error {
+	b, ok := e.Backend.(Backend)
+	if !ok {
+		return fmt.Errorf("%T can not delete entries", e.Backend)
+	}
+	return b.Delete(logicalID)
+}
+
+//-----------------------------------------------------------------------------
+// Helpers
+//-----------------------------------------------------------------------------
+
+func (e *Encrypted) seal(logicalID string, state interface{}) (*envelope, error) {
+
+	// Marshal json
+	plaintext, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fresh data key
+	dek := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return nil, err
+	}
+
+	kek, err := e.kek(e.salt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Seal the data key and the document
+	wrapped, err := gcmSeal(kek, dek, []byte(logicalID))
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := gcmSeal(dek, plaintext, []byte(logicalID))
+	if err != nil {
+		return nil, err
+	}
+
+	return &envelope{
+		Version:    envelopeVersion,
+		Salt:       e.salt,
+		WrappedKey: wrapped,
+		Ciphertext: ciphertext,
+	}, nil
+}
+
+func (e *Encrypted) open(logicalID string, raw json.RawMessage, state interface{}) error {
+
+	// No document means no state
+	if len(raw) == 0 {
+		return nil
+	}
+
+	// Plaintext documents written before encryption was enabled
+	env := &envelope{}
+	if err := json.Unmarshal(raw, env); err != nil || env.Version == 0 {
+		if !e.AllowPlaintext {
+			return fmt.Errorf("the state of %s is not encrypted, allow plaintext to migrate it", logicalID)
+		}
+		return json.Unmarshal(raw, state)
+	}
+
+	if env.Version != envelopeVersion {
+		return fmt.Errorf("unsupported state envelope version %d for %s", env.Version, logicalID)
+	}
+
+	kek, err := e.kek(env.Salt)
+	if err != nil {
+		return err
+	}
+
+	// Unseal the data key and the document
+	dek, err := gcmOpen(kek, env.WrappedKey, []byte(logicalID))
+	if err != nil {
+		return fmt.Errorf("error decrypting the state of %s: %s", logicalID, err)
+	}
+
+	plaintext, err := gcmOpen(dek, env.Ciphertext, []byte(logicalID))
+	if err != nil {
+		return fmt.Errorf("error decrypting the state of %s: %s", logicalID, err)
+	}
+
+	// Unmarshal json
+	return json.Unmarshal(plaintext, state)
+}
+
+func (e *Encrypted) kek(salt []byte) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if kek, ok := e.keks[string(salt)]; ok {
+		return kek, nil
+	}
+
+	if e.passphrase == nil {
+		return nil, errors.New("state was encrypted with a passphrase but a key file was given")
+	}
+
+	kek, err := scrypt.Key(e.passphrase, salt, 1<<15, 8, 1, keySize)
+	if err != nil {
+		return nil, err
+	}
+
+	e.keks[string(salt)] = kek
+	return kek, nil
+}
+
+func gcmSeal(key, plaintext, data []byte) ([]byte, error) {
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, data), nil
+}
+
+func gcmOpen(key, ciphertext, data []byte) ([]byte, error) {
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, data)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// isEmpty tells whether b holds no state. Backends that can not list are
+// never taken for empty.
+func isEmpty(b resource.State) (bool, error) {
+	l, ok := b.(interface{ List() ([]string, error) })
+	if !ok {
+		return false, nil
+	}
+	ids, err := l.List()
+	return len(ids) == 0, err
+}
+
+func readKeyFile(path string) ([]byte, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != keySize {
+		return nil, fmt.Errorf("%s: expected a hex encoded %d-byte key", path, keySize)
+	}
+
+	return key, nil
+}
+
+func writeKeyFile(path string) ([]byte, error) {
+
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	return key, ioutil.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600)
+}
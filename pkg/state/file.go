@@ -0,0 +1,279 @@
+package state
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//-----------------------------------------------------------------------------
+// Globals
+//-----------------------------------------------------------------------------
+
+// DefaultRetention is the number of versions kept per logical ID
+const DefaultRetention = 10
+
+//-----------------------------------------------------------------------------
+// Types
+//-----------------------------------------------------------------------------
+
+// File stores one JSON document per logical ID and keeps a bounded
+// history of every version written.
+type File struct {
+	Dir       string
+	Retention int
+	mu        sync.Mutex
+}
+
+//-----------------------------------------------------------------------------
+// Constructor
+//-----------------------------------------------------------------------------
+
+// NewFile ...
+func NewFile(dir string) *File {
+	return &File{
+		Dir:       dir,
+		Retention: DefaultRetention,
+	}
+}
+
+//-----------------------------------------------------------------------------
+// Methods
+//-----------------------------------------------------------------------------
+
+// Read ...
+func (f *File) Read(logicalID string, state interface{}) error {
+	return readJSON(f.current(logicalID), state)
+}
+
+// Write ...
+func (f *File) Write(logicalID string, state interface{}) error {
+
+	// Marshal json
+	jsonBytes, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Make sure the history directory exists
+	if err := os.MkdirAll(f.history(logicalID), 0700); err != nil {
+		return err
+	}
+
+	// Writers in this process take turns so the current version is the last
+	// one recorded
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Sync the document to a temporary file
+	tmp, err := writeTemp(f.Dir, jsonBytes)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	// Record a new version. Linking fails if another writer took the
+	// number, then the next one is tried.
+	versions, err := f.Versions(logicalID)
+	if err != nil {
+		return err
+	}
+
+	next := 1
+	if len(versions) > 0 {
+		next = versions[len(versions)-1].Number + 1
+	}
+
+	for {
+		err := os.Link(tmp, f.version(logicalID, next))
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+		next++
+	}
+
+	// Replace the current version, a crash leaves the old or the new one
+	if err := os.Rename(tmp, f.current(logicalID)); err != nil {
+		return err
+	}
+	if err := syncDir(f.Dir); err != nil {
+		return err
+	}
+
+	// Enforce the retention
+	versions, err = f.Versions(logicalID)
+	if err != nil {
+		return err
+	}
+	return f.prune(logicalID, versions)
+}
+
+// Versions returns the stored versions of a logical ID, oldest first.
+func (f *File) Versions(logicalID string) ([]Version, error) {
+
+	entries, err := ioutil.ReadDir(f.history(logicalID))
+	if err != nil {
+
+		// No directory means no history
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	versions := []Version{}
+	for _, e := range entries {
+		n, err := strconv.Atoi(strings.TrimSuffix(e.Name(), ".json"))
+		if err != nil || e.IsDir() {
+			continue
+		}
+		versions = append(versions, Version{Number: n, Time: e.ModTime()})
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Number < versions[j].Number
+	})
+
+	return versions, nil
+}
+
+// ReadVersion ...
+func (f *File) ReadVersion(logicalID string, version int, state interface{}) error {
+	if _, err := os.Stat(f.version(logicalID, version)); err != nil {
+		if os.IsNotExist(err) {
+			return &VersionNotFoundError{LogicalID: logicalID, Version: version}
+		}
+		return err
+	}
+	return readJSON(f.version(logicalID, version), state)
+}
+
+// List returns the logical IDs with a current state, sorted.
+func (f *File) List() ([]string, error) {
+
+	entries, err := ioutil.ReadDir(f.Dir)
+	if err != nil {
+
+		// No directory means no state
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			ids = append(ids, strings.TrimSuffix(e.Name(), ".json"))
+		}
+	}
+
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Delete removes the current state of a logical ID. Its history is kept so
+// the entry can still be restored.
+func (f *File) Delete(logicalID string) error {
+	if err := os.Remove(f.current(logicalID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// Helpers
+//-----------------------------------------------------------------------------
+
+func (f *File) current(logicalID string) string {
+	return filepath.Join(f.Dir, logicalID+".json")
+}
+
+func (f *File) history(logicalID string) string {
+	return filepath.Join(f.Dir, "history", logicalID)
+}
+
+func (f *File) version(logicalID string, version int) string {
+	return filepath.Join(f.history(logicalID), strconv.Itoa(version)+".json")
+}
+
+func (f *File) prune(logicalID string, versions []Version) error {
+	if f.Retention <= 0 || len(versions) <= f.Retention {
+		return nil
+	}
+	for _, v := range versions[:len(versions)-f.Retention] {
+		if err := os.Remove(f.version(logicalID, v.Number)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTemp writes data to a new file in dir, synced to disk, and returns
+// its path.
+func writeTemp(dir string, data []byte) (string, error) {
+
+	tmp, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
+
+// syncDir flushes the entries of dir, a rename is durable after it.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func readJSON(path string, state interface{}) error {
+
+	// Open a file handler
+	f, err := os.Open(path)
+	if err != nil {
+
+		// No file means no state
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	// Unmarshal json
+	return json.NewDecoder(f).Decode(state)
+}
@@ -0,0 +1,360 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+func testFile(t *testing.T) *File {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return NewFile(dir)
+}
+
+func TestFileReadMissing(t *testing.T) {
+	f := testFile(t)
+
+	s := &terraform.InstanceState{}
+	if err := f.Read("Missing", s); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if s.ID != "" {
+		t.Fatalf("bad: %#v", s)
+	}
+}
+
+func TestFileHistory(t *testing.T) {
+	f := testFile(t)
+	f.Retention = 3
+
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		if err := f.Write("Role", &terraform.InstanceState{ID: id}); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	// Only the newest versions are kept
+	versions, err := f.Versions("Role")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	actual := []int{}
+	for _, v := range versions {
+		actual = append(actual, v.Number)
+	}
+	if expected := []int{3, 4, 5}; !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	// Pruned versions are gone
+	err = f.ReadVersion("Role", 1, &terraform.InstanceState{})
+	if _, ok := err.(*VersionNotFoundError); !ok {
+		t.Fatalf("bad: %#v", err)
+	}
+
+	// Restoring writes a new version
+	if err := Restore(f, "Role", 3); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	s := &terraform.InstanceState{}
+	if err := f.Read("Role", s); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if s.ID != "c" {
+		t.Fatalf("bad: %#v", s)
+	}
+
+	versions, _ = f.Versions("Role")
+	if last := versions[len(versions)-1].Number; last != 6 {
+		t.Fatalf("bad: %d", last)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := &terraform.InstanceState{
+		ID: "role",
+		Attributes: map[string]string{
+			"name":        "nodes",
+			"description": "old",
+			"path":        "/",
+		},
+	}
+
+	after := &terraform.InstanceState{
+		ID: "role",
+		Attributes: map[string]string{
+			"name":        "nodes",
+			"description": "new",
+			"arn":         "arn:aws:iam::0:role/nodes",
+		},
+	}
+
+	expected := []Change{
+		{Key: "arn", New: "arn:aws:iam::0:role/nodes", HasNew: true},
+		{Key: "description", Old: "old", New: "new", HasOld: true, HasNew: true},
+		{Key: "path", Old: "/", HasOld: true},
+	}
+
+	actual := Diff(before, after)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	actions := []string{}
+	for _, c := range actual {
+		actions = append(actions, c.Action())
+	}
+	if expected := []string{"add", "change", "remove"}; !reflect.DeepEqual(actions, expected) {
+		t.Fatalf("bad: %#v", actions)
+	}
+}
+
+func TestDiffEmptyValues(t *testing.T) {
+	before := &terraform.InstanceState{
+		ID: "role",
+		Attributes: map[string]string{
+			"description": "",
+			"path":        "/",
+			"tags.%":      "",
+		},
+	}
+
+	after := &terraform.InstanceState{
+		ID: "role",
+		Attributes: map[string]string{
+			"description": "nodes",
+			"path":        "",
+		},
+	}
+
+	actions := []string{}
+	for _, c := range Diff(before, after) {
+		actions = append(actions, c.Key+" "+c.Action())
+	}
+	if expected := []string{"description change", "path change", "tags.% remove"}; !reflect.DeepEqual(actions, expected) {
+		t.Fatalf("bad: %#v", actions)
+	}
+}
+
+func TestFileConcurrentWrites(t *testing.T) {
+	f := testFile(t)
+	f.Retention = 0
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := f.Write("Role", &terraform.InstanceState{ID: strconv.Itoa(i)}); err != nil {
+				t.Errorf("err: %s", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Every write got its own version
+	versions, err := f.Versions("Role")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(versions) != 20 || versions[19].Number != 20 {
+		t.Fatalf("bad: %#v", versions)
+	}
+
+	// The current version is the last one recorded
+	last, current := &terraform.InstanceState{}, &terraform.InstanceState{}
+	if err := f.ReadVersion("Role", 20, last); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := f.Read("Role", current); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if current.ID != last.ID {
+		t.Fatalf("bad: %s != %s", current.ID, last.ID)
+	}
+
+	// No temporary files are left behind
+	ids, err := f.List()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	entries, _ := ioutil.ReadDir(f.Dir)
+	if !reflect.DeepEqual(ids, []string{"Role"}) || len(entries) != 2 {
+		t.Fatalf("bad: %#v, %d entries", ids, len(entries))
+	}
+}
+
+func TestEncrypted(t *testing.T) {
+	f := testFile(t)
+
+	// A plaintext entry written before encryption was enabled
+	if err := f.Write("Legacy", &terraform.InstanceState{ID: "legacy"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	e, err := NewEncryptedWithPassphrase(f, "secret")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	in := &terraform.InstanceState{
+		ID:         "key",
+		Attributes: map[string]string{"secret": "wJalrXUtnFEMI"},
+	}
+	if err := e.Write("AccessKey", in); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Opaque on disk
+	data, err := ioutil.ReadFile(f.current("AccessKey"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if strings.Contains(string(data), "wJalrXUtnFEMI") {
+		t.Fatalf("plaintext on disk: %s", data)
+	}
+
+	// Plaintext is refused unless migrating
+	if err := e.Read("Legacy", &terraform.InstanceState{}); err == nil || !strings.Contains(err.Error(), "not encrypted") {
+		t.Fatalf("bad: %v", err)
+	}
+	e.AllowPlaintext = true
+
+	// Transparent to readers
+	for id, expected := range map[string]string{"AccessKey": "key", "Legacy": "legacy"} {
+		out := &terraform.InstanceState{}
+		if err := e.Read(id, out); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if out.ID != expected {
+			t.Fatalf("bad: %#v", out)
+		}
+	}
+
+	// Wrong passphrase
+	bad, _ := NewEncryptedWithPassphrase(f, "wrong")
+	if err := bad.Read("AccessKey", &terraform.InstanceState{}); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// A key file is generated for new state only
+	f = testFile(t)
+	k, err := NewEncryptedWithKeyFile(f, f.Dir+"/key")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := k.Write("AccessKey", in); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := NewEncryptedWithKeyFile(f, f.Dir+"/kye"); err == nil || !strings.Contains(err.Error(), "key file "+f.Dir+"/kye not found") {
+		t.Fatalf("bad: %v", err)
+	}
+	k, err = NewEncryptedWithKeyFile(f, f.Dir+"/key")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	out := &terraform.InstanceState{}
+	if err := k.Read("AccessKey", out); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if out.Attributes["secret"] != "wJalrXUtnFEMI" {
+		t.Fatalf("bad: %#v", out)
+	}
+}
+
+func TestMove(t *testing.T) {
+	f := testFile(t)
+
+	f.Write("NodesRole", &terraform.InstanceState{ID: "nodes"})
+	f.Write("Other", &terraform.InstanceState{ID: "other"})
+
+	if err := Move(f, "NodesRole", "Other"); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if err := Move(f, "NodesRole", "WorkersRole"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ids, err := f.List()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if expected := []string{"Other", "WorkersRole"}; !reflect.DeepEqual(ids, expected) {
+		t.Fatalf("bad: %#v", ids)
+	}
+
+	// The history of a removed entry is kept
+	if err := Restore(f, "NodesRole", 1); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestSensitive(t *testing.T) {
+	m := map[string]*schema.Schema{
+		"name":   {Type: schema.TypeString},
+		"secret": {Type: schema.TypeString, Sensitive: true},
+		"keys": {
+			Type: schema.TypeList,
+			Elem: &schema.Resource{
+				Schema: map[string]*schema.Schema{
+					"id":       {Type: schema.TypeString},
+					"password": {Type: schema.TypeString, Sensitive: true},
+				},
+			},
+		},
+		"tokens": {Type: schema.TypeSet, Elem: &schema.Schema{Type: schema.TypeString, Sensitive: true}},
+	}
+
+	cases := map[string]bool{
+		"name":              false,
+		"secret":            true,
+		"keys.#":            false,
+		"keys.0.id":         false,
+		"keys.0.password":   true,
+		"tokens.#":          false,
+		"tokens.1234":       true,
+		"unknown":           false,
+		"keys.0.unknown.xx": false,
+	}
+
+	for k, expected := range cases {
+		if actual := Sensitive(m, k); actual != expected {
+			t.Fatalf("%s: bad: %t", k, actual)
+		}
+	}
+}
+
+func TestMask(t *testing.T) {
+	rp := &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"name":   {Type: schema.TypeString},
+			"secret": {Type: schema.TypeString, Sensitive: true},
+		},
+	}
+	attrs := map[string]string{"name": "nodes", "secret": "wJalrXUtnFEMI"}
+
+	expected := map[string]string{"name": "nodes", "secret": Masked}
+	if actual := Mask(rp, attrs); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	// Without a schema everything is masked
+	expected = map[string]string{"name": Masked, "secret": Masked}
+	if actual := Mask(nil, attrs); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
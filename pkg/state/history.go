@@ -0,0 +1,176 @@
+package state
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"fmt"
+	"sort"
+	"time"
+
+	// terraform
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+
+	// terramorph
+	"github.com/h0tbird/terramorph/pkg/resource"
+)
+
+//-----------------------------------------------------------------------------
+// Types
+//-----------------------------------------------------------------------------
+
+// History is implemented by backends that keep prior versions of each entry
+type History interface {
+	resource.State
+	Versions(string) ([]Version, error)
+	ReadVersion(string, int, interface{}) error
+}
+
+// Backend is implemented by stores that can also enumerate and remove entries
+type Backend interface {
+	History
+	List() ([]string, error)
+	Delete(string) error
+}
+
+// Version identifies a stored state version
+type Version struct {
+	Number int
+	Time   time.Time
+}
+
+// VersionNotFoundError ...
+type VersionNotFoundError struct {
+	LogicalID string
+	Version   int
+}
+
+func (e *VersionNotFoundError) Error() string {
+	return fmt.Sprintf("version %d of %s not found", e.Version, e.LogicalID)
+}
+
+// Change is a single attribute difference between two state versions.
+// HasOld and HasNew tell whether the attribute was present before and after,
+// an empty value is not an absent one.
+type Change struct {
+	Key    string
+	Old    string
+	New    string
+	HasOld bool
+	HasNew bool
+}
+
+// Action ...
+func (c Change) Action() string {
+	switch {
+	case !c.HasOld:
+		return "add"
+	case !c.HasNew:
+		return "remove"
+	default:
+		return "change"
+	}
+}
+
+//-----------------------------------------------------------------------------
+// Functions
+//-----------------------------------------------------------------------------
+
+// Restore writes a previous version of a resource as its current state
+func Restore(h History, logicalID string, version int) error {
+
+	// Read the requested version
+	state := &terraform.InstanceState{}
+	if err := h.ReadVersion(logicalID, version, state); err != nil {
+		return err
+	}
+
+	// Write it back as the newest version
+	return h.Write(logicalID, state)
+}
+
+// Move renames a logical ID without touching the remote resource
+func Move(b Backend, from, to string) error {
+
+	// Read the source
+	state := &terraform.InstanceState{}
+	if err := b.Read(from, state); err != nil {
+		return err
+	}
+	if state.ID == "" {
+		return fmt.Errorf("no state for %s", from)
+	}
+
+	// Refuse to overwrite
+	existing := &terraform.InstanceState{}
+	if err := b.Read(to, existing); err != nil {
+		return err
+	}
+	if existing.ID != "" {
+		return fmt.Errorf("%s already has state", to)
+	}
+
+	// Write the destination first so a failure never loses state
+	if err := b.Write(to, state); err != nil {
+		return err
+	}
+	return b.Delete(from)
+}
+
+// DiffVersions compares two stored versions of a resource
+func DiffVersions(h History, logicalID string, from, to int) ([]Change, error) {
+
+	before := &terraform.InstanceState{}
+	if err := h.ReadVersion(logicalID, from, before); err != nil {
+		return nil, err
+	}
+
+	after := &terraform.InstanceState{}
+	if err := h.ReadVersion(logicalID, to, after); err != nil {
+		return nil, err
+	}
+
+	return Diff(before, after), nil
+}
+
+// Diff compares two instance states attribute-by-attribute
+func Diff(before, after *terraform.InstanceState) []Change {
+
+	// Nil means no state
+	if before == nil {
+		before = &terraform.InstanceState{}
+	}
+	if after == nil {
+		after = &terraform.InstanceState{}
+	}
+
+	changes := []Change{}
+
+	// The ID is not part of the attributes
+	if before.ID != after.ID {
+		changes = append(changes, Change{Key: "id", Old: before.ID, New: after.ID, HasOld: before.ID != "", HasNew: after.ID != ""})
+	}
+
+	// Changed and removed attributes
+	for k, v := range before.Attributes {
+		if nv, ok := after.Attributes[k]; !ok || nv != v {
+			changes = append(changes, Change{Key: k, Old: v, New: nv, HasOld: true, HasNew: ok})
+		}
+	}
+
+	// Added attributes
+	for k, v := range after.Attributes {
+		if _, ok := before.Attributes[k]; !ok {
+			changes = append(changes, Change{Key: k, New: v, HasNew: true})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+
+	return changes
+}
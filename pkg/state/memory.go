@@ -0,0 +1,79 @@
+package state
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"encoding/json"
+	"sort"
+	"sync"
+)
+
+//-----------------------------------------------------------------------------
+// Types
+//-----------------------------------------------------------------------------
+
+// Memory keeps the JSON documents in memory. It has no history and is meant
+// for tests and throwaway runs.
+type Memory struct {
+	mu     sync.Mutex
+	states map[string][]byte
+}
+
+//-----------------------------------------------------------------------------
+// Constructor
+//-----------------------------------------------------------------------------
+
+// NewMemory ...
+func NewMemory() *Memory {
+	return &Memory{states: map[string][]byte{}}
+}
+
+//-----------------------------------------------------------------------------
+// Methods
+//-----------------------------------------------------------------------------
+
+// Read ...
+func (m *Memory) Read(logicalID string, state interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if data, ok := m.states[logicalID]; ok {
+		return json.Unmarshal(data, state)
+	}
+	return nil
+}
+
+// Write ...
+func (m *Memory) Write(logicalID string, state interface{}) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.states[logicalID] = data
+	return nil
+}
+
+// List returns the logical IDs with a state, sorted
+func (m *Memory) List() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := []string{}
+	for id := range m.states {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Delete ...
+func (m *Memory) Delete(logicalID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.states, logicalID)
+	return nil
+}
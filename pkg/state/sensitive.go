@@ -0,0 +1,84 @@
+package state
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"strings"
+
+	// terraform
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+//-----------------------------------------------------------------------------
+// Globals
+//-----------------------------------------------------------------------------
+
+// Masked replaces the value of sensitive attributes
+const Masked = "(sensitive value)"
+
+//-----------------------------------------------------------------------------
+// Functions
+//-----------------------------------------------------------------------------
+
+// Mask returns a copy of flatmap attributes with the values of every
+// attribute marked as sensitive in the resource schema replaced. Without a
+// schema nothing is known to be safe and every value is replaced.
+func Mask(rp *schema.Resource, attrs map[string]string) map[string]string {
+	masked := map[string]string{}
+	for k, v := range attrs {
+		if rp == nil || Sensitive(rp.Schema, k) {
+			v = Masked
+		}
+		masked[k] = v
+	}
+	return masked
+}
+
+// Sensitive reports whether a flatmap key such as "access_key.0.secret"
+// addresses a sensitive attribute. Collection counts are never sensitive.
+func Sensitive(m map[string]*schema.Schema, key string) bool {
+
+	parts := strings.Split(key, ".")
+	for i := 0; i < len(parts); i++ {
+
+		s, ok := m[parts[i]]
+		if !ok {
+			return false
+		}
+
+		// Counts of lists, sets and maps
+		if i+1 < len(parts) && (parts[i+1] == "#" || parts[i+1] == "%") {
+			return false
+		}
+
+		if s.Sensitive {
+			return true
+		}
+
+		switch s.Type {
+		case schema.TypeList, schema.TypeSet:
+			switch elem := s.Elem.(type) {
+			case *schema.Resource:
+				m = elem.Schema
+				i++
+			case *schema.Schema:
+				return elem.Sensitive
+			default:
+				return false
+			}
+		case schema.TypeMap:
+			if elem, ok := s.Elem.(*schema.Schema); ok {
+				return elem.Sensitive
+			}
+			return false
+		default:
+			return false
+		}
+	}
+
+	return false
+}
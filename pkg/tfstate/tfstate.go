@@ -0,0 +1,385 @@
+package tfstate
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	// community
+	uuid "github.com/hashicorp/go-uuid"
+
+	// terraform
+	"github.com/hashicorp/go-cty/cty"
+	ctyjson "github.com/hashicorp/go-cty/cty/json"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+
+	// terramorph
+	"github.com/h0tbird/terramorph/pkg/provider"
+	"github.com/h0tbird/terramorph/pkg/resource"
+)
+
+//-----------------------------------------------------------------------------
+// Globals
+//-----------------------------------------------------------------------------
+
+const (
+	stateVersion     = 4
+	terraformVersion = "0.14.2"
+	modeManaged      = "managed"
+)
+
+//-----------------------------------------------------------------------------
+// Types
+//-----------------------------------------------------------------------------
+
+// File is the JSON state format version 4 written by Terraform >= 0.12
+type File struct {
+	Version          int                        `json:"version"`
+	TerraformVersion string                     `json:"terraform_version"`
+	Serial           uint64                     `json:"serial"`
+	Lineage          string                     `json:"lineage"`
+	Outputs          map[string]json.RawMessage `json:"outputs"`
+	Resources        []Resource                 `json:"resources"`
+}
+
+// Resource ...
+type Resource struct {
+	Module    string     `json:"module,omitempty"`
+	Mode      string     `json:"mode"`
+	Type      string     `json:"type"`
+	Name      string     `json:"name"`
+	Provider  string     `json:"provider"`
+	Instances []Instance `json:"instances"`
+}
+
+// Instance ...
+type Instance struct {
+	SchemaVersion  uint64            `json:"schema_version"`
+	Attributes     json.RawMessage   `json:"attributes,omitempty"`
+	AttributesFlat map[string]string `json:"attributes_flat,omitempty"`
+	Private        []byte            `json:"private,omitempty"`
+	Dependencies   []string          `json:"dependencies,omitempty"`
+}
+
+// Schema is the schema of a resource type and the name of the provider
+// serving it. Resource is set for in-process providers only, plugins give
+// the implied type and version of their schema.
+type Schema struct {
+	Provider string
+	Resource *schema.Resource
+	Type     cty.Type
+	Version  int64
+}
+
+// Schemas resolves the schema of the type of a resource
+type Schemas func(r *resource.Handler) (*Schema, error)
+
+// RegistrySchemas resolves types through the in-process providers of reg
+func RegistrySchemas(reg *provider.Registry) Schemas {
+	return func(r *resource.Handler) (*Schema, error) {
+		name, _ := reg.Route(r.ResourceType)
+		rp, ok := reg.Resource(r.ResourceType)
+		if !ok {
+			return nil, fmt.Errorf("unknown resource type %s", r.ResourceType)
+		}
+		return &Schema{
+			Provider: name,
+			Resource: rp,
+			Type:     rp.CoreConfigSchema().ImpliedType(),
+			Version:  int64(rp.SchemaVersion),
+		}, nil
+	}
+}
+
+//-----------------------------------------------------------------------------
+// Export
+//-----------------------------------------------------------------------------
+
+// Export builds a Terraform state from the stored state of the given
+// resources. Resources without stored state are left out. The state is the
+// next one of prev, the file it replaces: its lineage is kept and its serial
+// incremented. Without prev a new lineage is started. Outputs are not
+// exported.
+func Export(schemas Schemas, s resource.State, resources []*resource.Handler, prev *File) (*File, error) {
+
+	f := &File{
+		Version:          stateVersion,
+		TerraformVersion: terraformVersion,
+		Serial:           1,
+		Outputs:          map[string]json.RawMessage{},
+		Resources:        []Resource{},
+	}
+
+	if prev != nil && prev.Lineage != "" {
+		f.Lineage, f.Serial = prev.Lineage, prev.Serial+1
+	} else {
+		lineage, err := uuid.GenerateUUID()
+		if err != nil {
+			return nil, err
+		}
+		f.Lineage = lineage
+	}
+
+	for _, h := range resources {
+
+		// Read the stored state
+		is := &terraform.InstanceState{}
+		if err := s.Read(h.ResourceLogicalID, is); err != nil {
+			return nil, err
+		}
+
+		if is.ID == "" {
+			continue
+		}
+
+		sch, err := schemas(h)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", h.ResourceLogicalID, err)
+		}
+
+		i, err := exportInstance(sch, is)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", h.ResourceLogicalID, err)
+		}
+
+		f.Resources = append(f.Resources, Resource{
+			Mode:      modeManaged,
+			Type:      h.ResourceType,
+			Name:      Name(h.ResourceLogicalID),
+			Provider:  providerAddr(sch.Provider),
+			Instances: []Instance{*i},
+		})
+	}
+
+	// Terraform sorts resources by address
+	sort.Slice(f.Resources, func(i, j int) bool {
+		return address(f.Resources[i]) < address(f.Resources[j])
+	})
+
+	return f, nil
+}
+
+func exportInstance(sch *Schema, is *terraform.InstanceState) (*Instance, error) {
+
+	// The ID is an attribute for Terraform
+	attrs := map[string]string{}
+	for k, v := range is.Attributes {
+		attrs[k] = v
+	}
+	attrs["id"] = is.ID
+
+	// Flatmap to nested JSON
+	val, err := (&terraform.InstanceState{ID: is.ID, Attributes: attrs}).AttrsAsObjectValue(sch.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	js, err := ctyjson.Marshal(val, sch.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	// Meta goes into the private blob
+	i := &Instance{
+		SchemaVersion: uint64(resource.SchemaVersion(is)),
+		Attributes:    js,
+	}
+
+	// The type is already in the address
+	meta := map[string]interface{}{}
+	for k, v := range is.Meta {
+		if k != resource.TypeKey {
+			meta[k] = v
+		}
+	}
+	if len(meta) > 0 {
+		if i.Private, err = json.Marshal(meta); err != nil {
+			return nil, err
+		}
+	}
+
+	return i, nil
+}
+
+//-----------------------------------------------------------------------------
+// Import
+//-----------------------------------------------------------------------------
+
+// Import writes every managed resource of a Terraform state into s. Resource
+// addresses are matched against the given handlers and fall back to the
+// logical ID derived from the resource name. Nothing is written when two
+// addresses get the same logical ID, or when a logical ID already has state
+// and force is not set. Resources of child modules are refused. The
+// imported logical IDs are returned.
+func Import(schemas Schemas, s resource.State, f *File, resources []*resource.Handler, force bool) ([]string, error) {
+
+	if f.Version != stateVersion {
+		return nil, fmt.Errorf("unsupported state version %d", f.Version)
+	}
+
+	// Address to handler
+	handlers := map[string]*resource.Handler{}
+	for _, h := range resources {
+		handlers[h.ResourceType+"."+Name(h.ResourceLogicalID)] = h
+	}
+
+	// Convert every instance before writing any
+	imported := []string{}
+	states := map[string]*terraform.InstanceState{}
+	addresses := map[string]string{}
+	for _, r := range f.Resources {
+
+		if r.Mode != modeManaged {
+			continue
+		}
+
+		if r.Module != "" {
+			return nil, fmt.Errorf("%s: resources of child modules are not supported", address(r))
+		}
+
+		if len(r.Instances) != 1 {
+			return nil, fmt.Errorf("%s: count and for_each are not supported", address(r))
+		}
+
+		h, ok := handlers[address(r)]
+		if !ok {
+			h = &resource.Handler{ResourceLogicalID: LogicalID(r.Name), ResourceType: r.Type}
+		}
+		id := h.ResourceLogicalID
+		if !resource.LogicalIDReg.MatchString(id) {
+			return nil, fmt.Errorf("%s: invalid logical ID %q", address(r), id)
+		}
+
+		sch, err := schemas(h)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", address(r), err)
+		}
+
+		is, err := importInstance(sch, &r.Instances[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", address(r), err)
+		}
+		is.Meta[resource.TypeKey] = r.Type
+
+		if other, ok := addresses[id]; ok {
+			return nil, fmt.Errorf("%s and %s both import as %s, declare them in the manifest to tell them apart", other, address(r), id)
+		}
+
+		if !force {
+			existing := &terraform.InstanceState{}
+			if err := s.Read(id, existing); err != nil {
+				return nil, err
+			}
+			if existing.ID != "" {
+				return nil, fmt.Errorf("%s: %s already has state, import with force to overwrite it", address(r), id)
+			}
+		}
+
+		addresses[id] = address(r)
+		states[id] = is
+		imported = append(imported, id)
+	}
+
+	for i, id := range imported {
+		if err := s.Write(id, states[id]); err != nil {
+			return imported[:i], err
+		}
+	}
+
+	return imported, nil
+}
+
+func importInstance(sch *Schema, i *Instance) (*terraform.InstanceState, error) {
+
+	is := &terraform.InstanceState{}
+
+	switch {
+
+	// Nested JSON to flatmap
+	case len(i.Attributes) > 0:
+		val, err := ctyjson.Unmarshal(i.Attributes, sch.Type)
+		if err != nil {
+			return nil, err
+		}
+		if sch.Resource == nil {
+			is = terraform.NewInstanceStateShimmedFromValue(val, int(sch.Version))
+		} else if is, err = sch.Resource.ShimInstanceStateFromValue(val); err != nil {
+			return nil, err
+		}
+
+	// Legacy flatmap
+	case i.AttributesFlat != nil:
+		is.ID = i.AttributesFlat["id"]
+		is.Attributes = i.AttributesFlat
+	}
+
+	// Private blob back into Meta
+	if len(i.Private) > 0 {
+		if err := json.Unmarshal(i.Private, &is.Meta); err != nil {
+			return nil, fmt.Errorf("error decoding private data: %s", err)
+		}
+	}
+
+	if is.Meta == nil {
+		is.Meta = map[string]interface{}{}
+	}
+	is.Meta[resource.SchemaVersionKey] = strconv.FormatUint(i.SchemaVersion, 10)
+
+	return is, nil
+}
+
+//-----------------------------------------------------------------------------
+// Addresses
+//-----------------------------------------------------------------------------
+
+// Name converts a logical ID into a Terraform resource name:
+// NodesRole becomes nodes_role.
+func Name(logicalID string) string {
+	r := []rune(logicalID)
+	b := strings.Builder{}
+	for i, c := range r {
+		if unicode.IsUpper(c) {
+			if i > 0 && (unicode.IsLower(r[i-1]) || unicode.IsDigit(r[i-1]) ||
+				(i+1 < len(r) && unicode.IsLower(r[i+1]))) {
+				b.WriteRune('_')
+			}
+			c = unicode.ToLower(c)
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// LogicalID converts a Terraform resource name into a logical ID:
+// nodes_role becomes NodesRole.
+func LogicalID(name string) string {
+	b := strings.Builder{}
+	for _, part := range strings.Split(name, "_") {
+		if part == "" {
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+func address(r Resource) string {
+	if r.Module != "" {
+		return r.Module + "." + r.Type + "." + r.Name
+	}
+	return r.Type + "." + r.Name
+}
+
+func providerAddr(name string) string {
+	return fmt.Sprintf("provider[\"registry.terraform.io/hashicorp/%s\"]", name)
+}
@@ -0,0 +1,292 @@
+package tfstate
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+
+	"github.com/h0tbird/terramorph/pkg/provider"
+	"github.com/h0tbird/terramorph/pkg/resource"
+	"github.com/h0tbird/terramorph/pkg/state"
+)
+
+func testRegistry() *provider.Registry {
+	reg := provider.NewRegistry()
+	reg.Register("test", testProvider)
+	return reg
+}
+
+func testProvider() *schema.Provider {
+	return &schema.Provider{
+		ResourcesMap: map[string]*schema.Resource{
+			"test_role": {
+				SchemaVersion: 1,
+				Schema: map[string]*schema.Schema{
+					"name": {Type: schema.TypeString, Required: true},
+					"tags": {Type: schema.TypeMap, Optional: true, Elem: &schema.Schema{Type: schema.TypeString}},
+					"arns": {Type: schema.TypeList, Optional: true, Elem: &schema.Schema{Type: schema.TypeString}},
+				},
+			},
+			"test_policy": {
+				Schema: map[string]*schema.Schema{
+					"name": {Type: schema.TypeString, Required: true},
+				},
+			},
+		},
+	}
+}
+
+func TestName(t *testing.T) {
+	cases := map[string]string{
+		"NodesRole":                        "nodes_role",
+		"NodesRoleToNodesPolicyAttachment": "nodes_role_to_nodes_policy_attachment",
+		"IAMRole":                          "iam_role",
+		"Role2":                            "role2",
+	}
+
+	for in, expected := range cases {
+		if actual := Name(in); actual != expected {
+			t.Fatalf("%s: bad: %s", in, actual)
+		}
+	}
+
+	if actual := LogicalID("nodes_role"); actual != "NodesRole" {
+		t.Fatalf("bad: %s", actual)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	p := RegistrySchemas(testRegistry())
+	s := state.NewMemory()
+
+	in := &terraform.InstanceState{
+		ID: "nodes",
+		Attributes: map[string]string{
+			"id":        "nodes",
+			"name":      "nodes",
+			"tags.%":    "1",
+			"tags.team": "infra",
+			"arns.#":    "2",
+			"arns.0":    "arn:a",
+			"arns.1":    "arn:b",
+		},
+		Meta: map[string]interface{}{
+			"schema_version": "1",
+		},
+	}
+	s.Write("NodesRole", in)
+
+	resources := []*resource.Handler{
+		{ResourceLogicalID: "NodesRole", ResourceType: "test_role"},
+		{ResourceLogicalID: "Missing", ResourceType: "test_role"},
+	}
+
+	f, err := Export(p, s, resources, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(f.Resources) != 1 {
+		t.Fatalf("bad: %#v", f.Resources)
+	}
+
+	r := f.Resources[0]
+	if address(r) != "test_role.nodes_role" || r.Instances[0].SchemaVersion != 1 {
+		t.Fatalf("bad: %#v", r)
+	}
+
+	attrs := map[string]interface{}{}
+	if err := json.Unmarshal(r.Instances[0].Attributes, &attrs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(attrs["arns"], []interface{}{"arn:a", "arn:b"}) {
+		t.Fatalf("bad: %#v", attrs)
+	}
+
+	// Round trip through JSON into an empty store
+	data, err := json.Marshal(f)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	f = &File{}
+	if err := json.Unmarshal(data, f); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	s2 := state.NewMemory()
+	ids, err := Import(p, s2, f, resources, false)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(ids, []string{"NodesRole"}) {
+		t.Fatalf("bad: %#v", ids)
+	}
+
+	out := &terraform.InstanceState{}
+	s2.Read("NodesRole", out)
+	if out.ID != in.ID || !reflect.DeepEqual(out.Attributes, in.Attributes) {
+		t.Fatalf("bad: %#v", out)
+	}
+	if out.Meta["schema_version"] != "1" || resource.StateType(out) != "test_role" {
+		t.Fatalf("bad: %#v", out.Meta)
+	}
+}
+
+func TestExportLineage(t *testing.T) {
+	p := RegistrySchemas(testRegistry())
+	s := state.NewMemory()
+	s.Write("NodesRole", &terraform.InstanceState{ID: "nodes", Attributes: map[string]string{"name": "nodes"}})
+	resources := []*resource.Handler{{ResourceLogicalID: "NodesRole", ResourceType: "test_role"}}
+
+	first, err := Export(p, s, resources, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if first.Lineage == "" || first.Serial != 1 {
+		t.Fatalf("bad: %s %d", first.Lineage, first.Serial)
+	}
+
+	// The next export keeps the lineage
+	next, err := Export(p, s, resources, first)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if next.Lineage != first.Lineage || next.Serial != 2 {
+		t.Fatalf("bad: %s %d", next.Lineage, next.Serial)
+	}
+}
+
+func TestImportConflicts(t *testing.T) {
+	p := RegistrySchemas(testRegistry())
+
+	instance := func(name string) []Instance {
+		return []Instance{{Attributes: json.RawMessage(`{"id": "` + name + `", "name": "` + name + `"}`)}}
+	}
+
+	f := &File{
+		Version: stateVersion,
+		Resources: []Resource{
+			{Mode: modeManaged, Type: "test_role", Name: "nodes", Instances: instance("role")},
+			{Mode: modeManaged, Type: "test_policy", Name: "nodes", Instances: instance("policy")},
+		},
+	}
+
+	// Two addresses with the same fallback logical ID
+	s := state.NewMemory()
+	if _, err := Import(p, s, f, nil, false); err == nil || !strings.Contains(err.Error(), "both import as Nodes") {
+		t.Fatalf("bad: %v", err)
+	}
+	if ids, _ := s.List(); len(ids) != 0 {
+		t.Fatalf("bad: %#v", ids)
+	}
+
+	// Declared in the manifest they are told apart
+	resources := []*resource.Handler{
+		{ResourceLogicalID: "Nodes", ResourceType: "test_role"},
+		{ResourceLogicalID: "NodesPolicy", ResourceType: "test_policy"},
+	}
+	f.Resources[1].Name = "nodes_policy"
+	ids, err := Import(p, s, f, resources, false)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(ids, []string{"Nodes", "NodesPolicy"}) {
+		t.Fatalf("bad: %#v", ids)
+	}
+
+	// Existing state is only overwritten with force
+	f.Resources[0].Instances = instance("other")
+	if _, err := Import(p, s, f, resources, false); err == nil || !strings.Contains(err.Error(), "already has state") {
+		t.Fatalf("bad: %v", err)
+	}
+	is := &terraform.InstanceState{}
+	s.Read("Nodes", is)
+	if is.ID != "role" {
+		t.Fatalf("bad: %#v", is)
+	}
+
+	if _, err := Import(p, s, f, resources, true); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	s.Read("Nodes", is)
+	if is.ID != "other" {
+		t.Fatalf("bad: %#v", is)
+	}
+}
+
+func TestImportModules(t *testing.T) {
+	f := &File{
+		Version: stateVersion,
+		Resources: []Resource{
+			{Module: "module.iam", Mode: modeManaged, Type: "test_role", Name: "nodes", Instances: []Instance{{Attributes: json.RawMessage(`{"id": "nodes", "name": "nodes"}`)}}},
+		},
+	}
+
+	// Child modules are refused rather than imported at the root
+	s := state.NewMemory()
+	if _, err := Import(RegistrySchemas(testRegistry()), s, f, nil, false); err == nil || !strings.Contains(err.Error(), "module.iam.test_role.nodes: resources of child modules") {
+		t.Fatalf("bad: %v", err)
+	}
+	if ids, _ := s.List(); len(ids) != 0 {
+		t.Fatalf("bad: %#v", ids)
+	}
+}
+
+func TestImportNames(t *testing.T) {
+	f := &File{
+		Version: stateVersion,
+		Resources: []Resource{
+			{Mode: modeManaged, Type: "test_role", Name: "../nodes", Instances: []Instance{{Attributes: json.RawMessage(`{"id": "nodes", "name": "nodes"}`)}}},
+		},
+	}
+
+	// Names are not taken as paths
+	s := state.NewMemory()
+	if _, err := Import(RegistrySchemas(testRegistry()), s, f, nil, false); err == nil || !strings.Contains(err.Error(), `invalid logical ID "../nodes"`) {
+		t.Fatalf("bad: %v", err)
+	}
+	if ids, _ := s.List(); len(ids) != 0 {
+		t.Fatalf("bad: %#v", ids)
+	}
+}
+
+func TestPluginSchemas(t *testing.T) {
+
+	// A plugin only reports the implied type of its schema
+	rp := testProvider().ResourcesMap["test_role"]
+	p := func(r *resource.Handler) (*Schema, error) {
+		return &Schema{Provider: "plugin", Type: rp.CoreConfigSchema().ImpliedType(), Version: 1}, nil
+	}
+
+	s := state.NewMemory()
+	s.Write("NodesRole", &terraform.InstanceState{ID: "nodes", Attributes: map[string]string{
+		"id":        "nodes",
+		"name":      "nodes",
+		"tags.%":    "1",
+		"tags.team": "infra",
+	}})
+	resources := []*resource.Handler{{ResourceLogicalID: "NodesRole", ResourceType: "test_role"}}
+
+	f, err := Export(p, s, resources, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if r := f.Resources[0]; r.Provider != `provider["registry.terraform.io/hashicorp/plugin"]` {
+		t.Fatalf("bad: %#v", r)
+	}
+
+	s2 := state.NewMemory()
+	if _, err := Import(p, s2, f, resources, false); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	out := &terraform.InstanceState{}
+	s2.Read("NodesRole", out)
+	if out.ID != "nodes" || out.Attributes["tags.team"] != "infra" || out.Attributes["name"] != "nodes" {
+		t.Fatalf("bad: %#v", out)
+	}
+}
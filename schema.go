@@ -0,0 +1,143 @@
+package main
+
+//-----------------------------------------------------------------------------
+// Imports
+//-----------------------------------------------------------------------------
+
+import (
+
+	// stdlib
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	// terramorph
+	"github.com/h0tbird/terramorph/pkg/provider"
+)
+
+//-----------------------------------------------------------------------------
+// Schema command
+//-----------------------------------------------------------------------------
+
+const schemaUsage = `usage:
+  terramorph schema [-json]
+  terramorph schema [-json] <type>
+  terramorph schema [-json] data.<type>`
+
+// schemaCmd lists the types of the registered providers or dumps the schema
+// of one resource or data source type.
+func schemaCmd(w io.Writer, reg *provider.Registry, args []string) error {
+
+	asJSON := false
+	if len(args) > 0 && args[0] == "-json" {
+		asJSON, args = true, args[1:]
+	}
+
+	switch len(args) {
+	case 0:
+		types := reg.Types()
+		if asJSON {
+			return writeJSON(w, types)
+		}
+		for _, t := range types {
+			fmt.Fprintln(w, t)
+		}
+		return nil
+	case 1:
+	default:
+		return errors.New(schemaUsage)
+	}
+
+	d, err := reg.Describe(args[0])
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		return writeJSON(w, d)
+	}
+
+	fmt.Fprintf(w, "%s %s (provider %s, schema version %d)\n\n", d.Kind, d.Type, d.Provider, d.Version)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	writeBlock(tw, d.Block, "  ")
+	return tw.Flush()
+}
+
+//-----------------------------------------------------------------------------
+// Helpers
+//-----------------------------------------------------------------------------
+
+func writeBlock(w io.Writer, b *provider.BlockDescription, indent string) {
+
+	for _, name := range sortedKeys(b.Attributes) {
+		a := b.Attributes[name]
+		flags := schemaFlags(a.Required, a.Optional, a.Computed, a.ForceNew, a.Sensitive)
+		fmt.Fprintf(w, "%s%s\t%s\t%s", indent, name, a.Type, flags)
+		writeDescription(w, a.Description)
+	}
+
+	for _, name := range sortedKeys(b.BlockTypes) {
+		nb := b.BlockTypes[name]
+		flags := schemaFlags(nb.Required, nb.Optional, nb.Computed, nb.ForceNew, false)
+		if nb.MinItems > 0 || nb.MaxItems > 0 {
+			flags += fmt.Sprintf(", items %d..%d", nb.MinItems, nb.MaxItems)
+		}
+		fmt.Fprintf(w, "%s%s\tblock %s\t%s", indent, name, nb.Nesting, flags)
+		writeDescription(w, nb.Description)
+		writeBlock(w, nb.Block, indent+"  ")
+	}
+}
+
+// writeDescription ends a row, the description column is left out when empty
+// to keep the padding off the line.
+func writeDescription(w io.Writer, description string) {
+	if description != "" {
+		fmt.Fprintf(w, "\t%s", description)
+	}
+	fmt.Fprintln(w)
+}
+
+func schemaFlags(required, optional, computed, forceNew, sensitive bool) string {
+	flags := []string{}
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"required", required},
+		{"optional", optional},
+		{"computed", computed},
+		{"force new", forceNew},
+		{"sensitive", sensitive},
+	} {
+		if f.set {
+			flags = append(flags, f.name)
+		}
+	}
+	return strings.Join(flags, ", ")
+}
+
+func sortedKeys(m interface{}) []string {
+	keys := []string{}
+	switch m := m.(type) {
+	case map[string]*provider.AttributeDescription:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]*provider.NestedBlockDescription:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -7,52 +7,408 @@ package main
 import (
 
 	// stdlib
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	// terraform
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+
+	// terramorph
+	"github.com/h0tbird/terramorph/pkg/manifest"
+	"github.com/h0tbird/terramorph/pkg/provider"
+	"github.com/h0tbird/terramorph/pkg/resource"
+	"github.com/h0tbird/terramorph/pkg/state"
+	"github.com/h0tbird/terramorph/pkg/tfstate"
 )
 
 //-----------------------------------------------------------------------------
-// State implementation
+// State backend
 //-----------------------------------------------------------------------------
 
-type state struct{}
+// newState returns the file backend, encrypted at rest when a key file or a
+// passphrase is found in the environment. Plaintext state is only read, to
+// migrate it, with TERRAMORPH_STATE_ALLOW_PLAINTEXT set.
+func newState(dir string) (state.Backend, error) {
 
-func (s *state) Read(logicalID string, state interface{}) error {
+	f := state.NewFile(dir)
 
-	// Open a file handler
-	f, err := os.Open(os.Getenv("HOME") + "/.terramorph/" + logicalID + ".json")
+	var e *state.Encrypted
+	var err error
+	switch {
+	case os.Getenv("TERRAMORPH_STATE_KEY_FILE") != "":
+		e, err = state.NewEncryptedWithKeyFile(f, os.Getenv("TERRAMORPH_STATE_KEY_FILE"))
+	case os.Getenv("TERRAMORPH_STATE_PASSPHRASE") != "":
+		e, err = state.NewEncryptedWithPassphrase(f, os.Getenv("TERRAMORPH_STATE_PASSPHRASE"))
+	default:
+		return f, nil
+	}
 	if err != nil {
+		return nil, err
+	}
+
+	e.AllowPlaintext = os.Getenv("TERRAMORPH_STATE_ALLOW_PLAINTEXT") != ""
+	return e, nil
+}
+
+//-----------------------------------------------------------------------------
+// State commands
+//-----------------------------------------------------------------------------
 
-		// No file means no state
-		if os.IsNotExist(err) {
-			return nil
+const stateUsage = `usage:
+  terramorph state list
+  terramorph state show <logicalID>
+  terramorph state rm <logicalID>
+  terramorph state mv <from> <to>
+  terramorph state history <logicalID>
+  terramorph state diff <logicalID> <from> <to>
+  terramorph state restore <logicalID> <version>
+  terramorph state import [-force] <terraform.tfstate>
+  terramorph state export [terraform.tfstate]`
+
+func stateCmd(w, ew io.Writer, s state.Backend, m *manifest.Handler, reg *provider.Registry, args []string) error {
+
+	if len(args) == 0 {
+		return errors.New(stateUsage)
+	}
+
+	switch args[0] {
+	case "list":
+		if len(args) != 1 {
+			return errors.New(stateUsage)
+		}
+		return stateList(w, s, m)
+	case "show":
+		if len(args) != 2 {
+			return errors.New(stateUsage)
+		}
+		if err := checkLogicalIDs(args[1]); err != nil {
+			return err
+		}
+		return stateShow(w, s, m, reg, args[1])
+	case "rm":
+		if len(args) != 2 {
+			return errors.New(stateUsage)
+		}
+		if err := checkLogicalIDs(args[1]); err != nil {
+			return err
 		}
+		return s.Delete(args[1])
+	case "mv":
+		if len(args) != 3 {
+			return errors.New(stateUsage)
+		}
+		if err := checkLogicalIDs(args[1], args[2]); err != nil {
+			return err
+		}
+		return state.Move(s, args[1], args[2])
+	case "history":
+		if len(args) != 2 {
+			return errors.New(stateUsage)
+		}
+		if err := checkLogicalIDs(args[1]); err != nil {
+			return err
+		}
+		return stateHistory(w, s, args[1])
+	case "diff":
+		if len(args) != 4 {
+			return errors.New(stateUsage)
+		}
+		if err := checkLogicalIDs(args[1]); err != nil {
+			return err
+		}
+		from, to, err := atoi2(args[2], args[3])
+		if err != nil {
+			return err
+		}
+		return stateDiff(w, s, args[1], from, to)
+	case "restore":
+		if len(args) != 3 {
+			return errors.New(stateUsage)
+		}
+		if err := checkLogicalIDs(args[1]); err != nil {
+			return err
+		}
+		v, err := strconv.Atoi(args[2])
+		if err != nil {
+			return err
+		}
+		return state.Restore(s, args[1], v)
+	case "import":
+		force := len(args) == 3 && args[1] == "-force"
+		if len(args) != 2 && !force {
+			return errors.New(stateUsage)
+		}
+		return stateImport(w, s, m, reg, args[len(args)-1], force)
+	case "export":
+		if len(args) > 2 {
+			return errors.New(stateUsage)
+		}
+		if len(args) == 2 {
+			return stateExportFile(ew, s, m, reg, args[1])
+		}
+		return stateExport(w, ew, s, m, reg, nil)
+	}
+
+	return errors.New(stateUsage)
+}
+
+func stateList(w io.Writer, s state.Backend, m *manifest.Handler) error {
+
+	ids, err := s.List()
+	if err != nil {
+		return err
+	}
+
+	types := resourceTypes(m)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "LOGICAL ID\tTYPE\tID")
+	for _, id := range ids {
+		is := &terraform.InstanceState{}
+		if err := s.Read(id, is); err != nil {
+			return err
+		}
+		t := stateType(is, types[id])
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", id, t, is.ID)
+	}
+
+	return tw.Flush()
+}
+
+func stateShow(w io.Writer, s state.Backend, m *manifest.Handler, reg *provider.Registry, logicalID string) error {
+
+	is := &terraform.InstanceState{}
+	if err := s.Read(logicalID, is); err != nil {
 		return err
 	}
-	defer f.Close()
+	if is.ID == "" {
+		return fmt.Errorf("no state for %s", logicalID)
+	}
+
+	// Sensitive values are masked, all of them for unknown types
+	t := stateType(is, resourceTypes(m)[logicalID])
+	rp, _ := reg.Resource(t)
+	attrs := state.Mask(rp, is.Attributes)
+
+	keys := []string{}
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
 
-	// Unmarshal json
-	return json.NewDecoder(f).Decode(state)
+	tw := tabwriter.NewWriter(w, 0, 4, 1, ' ', 0)
+	fmt.Fprintf(tw, "# %s (%s)\n", logicalID, t)
+	fmt.Fprintf(tw, "id\t= %q\n", is.ID)
+	for _, k := range keys {
+		fmt.Fprintf(tw, "%s\t= %q\n", k, attrs[k])
+	}
+
+	return tw.Flush()
 }
 
-func (s *state) Write(logicalID string, state interface{}) error {
+func stateHistory(w io.Writer, s state.Backend, logicalID string) error {
 
-	// Open a file handler
-	f, err := os.Create(os.Getenv("HOME") + "/.terramorph/" + logicalID + ".json")
+	versions, err := s.Versions(logicalID)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	// Marshal json
-	jsonBytes, err := json.MarshalIndent(state, "", "  ")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tWRITTEN")
+	for _, v := range versions {
+		fmt.Fprintf(tw, "%d\t%s\n", v.Number, v.Time.Format(time.RFC3339))
+	}
+
+	return tw.Flush()
+}
+
+func stateDiff(w io.Writer, s state.Backend, logicalID string, from, to int) error {
+
+	changes, err := state.DiffVersions(s, logicalID, from, to)
 	if err != nil {
 		return err
 	}
 
-	// Write to disk
-	_, err = io.Copy(f, bytes.NewReader(jsonBytes))
+	for _, c := range changes {
+		switch c.Action() {
+		case "add":
+			fmt.Fprintf(w, "+ %s: %q\n", c.Key, c.New)
+		case "remove":
+			fmt.Fprintf(w, "- %s: %q\n", c.Key, c.Old)
+		default:
+			fmt.Fprintf(w, "~ %s: %q => %q\n", c.Key, c.Old, c.New)
+		}
+	}
+
+	return nil
+}
+
+func stateImport(w io.Writer, s state.Backend, m *manifest.Handler, reg *provider.Registry, path string, force bool) error {
+
+	f, err := readTFState(path)
+	if err != nil {
+		return err
+	}
+
+	ids, err := tfstate.Import(stateSchemas(m, reg), s, f, handlers(m), force)
+	for _, id := range ids {
+		fmt.Fprintf(w, "imported %s\n", id)
+	}
+
 	return err
 }
+
+// stateExport writes the Terraform state of the manifest to w and warns on
+// ew about the outputs left out of it.
+func stateExport(w, ew io.Writer, s state.Backend, m *manifest.Handler, reg *provider.Registry, prev *tfstate.File) error {
+
+	f, err := tfstate.Export(stateSchemas(m, reg), s, handlers(m), prev)
+	if err != nil {
+		return err
+	}
+
+	outputs := map[string]bool{}
+	for name := range m.Outputs {
+		outputs[name] = true
+	}
+	if prev != nil {
+		for name := range prev.Outputs {
+			outputs[name] = true
+		}
+	}
+	if len(outputs) > 0 {
+		names := []string{}
+		for name := range outputs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(ew, "Warning: Outputs are not exported\n\n  The Terraform state has no outputs, %s left out.\n\n", strings.Join(names, ", "))
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(f)
+}
+
+// stateExportFile exports to path as the next state of the file already
+// there. The export goes to a temporary file renamed into place, a failure
+// leaves the old file untouched.
+func stateExportFile(ew io.Writer, s state.Backend, m *manifest.Handler, reg *provider.Registry, path string) error {
+
+	var prev *tfstate.File
+	if _, err := os.Stat(path); err == nil {
+		if prev, err = readTFState(path); err != nil {
+			return err
+		}
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := stateExport(tmp, ew, s, m, reg, prev); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func readTFState(path string) (*tfstate.File, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &tfstate.File{}
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return f, nil
+}
+
+// checkLogicalIDs refuses the arguments state can not be stored under
+func checkLogicalIDs(ids ...string) error {
+	for _, id := range ids {
+		if !resource.LogicalIDReg.MatchString(id) {
+			return fmt.Errorf("invalid logical ID %q", id)
+		}
+	}
+	return nil
+}
+
+// stateSchemas resolves resource types through the provider routing of the
+// manifest, out-of-process providers included
+func stateSchemas(m *manifest.Handler, reg *provider.Registry) tfstate.Schemas {
+	return func(r *resource.Handler) (*tfstate.Schema, error) {
+		name, rp, rs, err := m.ResourceSchema(context.Background(), reg, r)
+		if err != nil {
+			return nil, err
+		}
+		if rp != nil {
+			return &tfstate.Schema{Provider: name, Resource: rp, Type: rp.CoreConfigSchema().ImpliedType(), Version: int64(rp.SchemaVersion)}, nil
+		}
+		return &tfstate.Schema{Provider: name, Type: rs.Block.ImpliedType(), Version: rs.Version}, nil
+	}
+}
+
+func handlers(m *manifest.Handler) []*resource.Handler {
+	list := []*resource.Handler{}
+	for _, h := range m.Resources {
+		list = append(list, h)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ResourceLogicalID < list[j].ResourceLogicalID
+	})
+	return list
+}
+
+func resourceTypes(m *manifest.Handler) map[string]string {
+	types := map[string]string{}
+	for _, h := range m.Resources {
+		types[h.ResourceLogicalID] = h.ResourceType
+	}
+	return types
+}
+
+// stateType returns the type recorded in the state, then the one declared in
+// the manifest, or "-" when neither is known
+func stateType(is *terraform.InstanceState, declared string) string {
+	if t := resource.StateType(is); t != "" {
+		return t
+	}
+	if declared != "" {
+		return declared
+	}
+	return "-"
+}
+
+func atoi2(a, b string) (int, int, error) {
+	x, err := strconv.Atoi(a)
+	if err != nil {
+		return 0, 0, err
+	}
+	y, err := strconv.Atoi(b)
+	return x, y, err
+}